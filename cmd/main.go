@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	talk_together_app "github.com/firstproject/talk-together-app/hub"
 	"github.com/firstproject/talk-together-app/pkg/handler"
 	"github.com/firstproject/talk-together-app/pkg/kafka"
 	"github.com/firstproject/talk-together-app/pkg/redis"
 	"github.com/firstproject/talk-together-app/pkg/repository"
 	"github.com/firstproject/talk-together-app/pkg/service"
+	"github.com/firstproject/talk-together-app/pkg/storage"
 	"github.com/firstproject/talk-together-app/server"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -64,19 +67,84 @@ func main() {
 	}
 
 	hub := talk_together_app.NewHub()
+	if viper.GetBool("hub.cluster") {
+		hub = talk_together_app.NewClusterHub(redisClient.NewRoomPubSub())
+	}
 	go hub.Run()
 
 	kafkaConsumer, err := kafka.NewKafkaConsumer(
 		[]string{viper.GetString("kafka.brokers")},
 		viper.GetString("kafka.topic"),
+		viper.GetString("kafka.group_id"),
 		hub,
 	)
-	go kafkaConsumer.Start()
+	if err != nil {
+		logrus.Fatalf("Error initializing Kafka consumer: %s", err.Error())
+	}
+	go kafkaConsumer.Start(context.Background())
+
+	if os.Getenv("ATTACHMENT_SIGNING_KEY") == "" {
+		logrus.Fatal("ATTACHMENT_SIGNING_KEY is not set")
+	}
+
+	signingKeys, err := newKeySet()
+	if err != nil {
+		logrus.Fatalf("Error loading token signing keys: %s", err.Error())
+	}
+
+	oidcProviders, err := newOIDCProviders()
+	if err != nil {
+		logrus.Fatalf("Error loading SSO providers: %s", err.Error())
+	}
+
+	blobs, err := newBlobStore()
+	if err != nil {
+		logrus.Fatalf("Error initializing blob storage: %s", err.Error())
+	}
 
 	repos := repository.NewRepository(db)
-	services := service.NewService(repos, redisClient, kafkaProducer)
+	services := service.NewService(repos, redisClient, kafkaProducer, blobs, service.Config{
+		Attachments: service.AttachmentConfig{
+			MaxSize:    viper.GetInt64("attachments.max_size"),
+			ChunkSize:  viper.GetInt64("attachments.chunk_size"),
+			URLTTL:     viper.GetDuration("attachments.url_ttl"),
+			UnusedTTL:  viper.GetDuration("attachments.unused_ttl"),
+			SigningKey: []byte(os.Getenv("ATTACHMENT_SIGNING_KEY")),
+		},
+		Passwords: service.PasswordConfig{
+			Memory:      viper.GetUint32("auth.password.memory"),
+			Iterations:  viper.GetUint32("auth.password.iterations"),
+			Parallelism: uint8(viper.GetUint("auth.password.parallelism")),
+			SaltLength:  viper.GetUint32("auth.password.salt_length"),
+			KeyLength:   viper.GetUint32("auth.password.key_length"),
+		},
+		Tokens: service.TokenConfig{
+			AccessTTL:  viper.GetDuration("auth.access_ttl"),
+			RefreshTTL: viper.GetDuration("auth.refresh_ttl"),
+		},
+		TwoFactor: service.TwoFactorConfig{
+			Issuer:        viper.GetString("auth.two_factor.issuer"),
+			ChallengeTTL:  viper.GetDuration("auth.two_factor.challenge_ttl"),
+			MaxAttempts:   viper.GetInt("auth.two_factor.max_attempts"),
+			LockoutWindow: viper.GetDuration("auth.two_factor.lockout_window"),
+		},
+		SigningKeys: signingKeys,
+		OIDC:        oidcProviders,
+	})
+
+	outboxRelay := service.NewOutboxRelay(
+		repos.Outbox,
+		kafkaProducer,
+		viper.GetDuration("outbox.poll_interval"),
+		viper.GetInt("outbox.batch_size"),
+	)
+	go outboxRelay.Run(context.Background())
+	go services.Attachment.RunCleanup(context.Background())
 	handlers := handler.NewHandler(services, hub)
 
+	go handlers.RunPresenceSweeper(context.Background())
+	go handlers.RunSessionRevocationListener(context.Background())
+
 	srv := new(server.Server)
 	if err := srv.Run(viper.GetString("port"), handlers.InitRoutes()); err != nil {
 		logrus.Fatalf("error starting server: %s", err.Error())
@@ -85,6 +153,102 @@ func main() {
 	//services repos, redisClient, kafkaProducer
 }
 
+// Загружает ключи подписи токенов из auth.jwt.keys
+// Секреты HS256 берутся из переменных окружения, ключи RS256 и EdDSA - из PEM файлов
+func newKeySet() (*service.KeySet, error) {
+	var keys []struct {
+		Id             string `mapstructure:"id"`
+		Algorithm      string `mapstructure:"algorithm"`
+		SecretEnv      string `mapstructure:"secret_env"`
+		PrivateKeyFile string `mapstructure:"private_key_file"`
+		PublicKeyFile  string `mapstructure:"public_key_file"`
+	}
+	if err := viper.UnmarshalKey("auth.jwt.keys", &keys); err != nil {
+		return nil, err
+	}
+
+	configs := make([]service.SigningKeyConfig, 0, len(keys))
+	for _, key := range keys {
+		cfg := service.SigningKeyConfig{Id: key.Id, Algorithm: key.Algorithm}
+
+		if key.SecretEnv != "" {
+			cfg.Secret = []byte(os.Getenv(key.SecretEnv))
+		}
+
+		var err error
+		if key.PrivateKeyFile != "" {
+			if cfg.PrivateKeyPEM, err = os.ReadFile(key.PrivateKeyFile); err != nil {
+				return nil, err
+			}
+		}
+		if key.PublicKeyFile != "" {
+			if cfg.PublicKeyPEM, err = os.ReadFile(key.PublicKeyFile); err != nil {
+				return nil, err
+			}
+		}
+
+		configs = append(configs, cfg)
+	}
+
+	return service.NewKeySet(viper.GetString("auth.jwt.active_key"), configs)
+}
+
+// Загружает провайдеров единого входа из auth.oidc.providers, секрет клиента берется из переменной окружения
+func newOIDCProviders() ([]service.OIDCProviderConfig, error) {
+	var providers []struct {
+		Id              string   `mapstructure:"id"`
+		Name            string   `mapstructure:"name"`
+		Issuer          string   `mapstructure:"issuer"`
+		ClientId        string   `mapstructure:"client_id"`
+		ClientSecretEnv string   `mapstructure:"client_secret_env"`
+		RedirectURL     string   `mapstructure:"redirect_url"`
+		Scopes          []string `mapstructure:"scopes"`
+	}
+	if err := viper.UnmarshalKey("auth.oidc.providers", &providers); err != nil {
+		return nil, err
+	}
+
+	configs := make([]service.OIDCProviderConfig, 0, len(providers))
+	for _, provider := range providers {
+		cfg := service.OIDCProviderConfig{
+			Id:          provider.Id,
+			Name:        provider.Name,
+			Issuer:      provider.Issuer,
+			ClientId:    provider.ClientId,
+			RedirectURL: provider.RedirectURL,
+			Scopes:      provider.Scopes,
+		}
+		if provider.ClientSecretEnv != "" {
+			cfg.ClientSecret = os.Getenv(provider.ClientSecretEnv)
+		}
+
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+
+	return configs, nil
+}
+
+// Выбирает хранилище файлов по storage.backend: local или s3
+func newBlobStore() (storage.BlobStore, error) {
+	switch backend := viper.GetString("storage.backend"); backend {
+	case "local":
+		return storage.NewLocalStore(viper.GetString("storage.local_path"))
+	case "s3":
+		return storage.NewS3Store(storage.S3Config{
+			Endpoint:  viper.GetString("storage.s3.endpoint"),
+			Bucket:    viper.GetString("storage.s3.bucket"),
+			Region:    viper.GetString("storage.s3.region"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
 func initConfig() error {
 	viper.AddConfigPath("./configs")
 	viper.SetConfigName("config")
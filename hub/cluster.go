@@ -0,0 +1,17 @@
+package hub
+
+// Сообщение, полученное из межузловой шины для конкретной комнаты
+type ClusterMessage struct {
+	Room    int
+	Payload []byte
+}
+
+// Межузловая шина событий комнат.
+// Hub публикует в нее все события и подписывается только на комнаты,
+// в которых у текущего узла есть локальные клиенты
+type Cluster interface {
+	Publish(roomId int, payload []byte) error
+	Subscribe(roomId int) error
+	Unsubscribe(roomId int) error
+	Messages() <-chan ClusterMessage
+}
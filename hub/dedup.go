@@ -0,0 +1,40 @@
+package hub
+
+// Количество последних id событий, запоминаемых для каждой комнаты
+const dedupWindowSize = 512
+
+// Окно дедупликации: хранит id последних доставленных событий комнаты
+// Kafka и outbox гарантируют доставку как минимум один раз, поэтому повторы отбрасываются здесь
+type dedupWindow struct {
+	ids   map[int64]struct{}
+	ring  []int64
+	next  int
+	count int
+}
+
+func newDedupWindow(size int) *dedupWindow {
+	return &dedupWindow{
+		ids:  make(map[int64]struct{}, size),
+		ring: make([]int64, size),
+	}
+}
+
+// Возвращает true, если id уже был доставлен
+// Иначе запоминает его, вытесняя самый старый id при заполнении окна
+func (w *dedupWindow) seen(id int64) bool {
+	if _, exists := w.ids[id]; exists {
+		return true
+	}
+
+	if w.count == len(w.ring) {
+		delete(w.ids, w.ring[w.next])
+	} else {
+		w.count++
+	}
+
+	w.ring[w.next] = id
+	w.next = (w.next + 1) % len(w.ring)
+	w.ids[id] = struct{}{}
+
+	return false
+}
@@ -2,15 +2,20 @@ package hub
 
 import (
 	"github.com/firstproject/talk-together-app/model"
+	"github.com/goccy/go-json"
 	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
 	"net/http"
 	"sync"
+	"sync/atomic"
 )
 
 // Представляет команту с клиентами и обеспечивает потокобезопасность
+// Клиенты хранятся по номеру соединения: у одного пользователя их может быть несколько
 type RoomEntry struct {
 	Room    *model.Room
 	Clients map[int]*model.Client
+	recent  *dedupWindow
 	mu      sync.RWMutex
 }
 
@@ -21,7 +26,10 @@ type Hub struct {
 	Upgrader   websocket.Upgrader
 	Register   chan *model.Client
 	Unregister chan *model.Client
-	Broadcast  chan *model.Message
+	Broadcast  chan *model.Event
+	cluster    Cluster
+	typing     *typingTracker
+	clientSeq  atomic.Int64
 }
 
 // Создает и инициализирует новый экземпляр Hub
@@ -30,7 +38,8 @@ func NewHub() *Hub {
 		Rooms:      make(map[int]*RoomEntry),
 		Register:   make(chan *model.Client),
 		Unregister: make(chan *model.Client),
-		Broadcast:  make(chan *model.Message),
+		Broadcast:  make(chan *model.Event),
+		typing:     newTypingTracker(),
 		Upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true
@@ -39,17 +48,51 @@ func NewHub() *Hub {
 	}
 }
 
+// Выдает уникальный в пределах узла номер соединения для model.Client.Id
+func (h *Hub) NextClientId() int {
+	return int(h.clientSeq.Add(1))
+}
+
+// Создает Hub в кластерном режиме.
+// События публикуются в общую шину, а локальным клиентам доставляются
+// только из подписки, поэтому каждый узел отдает событие ровно один раз
+func NewClusterHub(cluster Cluster) *Hub {
+	h := NewHub()
+	h.cluster = cluster
+	return h
+}
+
 // Запускает основной цикл обработки событий Hub
-// Обрабатывает: регистрацию, отмену регистрации, broadcast сообщений
+// Обрабатывает: регистрацию, отмену регистрации, broadcast событий
+// Завершается, если один из каналов закрыт
 func (h *Hub) Run() {
+	var clusterMessages <-chan ClusterMessage
+	if h.cluster != nil {
+		clusterMessages = h.cluster.Messages()
+	}
+
 	for {
 		select {
-		case client := <-h.Register:
+		case client, ok := <-h.Register:
+			if !ok {
+				return
+			}
 			h.registerClient(client)
-		case client := <-h.Unregister:
+		case client, ok := <-h.Unregister:
+			if !ok {
+				return
+			}
 			h.unregisterClient(client)
-		case message := <-h.Broadcast:
-			h.broadcastMessage(message)
+		case event, ok := <-h.Broadcast:
+			if !ok {
+				return
+			}
+			h.broadcastEvent(event)
+		case message, ok := <-clusterMessages:
+			if !ok {
+				return
+			}
+			h.deliverLocal(message.Room, message.Payload)
 		}
 	}
 }
@@ -64,6 +107,7 @@ func (h *Hub) CreateRoom(room *model.Room) {
 		h.Rooms[room.Id] = &RoomEntry{
 			Room:    room,
 			Clients: make(map[int]*model.Client),
+			recent:  newDedupWindow(dedupWindowSize),
 		}
 	}
 }
@@ -95,7 +139,7 @@ func (h *Hub) RemoveRoom(roomId int) {
 		roomEntry.mu.Unlock()
 
 		delete(h.Rooms, roomId)
-
+		h.unsubscribeRoom(roomId)
 	}
 
 }
@@ -110,8 +154,14 @@ func (h *Hub) registerClient(client *model.Client) {
 	if _, exists := h.Rooms[client.Room]; !exists {
 		h.Rooms[client.Room] = &RoomEntry{
 			Clients: make(map[int]*model.Client),
+			recent:  newDedupWindow(dedupWindowSize),
 		}
 	}
+
+	if len(h.Rooms[client.Room].Clients) == 0 {
+		h.subscribeRoom(client.Room)
+	}
+
 	h.Rooms[client.Room].mu.Lock()
 	h.Rooms[client.Room].Clients[client.Id] = client
 	h.Rooms[client.Room].mu.Unlock()
@@ -126,32 +176,134 @@ func (h *Hub) unregisterClient(client *model.Client) {
 
 	if room, exists := h.Rooms[client.Room]; exists {
 		room.mu.Lock()
-		delete(room.Clients, client.Id)
-		close(client.Send)
+		// Клиент мог быть уже отключен при рассылке, канал закрыт повторно быть не должен
+		if current, ok := room.Clients[client.Id]; ok && current == client {
+			delete(room.Clients, client.Id)
+			close(client.Send)
+		}
 		room.mu.Unlock()
 
 		if len(room.Clients) == 0 {
 			delete(h.Rooms, client.Room)
+			h.unsubscribeRoom(client.Room)
 		}
 	}
 }
 
-func (h *Hub) broadcastMessage(message *model.Message) {
+// Сериализует событие в конверт и рассылает его всем клиентам комнаты
+// В кластерном режиме только публикует событие в шину
+// Внутренний метод, вызывается из Run()
+func (h *Hub) broadcastEvent(event *model.Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logrus.Errorf("failed to marshal %s event: %s", event.Type, err.Error())
+		return
+	}
+
+	if h.cluster != nil {
+		if err := h.cluster.Publish(event.Room, payload); err != nil {
+			logrus.Errorf("failed to publish %s event to room %d: %s", event.Type, event.Room, err.Error())
+		}
+		return
+	}
+
+	h.deliverLocal(event.Room, payload)
+}
+
+// Отправляет готовый кадр всем локальным клиентам комнаты
+// Повторно доставленные события outbox отбрасываются окном дедупликации по id события,
+// события набора текста не отправляются их автору
+// Медленные клиенты с переполненным буфером отключаются
+func (h *Hub) deliverLocal(roomId int, payload []byte) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	if room, exists := h.Rooms[message.Room]; exists {
-		room.mu.RLock()
-		defer room.mu.RUnlock()
+	if room, exists := h.Rooms[roomId]; exists {
+		room.mu.Lock()
+		defer room.mu.Unlock()
+
+		event, _ := model.ParseEvent(payload)
+		if event != nil && event.Id != 0 && room.recent.seen(event.Id) {
+			return
+		}
+
+		author, skipAuthor := typingAuthor(event)
 
 		for _, client := range room.Clients {
+			if skipAuthor && client.User == author {
+				continue
+			}
+
 			select {
-			case client.Send <- []byte(message.Content):
+			case client.Send <- payload:
 			default:
 				close(client.Send)
 				delete(room.Clients, client.Id)
 			}
 		}
+
+		// Покинувший комнату пользователь получает событие последним, после чего его соединения закрываются
+		if userId, ok := leftMember(event); ok {
+			for _, client := range room.Clients {
+				if client.User == userId {
+					close(client.Send)
+					delete(room.Clients, client.Id)
+				}
+			}
+		}
+	}
+}
+
+// Отключает локальные соединения отозванной сессии во всех комнатах
+func (h *Hub) DisconnectSession(sessionId string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, room := range h.Rooms {
+		room.mu.Lock()
+		for id, client := range room.Clients {
+			if client.Session == sessionId {
+				close(client.Send)
+				delete(room.Clients, id)
+			}
+		}
+		room.mu.Unlock()
+	}
+}
+
+// Возвращает пользователя, покинувшего комнату, для события member.left
+func leftMember(event *model.Event) (int, bool) {
+	if event == nil || event.Type != model.EventMemberLeft {
+		return 0, false
+	}
+
+	var member model.RoomMember
+	if err := event.Decode(&member); err != nil {
+		return 0, false
+	}
+
+	return member.User, true
+}
+
+// Подписывает узел на события комнаты в кластерном режиме
+func (h *Hub) subscribeRoom(roomId int) {
+	if h.cluster == nil {
+		return
+	}
+
+	if err := h.cluster.Subscribe(roomId); err != nil {
+		logrus.Errorf("failed to subscribe to room %d: %s", roomId, err.Error())
+	}
+}
+
+// Отписывает узел от событий комнаты в кластерном режиме
+func (h *Hub) unsubscribeRoom(roomId int) {
+	if h.cluster == nil {
+		return
+	}
+
+	if err := h.cluster.Unsubscribe(roomId); err != nil {
+		logrus.Errorf("failed to unsubscribe from room %d: %s", roomId, err.Error())
 	}
 }
 
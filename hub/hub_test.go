@@ -5,6 +5,7 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"sync"
 	"testing"
 	"time"
 )
@@ -80,19 +81,31 @@ func TestHub_BroadcastMessage(t *testing.T) {
 	hub.Register <- client
 	time.Sleep(10 * time.Millisecond)
 
-	message := &model.Message{
+	message := model.Message{
 		Id:      1,
 		Room:    1,
 		User:    1,
 		Content: "test message",
 	}
 
-	hub.Broadcast <- message
+	event, err := model.NewEvent(model.EventMessageCreated, message.Room, message)
+	assert.NoError(t, err)
+
+	hub.Broadcast <- event
 	time.Sleep(10 * time.Millisecond)
 
 	select {
 	case recivedMsg := <-client.Send:
-		assert.Equal(t, "test message", string(recivedMsg))
+		recived, err := model.ParseEvent(recivedMsg)
+		assert.NoError(t, err)
+		assert.Equal(t, model.EventMessageCreated, recived.Type)
+		assert.Equal(t, model.EventVersion, recived.V)
+
+		var data model.Message
+		assert.NoError(t, recived.Decode(&data))
+		assert.Equal(t, message.Id, data.Id)
+		assert.Equal(t, message.User, data.User)
+		assert.Equal(t, "test message", data.Content)
 	case <-time.After(100 * time.Millisecond):
 		t.Error("Message was not send to client")
 	}
@@ -153,3 +166,337 @@ func TestHub_CreateRoom(t *testing.T) {
 	assert.Contains(t, hub.Rooms, 1)
 	assert.Equal(t, room, hub.Rooms[1].Room)
 }
+
+func TestHub_BroadcastOtherRoom(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	client := &model.Client{
+		Id:   1,
+		Conn: &websocket.Conn{},
+		Room: 1,
+		User: 1,
+		Send: make(chan []byte, 10),
+	}
+
+	hub.Register <- client
+	time.Sleep(10 * time.Millisecond)
+
+	event, err := model.NewEvent(model.EventMessageDeleted, 2, model.MessageDeletedPayload{Id: 1, Room: 2})
+	assert.NoError(t, err)
+
+	hub.Broadcast <- event
+	time.Sleep(10 * time.Millisecond)
+
+	select {
+	case <-client.Send:
+		t.Error("Event from another room was delivered")
+	default:
+	}
+}
+
+func TestParseEvent_UnsupportedVersion(t *testing.T) {
+	_, err := model.ParseEvent([]byte(`{"type":"message.create","v":2,"data":{"content":"hi"}}`))
+	assert.Error(t, err)
+
+	event, err := model.ParseEvent([]byte(`{"type":"message.create","v":1,"data":{"content":"hi"}}`))
+	assert.NoError(t, err)
+
+	var input model.CreateMessageInput
+	assert.NoError(t, event.Decode(&input))
+	assert.Equal(t, "hi", input.Content)
+}
+
+// Общая шина в памяти, имитирующая Redis Pub/Sub между узлами
+type fakeBus struct {
+	mu    sync.Mutex
+	nodes []*fakeCluster
+}
+
+type fakeCluster struct {
+	bus      *fakeBus
+	mu       sync.Mutex
+	rooms    map[int]bool
+	messages chan ClusterMessage
+}
+
+func (b *fakeBus) node() *fakeCluster {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	node := &fakeCluster{bus: b, rooms: make(map[int]bool), messages: make(chan ClusterMessage, 10)}
+	b.nodes = append(b.nodes, node)
+	return node
+}
+
+func (c *fakeCluster) Publish(roomId int, payload []byte) error {
+	c.bus.mu.Lock()
+	defer c.bus.mu.Unlock()
+
+	for _, node := range c.bus.nodes {
+		node.mu.Lock()
+		if node.rooms[roomId] {
+			node.messages <- ClusterMessage{Room: roomId, Payload: payload}
+		}
+		node.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *fakeCluster) Subscribe(roomId int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rooms[roomId] = true
+	return nil
+}
+
+func (c *fakeCluster) Unsubscribe(roomId int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.rooms, roomId)
+	return nil
+}
+
+func (c *fakeCluster) Messages() <-chan ClusterMessage {
+	return c.messages
+}
+
+func TestHub_ClusterBroadcast(t *testing.T) {
+	bus := &fakeBus{}
+	nodeA, nodeB, nodeC := bus.node(), bus.node(), bus.node()
+
+	hubA, hubB, hubC := NewClusterHub(nodeA), NewClusterHub(nodeB), NewClusterHub(nodeC)
+	go hubA.Run()
+	go hubB.Run()
+	go hubC.Run()
+
+	clientA := &model.Client{Id: 1, Conn: &websocket.Conn{}, Room: 1, User: 1, Send: make(chan []byte, 10)}
+	clientB := &model.Client{Id: 2, Conn: &websocket.Conn{}, Room: 1, User: 2, Send: make(chan []byte, 10)}
+
+	hubA.Register <- clientA
+	hubB.Register <- clientB
+	time.Sleep(10 * time.Millisecond)
+
+	nodeC.mu.Lock()
+	assert.False(t, nodeC.rooms[1], "node without local clients must not subscribe")
+	nodeC.mu.Unlock()
+
+	event, err := model.NewEvent(model.EventMessageCreated, 1, model.Message{Id: 1, Room: 1, User: 1, Content: "hi"})
+	assert.NoError(t, err)
+
+	hubA.Broadcast <- event
+	time.Sleep(20 * time.Millisecond)
+
+	assert.Len(t, clientA.Send, 1)
+	assert.Len(t, clientB.Send, 1)
+
+	hubB.Unregister <- clientB
+	time.Sleep(10 * time.Millisecond)
+
+	nodeB.mu.Lock()
+	assert.False(t, nodeB.rooms[1], "node must unsubscribe when the room is empty")
+	nodeB.mu.Unlock()
+}
+
+func TestHub_BroadcastDeduplicatesMessages(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	client := &model.Client{
+		Id:   1,
+		Conn: &websocket.Conn{},
+		Room: 1,
+		User: 1,
+		Send: make(chan []byte, 10),
+	}
+
+	hub.Register <- client
+	time.Sleep(10 * time.Millisecond)
+
+	message := model.Message{Id: 7, Room: 1, User: 1, Content: "once"}
+	created, err := model.NewEvent(model.EventMessageCreated, 1, message)
+	assert.NoError(t, err)
+	updated, err := model.NewEvent(model.EventMessageUpdated, 1, message)
+	assert.NoError(t, err)
+	deleted, err := model.NewEvent(model.EventMessageDeleted, 1, model.MessageDeletedPayload{Id: 7, Room: 1})
+	assert.NoError(t, err)
+	typing, err := model.NewEvent(model.EventTypingStart, 1, nil)
+	assert.NoError(t, err)
+	created.Id, updated.Id, deleted.Id = 10, 11, 12
+
+	// Повторы любого события outbox отбрасываются, события без id доставляются всегда
+	for _, event := range []*model.Event{created, created, updated, updated, deleted, deleted, typing, typing} {
+		hub.Broadcast <- event
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Len(t, client.Send, 5)
+}
+
+func TestDedupWindow_Evicts(t *testing.T) {
+	window := newDedupWindow(2)
+
+	assert.False(t, window.seen(1))
+	assert.False(t, window.seen(2))
+	assert.True(t, window.seen(1))
+
+	assert.False(t, window.seen(3))
+	assert.False(t, window.seen(1), "oldest id must be evicted")
+	assert.True(t, window.seen(3))
+}
+
+func TestHub_TypingSkipsAuthorAndThrottles(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	author := &model.Client{Id: 1, Conn: &websocket.Conn{}, Room: 1, User: 1, Send: make(chan []byte, 10)}
+	other := &model.Client{Id: 2, Conn: &websocket.Conn{}, Room: 1, User: 2, Send: make(chan []byte, 10)}
+
+	hub.Register <- author
+	hub.Register <- other
+	time.Sleep(10 * time.Millisecond)
+
+	hub.StartTyping(1, 1)
+	hub.StartTyping(1, 1)
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Len(t, author.Send, 0)
+	assert.Len(t, other.Send, 1, "repeated typing.start must be throttled")
+
+	received, err := model.ParseEvent(<-other.Send)
+	assert.NoError(t, err)
+	assert.Equal(t, model.EventTypingStart, received.Type)
+
+	hub.StopTyping(1, 1)
+	time.Sleep(10 * time.Millisecond)
+
+	received, err = model.ParseEvent(<-other.Send)
+	assert.NoError(t, err)
+	assert.Equal(t, model.EventTypingStop, received.Type)
+}
+
+func TestHub_TypingExpires(t *testing.T) {
+	hub := NewHub()
+	hub.typing.timeout = 20 * time.Millisecond
+	go hub.Run()
+
+	other := &model.Client{Id: 2, Conn: &websocket.Conn{}, Room: 1, User: 2, Send: make(chan []byte, 10)}
+	hub.Register <- other
+	time.Sleep(10 * time.Millisecond)
+
+	hub.StartTyping(1, 1)
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Len(t, other.Send, 2)
+	<-other.Send
+	received, err := model.ParseEvent(<-other.Send)
+	assert.NoError(t, err)
+	assert.Equal(t, model.EventTypingStop, received.Type)
+}
+
+func TestHub_MemberLeftDisconnectsUser(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	leaving := &model.Client{Id: 1, Conn: &websocket.Conn{}, Room: 1, User: 7, Send: make(chan []byte, 10)}
+	staying := &model.Client{Id: 2, Conn: &websocket.Conn{}, Room: 1, User: 8, Send: make(chan []byte, 10)}
+	hub.Register <- leaving
+	hub.Register <- staying
+	time.Sleep(10 * time.Millisecond)
+
+	event, err := model.NewEvent(model.EventMemberLeft, 1, model.RoomMember{Room: 1, User: 7})
+	assert.NoError(t, err)
+	hub.Broadcast <- event
+
+	select {
+	case frame := <-staying.Send:
+		received, err := model.ParseEvent(frame)
+		assert.NoError(t, err)
+		assert.Equal(t, model.EventMemberLeft, received.Type)
+	case <-time.After(time.Second):
+		t.Fatal("remaining member did not receive member.left")
+	}
+
+	select {
+	case _, ok := <-leaving.Send:
+		assert.True(t, ok, "departing member should receive member.left first")
+	case <-time.After(time.Second):
+		t.Fatal("departing member did not receive member.left")
+	}
+
+	select {
+	case _, ok := <-leaving.Send:
+		assert.False(t, ok, "departing member's channel should be closed")
+	case <-time.After(time.Second):
+		t.Fatal("departing member was not disconnected")
+	}
+
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	assert.NotContains(t, hub.Rooms[1].Clients, leaving.Id)
+	assert.Contains(t, hub.Rooms[1].Clients, staying.Id)
+}
+
+func TestHub_DisconnectSession(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	revoked := &model.Client{Id: 1, Conn: &websocket.Conn{}, Room: 1, User: 7, Session: "a", Send: make(chan []byte, 10)}
+	revokedOther := &model.Client{Id: 1, Conn: &websocket.Conn{}, Room: 2, User: 7, Session: "a", Send: make(chan []byte, 10)}
+	active := &model.Client{Id: 2, Conn: &websocket.Conn{}, Room: 1, User: 8, Session: "b", Send: make(chan []byte, 10)}
+	hub.Register <- revoked
+	hub.Register <- revokedOther
+	hub.Register <- active
+	time.Sleep(10 * time.Millisecond)
+
+	hub.DisconnectSession("a")
+
+	for _, client := range []*model.Client{revoked, revokedOther} {
+		_, ok := <-client.Send
+		assert.False(t, ok, "client of the revoked session should be disconnected")
+	}
+
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	assert.NotContains(t, hub.Rooms[1].Clients, revoked.Id)
+	assert.Empty(t, hub.Rooms[2].Clients)
+	assert.Contains(t, hub.Rooms[1].Clients, active.Id)
+}
+
+func TestHub_DisconnectSessionKeepsOtherSessionOfUser(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	revoked := &model.Client{Id: hub.NextClientId(), Conn: &websocket.Conn{}, Room: 1, User: 7, Session: "a", Send: make(chan []byte, 10)}
+	active := &model.Client{Id: hub.NextClientId(), Conn: &websocket.Conn{}, Room: 1, User: 7, Session: "b", Send: make(chan []byte, 10)}
+	hub.Register <- revoked
+	hub.Register <- active
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, 2, hub.GetRoomClientsCount(1))
+
+	hub.DisconnectSession("a")
+
+	_, ok := <-revoked.Send
+	assert.False(t, ok, "client of the revoked session should be disconnected")
+
+	event, err := model.NewEvent(model.EventMessageDeleted, 1, model.MessageDeletedPayload{Id: 1, Room: 1})
+	assert.NoError(t, err)
+	hub.Broadcast <- event
+
+	select {
+	case payload, ok := <-active.Send:
+		assert.True(t, ok, "other session of the user should stay connected")
+		assert.Contains(t, string(payload), model.EventMessageDeleted)
+	case <-time.After(time.Second):
+		t.Fatal("other session of the user did not receive the event")
+	}
+
+	// Отключение отозванного соединения не затрагивает оставшееся
+	hub.Unregister <- revoked
+	time.Sleep(10 * time.Millisecond)
+
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	assert.Equal(t, map[int]*model.Client{active.Id: active}, hub.Rooms[1].Clients)
+}
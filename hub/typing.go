@@ -0,0 +1,132 @@
+package hub
+
+import (
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	// Через это время без typing.start пользователь считается переставшим печатать
+	typingTimeout = 5 * time.Second
+	// Повторный typing.start рассылается не чаще этого интервала
+	typingThrottle = 2 * time.Second
+)
+
+type typingKey struct {
+	room int
+	user int
+}
+
+type typingState struct {
+	timer         *time.Timer
+	generation    int
+	lastBroadcast time.Time
+}
+
+// Состояние "печатает" пользователей, подключенных к текущему узлу
+type typingTracker struct {
+	mu       sync.Mutex
+	states   map[typingKey]*typingState
+	timeout  time.Duration
+	throttle time.Duration
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{
+		states:   make(map[typingKey]*typingState),
+		timeout:  typingTimeout,
+		throttle: typingThrottle,
+	}
+}
+
+// Отмечает, что пользователь печатает в комнате
+// Продлевает таймер истечения и рассылает typing.start с учетом ограничения частоты
+func (h *Hub) StartTyping(roomId, userId int) {
+	key := typingKey{room: roomId, user: userId}
+	now := time.Now()
+
+	h.typing.mu.Lock()
+	state, exists := h.typing.states[key]
+	if !exists {
+		state = &typingState{}
+		h.typing.states[key] = state
+	}
+
+	if state.timer != nil {
+		state.timer.Stop()
+	}
+	state.generation++
+	generation := state.generation
+	state.timer = time.AfterFunc(h.typing.timeout, func() {
+		h.expireTyping(key, generation)
+	})
+
+	notify := now.Sub(state.lastBroadcast) >= h.typing.throttle
+	if notify {
+		state.lastBroadcast = now
+	}
+	h.typing.mu.Unlock()
+
+	if notify {
+		h.broadcastTyping(model.EventTypingStart, key)
+	}
+}
+
+// Снимает состояние "печатает" и рассылает typing.stop, если оно было
+func (h *Hub) StopTyping(roomId, userId int) {
+	key := typingKey{room: roomId, user: userId}
+
+	h.typing.mu.Lock()
+	state, exists := h.typing.states[key]
+	if exists {
+		state.timer.Stop()
+		delete(h.typing.states, key)
+	}
+	h.typing.mu.Unlock()
+
+	if exists {
+		h.broadcastTyping(model.EventTypingStop, key)
+	}
+}
+
+// Вызывается таймером, если пользователь долго не присылал typing.start
+// Таймер мог сработать одновременно с продлением, поэтому сверяется поколение
+func (h *Hub) expireTyping(key typingKey, generation int) {
+	h.typing.mu.Lock()
+	current, exists := h.typing.states[key]
+	expired := exists && current.generation == generation
+	if expired {
+		delete(h.typing.states, key)
+	}
+	h.typing.mu.Unlock()
+
+	if expired {
+		h.broadcastTyping(model.EventTypingStop, key)
+	}
+}
+
+func (h *Hub) broadcastTyping(eventType string, key typingKey) {
+	event, err := model.NewEvent(eventType, key.room, model.TypingPayload{User: key.user, Room: key.room})
+	if err != nil {
+		logrus.Errorf("failed to build %s event: %s", eventType, err.Error())
+		return
+	}
+
+	h.Broadcast <- event
+}
+
+// Возвращает автора события набора текста, которому это событие не отправляется
+func typingAuthor(event *model.Event) (int, bool) {
+	if event == nil || event.Type != model.EventTypingStart && event.Type != model.EventTypingStop {
+		return 0, false
+	}
+
+	var payload model.TypingPayload
+	if err := event.Decode(&payload); err != nil {
+		return 0, false
+	}
+
+	return payload.User, true
+}
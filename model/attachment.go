@@ -0,0 +1,44 @@
+package model
+
+import "time"
+
+// Варианты файла вложения для скачивания
+const (
+	AttachmentOriginal  = "original"
+	AttachmentThumbnail = "thumbnail"
+)
+
+// @Description Файл, прикрепленный к сообщению
+// Ссылки подписаны и действуют ограниченное время
+type Attachment struct {
+	Id           int       `json:"id" db:"id"`
+	Room         int       `json:"room" db:"room_id"`
+	User         int       `json:"user" db:"user_id"`
+	MessageId    *int      `json:"message_id,omitempty" db:"message_id"`
+	Filename     string    `json:"filename" db:"filename"`
+	ContentType  string    `json:"content_type" db:"content_type"`
+	Size         int64     `json:"size" db:"size"`
+	StorageKey   string    `json:"-" db:"storage_key"`
+	ThumbnailKey *string   `json:"-" db:"thumbnail_key"`
+	URL          string    `json:"url,omitempty" db:"-"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty" db:"-"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// @Description Загрузка файла по частям
+type AttachmentUpload struct {
+	Id        string    `json:"id" db:"id"`
+	Room      int       `json:"room" db:"room_id"`
+	User      int       `json:"user" db:"user_id"`
+	Filename  string    `json:"filename" db:"filename"`
+	Size      int64     `json:"size" db:"size"`
+	Received  int64     `json:"received" db:"received"`
+	Parts     int       `json:"parts" db:"parts"`
+	ChunkSize int64     `json:"chunk_size" db:"-"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type CreateUploadInput struct {
+	Filename string `json:"filename" binding:"required"`
+	Size     int64  `json:"size" binding:"required"`
+}
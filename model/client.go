@@ -3,12 +3,14 @@ package model
 import "github.com/gorilla/websocket"
 
 // @Description Клиент - существует во время соединения
+// Id - номер соединения, пользователь и сессия хранятся отдельно
 type Client struct {
-	Id   int             `json:"id"`
-	Conn *websocket.Conn `json:"conn"`
-	Room int             `json:"room_id" db:"room_id"`
-	User int             `json:"user" db:"user_id"`
-	Send chan []byte     `json:"send"`
+	Id      int             `json:"id"`
+	Conn    *websocket.Conn `json:"conn"`
+	Room    int             `json:"room_id" db:"room_id"`
+	User    int             `json:"user" db:"user_id"`
+	Session string          `json:"-" db:"-"`
+	Send    chan []byte     `json:"send"`
 }
 
 func (r *Room) GetId() int {
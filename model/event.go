@@ -0,0 +1,131 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Текущая версия протокола событий WebSocket
+const EventVersion = 1
+
+// Типы событий, отправляемых сервером
+const (
+	EventMessageCreated  = "message.created"
+	EventMessageUpdated  = "message.updated"
+	EventMessageDeleted  = "message.deleted"
+	EventRoomUpdated     = "room.updated"
+	EventPresenceChanged = "presence.changed"
+	EventReceiptUpdated  = "receipt.updated"
+	EventMemberJoined    = "member.joined"
+	EventMemberLeft      = "member.left"
+	EventMemberUpdated   = "member.updated"
+	EventMessagePinned   = "message.pinned"
+	EventMessageUnpinned = "message.unpinned"
+	EventThreadReply     = "thread.reply"
+	EventReactionAdded   = "reaction.added"
+	EventReactionRemoved = "reaction.removed"
+	EventError           = "error"
+)
+
+// Типы событий, отправляемых клиентом
+const (
+	EventMessageCreate     = "message.create"
+	EventPresenceHeartbeat = "presence.heartbeat"
+	EventPresenceUpdate    = "presence.update"
+)
+
+// Эфемерные события набора текста, пересылаются остальным участникам комнаты без изменений
+const (
+	EventTypingStart = "typing.start"
+	EventTypingStop  = "typing.stop"
+)
+
+// @Description Конверт события WebSocket - используется для всех кадров в обе стороны
+type Event struct {
+	// Номер события в outbox: одинаков у всех повторных доставок, у эфемерных событий не задан
+	Id   int64           `json:"id,omitempty"`
+	Type string          `json:"type"`
+	V    int             `json:"v"`
+	Room int             `json:"room,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// @Description Данные события error
+type ErrorPayload struct {
+	Message string `json:"message"`
+}
+
+// @Description Данные события message.deleted
+type MessageDeletedPayload struct {
+	Id     int  `json:"id"`
+	Room   int  `json:"room"`
+	Parent *int `json:"parent_id,omitempty"`
+}
+
+// @Description Данные события thread.reply: ответ и обновленные счетчики ветки
+type ThreadReplyPayload struct {
+	Parent      int       `json:"parent_id"`
+	ReplyCount  int       `json:"reply_count"`
+	LastReplyAt time.Time `json:"last_reply_at"`
+	Reply       Message   `json:"reply"`
+}
+
+// @Description Данные событий typing.start и typing.stop
+type TypingPayload struct {
+	User int `json:"user"`
+	Room int `json:"room"`
+}
+
+// @Description Данные команды message.create
+type CreateMessageInput struct {
+	Content       string `json:"content"`
+	ParentId      *int   `json:"parent_id"`
+	AttachmentIds []int  `json:"attachment_ids"`
+}
+
+// Создает событие текущей версии протокола с сериализованными данными
+func NewEvent(eventType string, roomId int, data interface{}) (*Event, error) {
+	event := &Event{
+		Type: eventType,
+		V:    EventVersion,
+		Room: roomId,
+	}
+
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		event.Data = raw
+	}
+
+	return event, nil
+}
+
+// Разбирает входящий кадр и проверяет версию протокола
+func ParseEvent(frame []byte) (*Event, error) {
+	var event Event
+	if err := json.Unmarshal(frame, &event); err != nil {
+		return nil, errors.New("invalid event frame")
+	}
+
+	if event.Type == "" {
+		return nil, errors.New("event type is empty")
+	}
+
+	if event.V != EventVersion {
+		return nil, errors.New("unsupported event version")
+	}
+
+	return &event, nil
+}
+
+// Декодирует данные события в переданную структуру
+func (e *Event) Decode(v interface{}) error {
+	if len(e.Data) == 0 {
+		return errors.New("event data is empty")
+	}
+	return json.Unmarshal(e.Data, v)
+}
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// Учетная запись пользователя у внешнего провайдера OIDC
+// Subject уникален в пределах провайдера, email запоминается на момент привязки
+type UserIdentity struct {
+	Id        int       `json:"id" db:"id"`
+	User      int       `json:"user_id" db:"user_id"`
+	Provider  string    `json:"provider" db:"provider"`
+	Subject   string    `json:"subject" db:"subject"`
+	Email     string    `json:"email" db:"email"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// @Description Провайдер единого входа
+type OIDCProvider struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// @Description Адрес страницы провайдера для привязки учетной записи
+type OIDCRedirect struct {
+	URL string `json:"url"`
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// @Description Приглашение в комнату по коду
+type RoomInvite struct {
+	Id        int        `json:"id" db:"id"`
+	Room      int        `json:"room" db:"room_id"`
+	Code      string     `json:"code" db:"code"`
+	CreatedBy int        `json:"created_by" db:"created_by"`
+	MaxUses   *int       `json:"max_uses" db:"max_uses"`
+	Uses      int        `json:"uses" db:"uses"`
+	ExpiresAt *time.Time `json:"expires_at" db:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at" db:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+// Приглашение действует, пока не отозвано, не истекло и не исчерпано
+func (i RoomInvite) Active(now time.Time) bool {
+	if i.RevokedAt != nil {
+		return false
+	}
+
+	if i.ExpiresAt != nil && !now.Before(*i.ExpiresAt) {
+		return false
+	}
+
+	if i.MaxUses != nil && i.Uses >= *i.MaxUses {
+		return false
+	}
+
+	return true
+}
+
+// @Description Публичные сведения о комнате по коду приглашения
+type InvitePreview struct {
+	Code        string     `json:"code"`
+	Room        int        `json:"room"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Members     int        `json:"members"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// ExpiresIn - срок действия в секундах, без него приглашение бессрочное
+type CreateInviteInput struct {
+	MaxUses   *int `json:"max_uses"`
+	ExpiresIn *int `json:"expires_in"`
+}
+
+func (i CreateInviteInput) Validate() error {
+	if i.MaxUses != nil && *i.MaxUses <= 0 {
+		return errors.New("max_uses must be positive")
+	}
+
+	if i.ExpiresIn != nil && *i.ExpiresIn <= 0 {
+		return errors.New("expires_in must be positive")
+	}
+
+	return nil
+}
@@ -4,10 +4,31 @@ import "time"
 
 // @Description Сообшения чата
 type Message struct {
-	Id        int       `json:"id"`
-	Room      int       `json:"room" db:"room_id"`
-	User      int       `json:"user" db:"user_id"`
+	Id          int             `json:"id"`
+	Room        int             `json:"room" db:"room_id"`
+	User        int             `json:"user" db:"user_id"`
+	Content     string          `json:"content" db:"content"`
+	ParentId    *int            `json:"parent_id,omitempty" db:"parent_id"`
+	ReplyCount  int             `json:"reply_count" db:"reply_count"`
+	LastReplyAt *time.Time      `json:"last_reply_at,omitempty" db:"last_reply_at"`
+	Reactions   []ReactionCount `json:"reactions,omitempty" db:"-"`
+	Attachments []Attachment    `json:"attachments,omitempty" db:"-"`
+	EditedAt    *time.Time      `json:"edited_at,omitempty" db:"edited_at"`
+	DeletedAt   *time.Time      `json:"deleted_at,omitempty" db:"deleted_at"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+}
+
+// Удаленное сообщение остается в истории как надгробие без текста
+func (m Message) Deleted() bool {
+	return m.DeletedAt != nil
+}
+
+// @Description Предыдущая версия сообщения, сохраняется при каждом изменении и удалении
+type MessageRevision struct {
+	Id        int       `json:"id" db:"id"`
+	MessageId int       `json:"message_id" db:"message_id"`
 	Content   string    `json:"content" db:"content"`
+	EditedBy  int       `json:"edited_by" db:"edited_by"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
@@ -18,3 +39,23 @@ func (r *Room) GetIdMes() int {
 func (u *User) GetIdUsMes() int {
 	return u.Id
 }
+
+// @Description Параметры курсорной пагинации истории комнаты
+type MessagePageQuery struct {
+	Before int
+	After  int
+	Limit  int
+}
+
+// @Description Страница истории сообщений комнаты
+type MessagePage struct {
+	Data       []Message `json:"data"`
+	NextCursor *int      `json:"next_cursor"`
+	PrevCursor *int      `json:"prev_cursor"`
+}
+
+// @Description Страница ответов ветки вместе с родительским сообщением
+type ThreadPage struct {
+	Parent Message `json:"parent"`
+	MessagePage
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// @Description Событие в outbox - записывается в одной транзакции с изменением данных
+type OutboxEvent struct {
+	Id          int64     `json:"id" db:"id"`
+	AggregateId int       `json:"aggregate_id" db:"aggregate_id"`
+	EventType   string    `json:"event_type" db:"event_type"`
+	Payload     []byte    `json:"payload" db:"payload"`
+	Attempts    int       `json:"attempts" db:"attempts"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// @Description Состояние очереди outbox
+type OutboxStats struct {
+	Pending    int     `json:"pending" db:"pending"`
+	LagSeconds float64 `json:"lag_seconds" db:"lag_seconds"`
+}
@@ -0,0 +1,20 @@
+package model
+
+// Статусы присутствия пользователя в комнате
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// @Description Присутствие пользователя в комнате
+type Presence struct {
+	User   int    `json:"user"`
+	Room   int    `json:"room"`
+	Status string `json:"status"`
+}
+
+// @Description Данные команды presence.update
+type PresenceUpdateInput struct {
+	Status string `json:"status"`
+}
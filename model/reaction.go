@@ -0,0 +1,135 @@
+package model
+
+import "unicode/utf8"
+
+// Наибольшая длина реакции в байтах, с запасом для составных эмодзи
+const maxEmojiLength = 64
+
+// @Description Количество реакций одним эмодзи на сообщение
+type ReactionCount struct {
+	Emoji string `json:"emoji" db:"emoji"`
+	Count int    `json:"count" db:"count"`
+}
+
+// @Description Данные событий reaction.added и reaction.removed
+type ReactionPayload struct {
+	MessageId int    `json:"message_id"`
+	Room      int    `json:"room"`
+	User      int    `json:"user"`
+	Emoji     string `json:"emoji"`
+	Count     int    `json:"count"`
+}
+
+// Служебные символы эмодзи-последовательностей
+const (
+	zeroWidthJoiner   = '\u200d'
+	variationSelector = '\ufe0f'
+	combiningKeycap   = '\u20e3'
+	cancelTag         = '\U000e007f'
+	firstTag          = '\U000e0020'
+	lastTag           = '\U000e007e'
+	firstSkin         = '\U0001f3fb'
+	lastSkin          = '\U0001f3ff'
+	firstRegional     = '\U0001f1e6'
+	lastRegional      = '\U0001f1ff'
+)
+
+// Диапазоны символов, которые могут быть основой эмодзи
+var emojiRanges = [][2]rune{
+	{0x00a9, 0x00a9}, {0x00ae, 0x00ae}, {0x203c, 0x203c}, {0x2049, 0x2049},
+	{0x2122, 0x2122}, {0x2139, 0x2139}, {0x2194, 0x2199}, {0x21a9, 0x21aa},
+	{0x231a, 0x231b}, {0x2328, 0x2328}, {0x23cf, 0x23cf}, {0x23e9, 0x23f3},
+	{0x23f8, 0x23fa}, {0x24c2, 0x24c2}, {0x25aa, 0x25ab}, {0x25b6, 0x25b6},
+	{0x25c0, 0x25c0}, {0x25fb, 0x25fe}, {0x2600, 0x27bf}, {0x2934, 0x2935},
+	{0x2b05, 0x2b07}, {0x2b1b, 0x2b1c}, {0x2b50, 0x2b50}, {0x2b55, 0x2b55},
+	{0x3030, 0x3030}, {0x303d, 0x303d}, {0x3297, 0x3297}, {0x3299, 0x3299},
+	{0x1f000, 0x1faff},
+}
+
+// Реакцией может быть только один эмодзи: отдельный символ с модификатором,
+// флаг, кнопка с цифрой или несколько эмодзи, соединенных ZWJ
+func ValidEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiLength || !utf8.ValidString(emoji) {
+		return false
+	}
+
+	runes := []rune(emoji)
+	switch {
+	case isRegionalIndicator(runes[0]):
+		return len(runes) == 2 && isRegionalIndicator(runes[1])
+	case isKeycapBase(runes[0]):
+		return validKeycap(runes[1:])
+	}
+
+	start := 0
+	for i, r := range runes {
+		if r != zeroWidthJoiner {
+			continue
+		}
+		if !validEmojiElement(runes[start:i]) {
+			return false
+		}
+		start = i + 1
+	}
+
+	return validEmojiElement(runes[start:])
+}
+
+// Основа эмодзи с необязательным селектором варианта или оттенком кожи
+// и последовательностью тегов для флагов регионов
+func validEmojiElement(runes []rune) bool {
+	if len(runes) == 0 || !isEmojiBase(runes[0]) {
+		return false
+	}
+
+	rest := runes[1:]
+	if len(rest) > 0 && (rest[0] == variationSelector || isSkinTone(rest[0])) {
+		rest = rest[1:]
+	}
+
+	if len(rest) > 0 && isTag(rest[0]) {
+		for len(rest) > 1 && isTag(rest[0]) {
+			rest = rest[1:]
+		}
+		return len(rest) == 1 && rest[0] == cancelTag
+	}
+
+	return len(rest) == 0
+}
+
+// Кнопка с цифрой: символ, необязательный селектор варианта и U+20E3
+func validKeycap(rest []rune) bool {
+	if len(rest) > 0 && rest[0] == variationSelector {
+		rest = rest[1:]
+	}
+	return len(rest) == 1 && rest[0] == combiningKeycap
+}
+
+func isEmojiBase(r rune) bool {
+	if isRegionalIndicator(r) || isSkinTone(r) {
+		return false
+	}
+
+	for _, bounds := range emojiRanges {
+		if r >= bounds[0] && r <= bounds[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func isKeycapBase(r rune) bool {
+	return r >= '0' && r <= '9' || r == '#' || r == '*'
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= firstRegional && r <= lastRegional
+}
+
+func isSkinTone(r rune) bool {
+	return r >= firstSkin && r <= lastSkin
+}
+
+func isTag(r rune) bool {
+	return r >= firstTag && r <= lastTag
+}
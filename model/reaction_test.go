@@ -0,0 +1,44 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestValidEmoji(t *testing.T) {
+	cases := map[string]bool{
+		"👍":                      true,
+		"❤\ufe0f":                true,
+		"☺":                      true,
+		"👍🏽":                     true,
+		"🇷🇺":                     true,
+		"1\ufe0f\u20e3":          true,
+		"#\u20e3":                true,
+		"👩\u200d👩\u200d👧\u200d👦": true,
+		"🏳\ufe0f\u200d🌈":         true,
+		"👩\U0001f3fd\u200d💻":     true,
+		"🏴\U000e0067\U000e0062\U000e0065\U000e006e\U000e0067\U000e007f": true,
+		"":              false,
+		"a":             false,
+		"ok":            false,
+		"1":             false,
+		"👍👍":            false,
+		"👍 ":            false,
+		"👍a":            false,
+		"a👍":            false,
+		"🇷":             false,
+		"🇷🇺🇺":           false,
+		"🏽":             false,
+		"\u200d👍":       false,
+		"👍\u200d":       false,
+		"👍\ufe0f\ufe0f": false,
+		"🏴\U000e0067":   false,
+		"\xff":          false,
+		strings.Repeat("👩\u200d", 16) + "👩": false,
+	}
+
+	for emoji, valid := range cases {
+		assert.Equal(t, valid, ValidEmoji(emoji), "%q", emoji)
+	}
+}
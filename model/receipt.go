@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// @Description Отметка о прочтении - последнее прочитанное пользователем сообщение комнаты
+type ReadReceipt struct {
+	User      int       `json:"user" db:"user_id"`
+	Room      int       `json:"room" db:"room_id"`
+	MessageId int       `json:"message_id" db:"last_read_message_id"`
+	ReadAt    time.Time `json:"read_at" db:"updated_at"`
+}
+
+// @Description Число непрочитанных сообщений комнаты
+// Tracked - у пользователя есть отметка о прочтении в комнате
+type UnreadCount struct {
+	Room    int  `json:"room" db:"room_id"`
+	Unread  int  `json:"unread" db:"unread"`
+	Tracked bool `json:"-" db:"tracked"`
+}
+
+// @Description Комната в списке комнат пользователя
+type RoomListItem struct {
+	Room
+	UnreadCount int `json:"unread_count"`
+}
+
+type MarkReadInput struct {
+	MessageId int `json:"message_id" binding:"required"`
+}
@@ -0,0 +1,110 @@
+package model
+
+import "time"
+
+// Роли участников комнаты в порядке убывания прав
+const (
+	RoleOwner     = "owner"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+	RoleReadOnly  = "read_only"
+)
+
+// Действия в комнате, требующие проверки роли
+type Permission string
+
+const (
+	PermSendMessage      Permission = "send_message"
+	PermReact            Permission = "react"
+	PermEditRoom         Permission = "edit_room"
+	PermDeleteRoom       Permission = "delete_room"
+	PermDeleteAnyMessage Permission = "delete_any_message"
+	PermKick             Permission = "kick"
+	PermBan              Permission = "ban"
+	PermPin              Permission = "pin"
+	PermInvite           Permission = "invite"
+	PermManageRoles      Permission = "manage_roles"
+	PermManageInvites    Permission = "manage_invites"
+	PermViewRevisions    Permission = "view_revisions"
+)
+
+// Матрица прав: какие действия доступны каждой роли
+var rolePermissions = map[string]map[Permission]bool{
+	RoleOwner: {
+		PermSendMessage:      true,
+		PermReact:            true,
+		PermEditRoom:         true,
+		PermDeleteRoom:       true,
+		PermDeleteAnyMessage: true,
+		PermViewRevisions:    true,
+		PermKick:             true,
+		PermBan:              true,
+		PermPin:              true,
+		PermInvite:           true,
+		PermManageRoles:      true,
+		PermManageInvites:    true,
+	},
+	RoleModerator: {
+		PermSendMessage:      true,
+		PermReact:            true,
+		PermEditRoom:         true,
+		PermDeleteAnyMessage: true,
+		PermViewRevisions:    true,
+		PermKick:             true,
+		PermBan:              true,
+		PermPin:              true,
+		PermInvite:           true,
+	},
+	RoleMember: {
+		PermSendMessage: true,
+		PermReact:       true,
+		PermInvite:      true,
+	},
+	RoleReadOnly: {},
+}
+
+var roleRanks = map[string]int{
+	RoleOwner:     3,
+	RoleModerator: 2,
+	RoleMember:    1,
+	RoleReadOnly:  0,
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Проверяет, разрешено ли роли действие
+func RoleHas(role string, perm Permission) bool {
+	return rolePermissions[role][perm]
+}
+
+// Модерировать можно только участников с более низкой ролью
+func RoleOutranks(role, target string) bool {
+	return roleRanks[role] > roleRanks[target]
+}
+
+// @Description Блокировка пользователя в комнате
+type RoomBan struct {
+	Room      int       `json:"room" db:"room_id"`
+	User      int       `json:"user" db:"user_id"`
+	BannedBy  int       `json:"banned_by" db:"banned_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// @Description Закрепленное сообщение комнаты
+type PinnedMessage struct {
+	Room      int       `json:"room" db:"room_id"`
+	MessageId int       `json:"message_id" db:"message_id"`
+	PinnedBy  int       `json:"pinned_by" db:"pinned_by"`
+	PinnedAt  time.Time `json:"pinned_at" db:"pinned_at"`
+}
+
+type MemberInput struct {
+	User int `json:"user" binding:"required"`
+}
+
+type UpdateMemberRoleInput struct {
+	Role string `json:"role" binding:"required"`
+}
@@ -6,25 +6,70 @@ import (
 	"time"
 )
 
+// Видимость комнаты
+// public - видна в поиске, вступить может любой
+// invite_only - видна в поиске, вступить можно только по приглашению
+// private - скрыта из поиска, вступить можно только по приглашению
+const (
+	RoomPublic     = "public"
+	RoomInviteOnly = "invite_only"
+	RoomPrivate    = "private"
+)
+
+// Тип комнаты
+// room - именованная комната
+// direct - личная переписка двух пользователей
+// group - безымянная переписка небольшой группы
+const (
+	RoomKindRoom   = "room"
+	RoomKindDirect = "direct"
+	RoomKindGroup  = "group"
+)
+
 // @Description Комнаты чата
 type Room struct {
-	Id          int       `json:"id" db:"id"`
-	Name        string    `json:"name" db:"name"`
-	Description string    `json:"description" db:"description"`
-	CreatedBy   int       `json:"created_by" db:"created_by"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	Id             int       `json:"id" db:"id"`
+	Name           string    `json:"name" db:"name"`
+	Description    string    `json:"description" db:"description"`
+	Visibility     string    `json:"visibility" db:"visibility"`
+	Kind           string    `json:"kind" db:"kind"`
+	ParticipantKey *string   `json:"-" db:"participant_key"`
+	CreatedBy      int       `json:"created_by" db:"created_by"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// Participants - остальные участники, создатель добавляется автоматически
+type CreateConversationInput struct {
+	Participants []int `json:"participants" binding:"required"`
+}
+
+// @Description Участник комнаты
+type RoomMember struct {
+	Room     int       `json:"room" db:"room_id"`
+	User     int       `json:"user" db:"user_id"`
+	Role     string    `json:"role" db:"role"`
+	JoinedAt time.Time `json:"joined_at" db:"joined_at"`
+}
+
+func ValidVisibility(visibility string) bool {
+	return visibility == RoomPublic || visibility == RoomInviteOnly || visibility == RoomPrivate
 }
 
 type UpdateRoomInput struct {
 	Name        *string `json:"name"`
 	Description *string `json:"description"`
+	Visibility  *string `json:"visibility"`
 }
 
 func (i UpdateRoomInput) Validate() error {
-	if i.Name == nil && i.Description == nil {
+	if i.Name == nil && i.Description == nil && i.Visibility == nil {
 		return errors.New("update structure has no values")
 	}
 
+	if i.Visibility != nil && !ValidVisibility(*i.Visibility) {
+		return errors.New("invalid visibility")
+	}
+
 	if i.Name != nil && strings.TrimSpace(*i.Name) == " " {
 		return errors.New("name cannot be empty")
 	}
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// @Description Параметры полнотекстового поиска по сообщениям
+// Пустые фильтры не ограничивают выборку, To не включается в диапазон
+type MessageSearchQuery struct {
+	Query  string
+	Room   int
+	Author int
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+	Offset int
+}
+
+// @Description Найденное сообщение с фрагментом, в котором совпадения обернуты в <mark>
+type MessageSearchResult struct {
+	Message
+	Headline string  `json:"headline" db:"headline"`
+	Rank     float64 `json:"rank" db:"rank"`
+}
+
+// @Description Страница результатов поиска, упорядоченных по релевантности
+type MessageSearchPage struct {
+	Data       []MessageSearchResult `json:"data"`
+	NextOffset *int                  `json:"next_offset"`
+}
@@ -0,0 +1,36 @@
+package model
+
+import "time"
+
+// @Description Пара токенов: короткоживущий access и одноразовый refresh для его обновления
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// Проверенный access токен
+// Session объединяет все токены, выданные по одному входу
+type AccessToken struct {
+	Id        string
+	User      int
+	Session   string
+	ExpiresAt time.Time
+}
+
+// Refresh токен хранится только в виде хеша
+// Каждый токен одноразовый: при обновлении погашается и заменяется следующим в той же сессии
+type RefreshToken struct {
+	Id        int        `db:"id"`
+	User      int        `db:"user_id"`
+	Session   string     `db:"session_id"`
+	TokenHash string     `db:"token_hash"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+type RefreshTokenInput struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
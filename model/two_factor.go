@@ -0,0 +1,51 @@
+package model
+
+import "time"
+
+// Второй фактор пользователя: секрет без EnabledAt означает незавершенное подключение
+// LastStep - последний принятый шаг TOTP, код с тем же шагом повторно не принимается
+type TwoFactor struct {
+	User      int        `db:"user_id"`
+	Username  string     `db:"username"`
+	Secret    string     `db:"secret"`
+	EnabledAt *time.Time `db:"enabled_at"`
+	LastStep  int64      `db:"last_step"`
+}
+
+func (t TwoFactor) Enabled() bool {
+	return t.EnabledAt != nil
+}
+
+// @Description Подключение TOTP: секрет и otpauth URI для приложения-аутентификатора
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// @Description Одноразовые коды восстановления, показываются только один раз
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// Код из приложения-аутентификатора, а где допускается - код восстановления
+type TwoFactorCodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorSignInInput struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// @Description Запрос второго фактора после проверки пароля
+type TwoFactorChallenge struct {
+	TwoFactorRequired  bool   `json:"two_factor_required"`
+	ChallengeToken     string `json:"challenge_token"`
+	ChallengeExpiresIn int    `json:"challenge_expires_in"`
+}
+
+// @Description Результат входа по паролю: пара токенов, либо при включенной 2FA - запрос второго фактора
+type SignInResult struct {
+	*TokenPair
+	*TwoFactorChallenge
+}
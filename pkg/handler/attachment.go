@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"errors"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/service"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Запас на заголовки multipart сверх максимального размера файла
+const multipartOverhead = 1 << 20
+
+// @Summary Upload attachment
+// @Security ApiKeyAuth
+// @Tags attachments
+// @Description Upload a file to the room in a single multipart request. The returned id is passed in attachment_ids when sending a message
+// @ID upload-attachment
+// @Accept mpfd
+// @Produce json
+// @Param id path int true "Room ID"
+// @Param file formData file true "File"
+// @Success 200 {object} model.Attachment
+// @Failure 400,403,413 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/attachments [post]
+func (h *Handler) uploadAttachment(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.services.Attachment.MaxSize()+multipartOverhead)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			newErrorResponse(c, http.StatusRequestEntityTooLarge, service.ErrAttachmentTooLarge.Error())
+			return
+		}
+		newErrorResponse(c, http.StatusBadRequest, "file is required")
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer file.Close()
+
+	attachment, err := h.services.Attachment.Upload(c.Request.Context(), roomId, userId, header.Filename, header.Size, file)
+	if err != nil {
+		newRoomErrorResponse(c, err, "room not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, attachment)
+}
+
+// @Summary Start chunked upload
+// @Security ApiKeyAuth
+// @Tags attachments
+// @Description Start a resumable upload of a large file. Chunks are sent in order with PUT /api/uploads/{id}
+// @ID start-upload
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Param input body model.CreateUploadInput true "file name and size"
+// @Success 200 {object} model.AttachmentUpload
+// @Failure 400,403,413 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/uploads [post]
+func (h *Handler) startUpload(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	var input model.CreateUploadInput
+	if err := c.BindJSON(&input); err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	upload, err := h.services.Attachment.StartUpload(roomId, userId, input)
+	if err != nil {
+		newRoomErrorResponse(c, err, "room not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, upload)
+}
+
+// @Summary Upload chunk
+// @Security ApiKeyAuth
+// @Tags attachments
+// @Description Upload the next chunk as the raw request body. offset must equal the received bytes of the upload, on 409 resume from the current upload state
+// @ID upload-chunk
+// @Accept octet-stream
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Param offset query int true "Chunk offset"
+// @Success 200 {object} model.AttachmentUpload
+// @Failure 400,404,409,411 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/uploads/{id} [put]
+func (h *Handler) uploadChunk(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.Query("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		newErrorResponse(c, http.StatusBadRequest, "invalid offset")
+		return
+	}
+
+	if c.Request.ContentLength < 0 {
+		newErrorResponse(c, http.StatusLengthRequired, "content length is required")
+		return
+	}
+
+	upload, err := h.services.Attachment.UploadChunk(c.Request.Context(), c.Param("id"), userId, offset, c.Request.ContentLength, c.Request.Body)
+	if err != nil {
+		newRoomErrorResponse(c, err, "upload not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, upload)
+}
+
+// @Summary Complete chunked upload
+// @Security ApiKeyAuth
+// @Tags attachments
+// @Description Assemble the uploaded chunks into an attachment
+// @ID complete-upload
+// @Accept json
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Success 200 {object} model.Attachment
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/uploads/{id}/complete [post]
+func (h *Handler) completeUpload(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	attachment, err := h.services.Attachment.CompleteUpload(c.Request.Context(), c.Param("id"), userId)
+	if err != nil {
+		newRoomErrorResponse(c, err, "upload not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, attachment)
+}
+
+// @Summary Get attachment
+// @Security ApiKeyAuth
+// @Tags attachments
+// @Description Get attachment metadata with fresh signed download URLs
+// @ID get-attachment
+// @Accept json
+// @Produce json
+// @Param id path int true "Attachment ID"
+// @Success 200 {object} model.Attachment
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/attachments/{id} [get]
+func (h *Handler) getAttachment(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid attachment id")
+		return
+	}
+
+	attachment, err := h.services.Attachment.GetAttachment(id, userId)
+	if err != nil {
+		newRoomErrorResponse(c, err, "attachment not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, attachment)
+}
+
+// @Summary Download attachment
+// @Tags attachments
+// @Description Download a file by signed URL. Images are served inline, other files as a download
+// @ID download-attachment
+// @Produce octet-stream
+// @Param id path int true "Attachment ID"
+// @Param variant query string false "original or thumbnail"
+// @Param expires query int true "Expiry unix time"
+// @Param signature query string true "URL signature"
+// @Success 200 {file} file
+// @Failure 403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /files/attachments/{id} [get]
+func (h *Handler) downloadAttachment(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid attachment id")
+		return
+	}
+
+	variant := c.DefaultQuery("variant", model.AttachmentOriginal)
+	expires, _ := strconv.ParseInt(c.Query("expires"), 10, 64)
+
+	blob, attachment, contentType, err := h.services.Attachment.OpenAttachment(c.Request.Context(), id, variant, expires, c.Query("signature"))
+	if err != nil {
+		newRoomErrorResponse(c, err, "attachment not found")
+		return
+	}
+	defer blob.Close()
+
+	// Загруженный файл не должен исполняться в контексте нашего домена
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Content-Security-Policy", "sandbox")
+	header.Set("Cache-Control", "private")
+	if variant == model.AttachmentOriginal {
+		header.Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	}
+
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, blob); err != nil {
+		logrus.Errorf("failed to send attachment %d: %s", id, err.Error())
+	}
+}
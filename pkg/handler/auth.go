@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"errors"
 	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/service"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
@@ -44,12 +46,12 @@ type signInInput struct {
 
 // @Summary SignIn
 // @Tags auth
-// @Description Login user
+// @Description Login user. With two-factor authentication enabled no tokens are issued: the response carries a challenge token for /auth/2fa/verify
 // @ID login-user
 // @Accept json
 // @Produce json
-// @Success 200 {string} string "token"
-// @Failure 400,404 {object} errorResponse
+// @Success 200 {object} model.SignInResult
+// @Failure 400,401 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Failure default {object} errorResponse
 // @Router /auth/sign-in [post]
@@ -61,13 +63,109 @@ func (h *Handler) signIn(c *gin.Context) {
 		return
 	}
 
-	token, err := h.services.Authorization.GenerateToken(input.Username, input.Password)
+	result, err := h.services.Authorization.GenerateToken(input.Username, input.Password)
+	if errors.Is(err, service.ErrInvalidCredentials) {
+		newErrorResponse(c, http.StatusUnauthorized, err.Error())
+		return
+	}
 	if err != nil {
 		newErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	c.JSON(http.StatusOK, map[string]interface{}{
-		"token": token,
-	})
+	c.JSON(http.StatusOK, result)
+}
+
+// @Summary Refresh tokens
+// @Tags auth
+// @Description Exchange a refresh token for a new token pair. Each refresh token works once; reusing one revokes the whole session
+// @ID refresh-token
+// @Accept json
+// @Produce json
+// @Param input body model.RefreshTokenInput true "refresh token"
+// @Success 200 {object} model.TokenPair
+// @Failure 400,401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /auth/refresh [post]
+func (h *Handler) refreshToken(c *gin.Context) {
+	var input model.RefreshTokenInput
+
+	if err := c.BindJSON(&input); err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tokens, err := h.services.Authorization.RefreshToken(input.RefreshToken)
+	if errors.Is(err, service.ErrInvalidRefreshToken) {
+		newErrorResponse(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// @Summary Logout
+// @Security ApiKeyAuth
+// @Tags auth
+// @Description End the current session: its refresh tokens and access tokens are revoked and its WebSocket connections closed
+// @ID logout
+// @Produce json
+// @Success 200 {object} StatusResponse
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /auth/logout [post]
+func (h *Handler) logout(c *gin.Context) {
+	token, err := getAccessToken(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := h.services.Authorization.Logout(token); err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// @Summary Logout from all devices
+// @Security ApiKeyAuth
+// @Tags auth
+// @Description End every session of the user, including the current one
+// @ID logout-all
+// @Produce json
+// @Success 200 {object} StatusResponse
+// @Failure 401 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /auth/logout-all [post]
+func (h *Handler) logoutAll(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := h.services.Authorization.LogoutAll(userId); err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// @Summary JSON Web Key Set
+// @Tags auth
+// @Description Public keys for verifying access tokens. Tokens carry the key id in the kid header; HS256 secrets are never published
+// @ID jwks
+// @Produce json
+// @Success 200 {object} model.JSONWebKeySet
+// @Router /.well-known/jwks.json [get]
+func (h *Handler) getJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.services.Authorization.JWKS())
 }
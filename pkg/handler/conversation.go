@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// @Summary Create conversation
+// @Security ApiKeyAuth
+// @Tags conversations
+// @Description Open a direct or group conversation with the given users. Returns the existing one for the same participants; only the caller rejoins it after leaving. Fails if a participant is banned in it
+// @ID create-conversation
+// @Accept json
+// @Produce json
+// @Param input body model.CreateConversationInput true "participants"
+// @Success 200 {object} getRoomResponse
+// @Failure 400,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/conversations [post]
+func (h *Handler) createConversation(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var input model.CreateConversationInput
+	if err := c.BindJSON(&input); err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	room, err := h.services.Room.CreateConversation(userId, input.Participants)
+	if err != nil {
+		newRoomErrorResponse(c, err, "conversation not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, getRoomResponse{
+		Data: room,
+	})
+}
@@ -28,8 +28,26 @@ func (h *Handler) InitRoutes() *gin.Engine {
 	{
 		auth.POST("/sign-up", h.signUp)
 		auth.POST("/sign-in", h.signIn)
+		auth.POST("/refresh", h.refreshToken)
+		auth.POST("/logout", h.userIdentity, h.logout)
+		auth.POST("/logout-all", h.userIdentity, h.logoutAll)
+		auth.GET("/oidc/providers", h.getOIDCProviders)
+		auth.GET("/oidc/:provider/login", h.oidcLogin)
+		auth.GET("/oidc/:provider/callback", h.oidcCallback)
+		auth.POST("/oidc/:provider/link", h.userIdentity, h.oidcLink)
+
+		twoFactor := auth.Group("/2fa")
+		{
+			twoFactor.POST("/verify", h.verifyTwoFactor)
+			twoFactor.POST("/enroll", h.userIdentity, h.enrollTwoFactor)
+			twoFactor.POST("/confirm", h.userIdentity, h.confirmTwoFactor)
+			twoFactor.POST("/recovery-codes", h.userIdentity, h.regenerateRecoveryCodes)
+			twoFactor.POST("/disable", h.userIdentity, h.disableTwoFactor)
+		}
 	}
 
+	router.GET("/.well-known/jwks.json", h.getJWKS)
+
 	api := router.Group("/api", h.userIdentity)
 	{
 		room := api.Group("/room")
@@ -40,7 +58,47 @@ func (h *Handler) InitRoutes() *gin.Engine {
 			room.GET("/:id", h.getRoomById)
 			room.PUT("/:id", h.updateRoom)
 			room.DELETE("/:id", h.deleteRoom)
-			room.GET("/:id/ws", h.handleWebSocket)
+			room.POST("/:id/ws-ticket", h.createWsTicket)
+			room.GET("/:id/presence", h.getRoomPresence)
+			room.POST("/:id/read", h.markRoomRead)
+			room.GET("/:id/receipts", h.getRoomReceipts)
+			room.POST("/:id/join", h.joinRoom)
+			room.POST("/:id/leave", h.leaveRoom)
+			room.GET("/:id/members", h.getRoomMembers)
+			room.POST("/:id/members", h.inviteMember)
+			room.DELETE("/:id/members/:user_id", h.kickMember)
+			room.PUT("/:id/members/:user_id/role", h.updateMemberRole)
+			room.GET("/:id/bans", h.getRoomBans)
+			room.POST("/:id/bans", h.banMember)
+			room.DELETE("/:id/bans/:user_id", h.unbanMember)
+			room.GET("/:id/pins", h.getPinnedMessages)
+			room.POST("/:id/invites", h.createInvite)
+			room.GET("/:id/invites", h.getRoomInvites)
+			room.DELETE("/:id/invites/:code", h.revokeInvite)
+			room.POST("/:id/attachments", h.uploadAttachment)
+			room.POST("/:id/uploads", h.startUpload)
+		}
+
+		uploads := api.Group("/uploads")
+		{
+			uploads.PUT("/:id", h.uploadChunk)
+			uploads.POST("/:id/complete", h.completeUpload)
+		}
+
+		api.GET("/attachments/:id", h.getAttachment)
+		api.GET("/search/messages", h.searchMessages)
+
+		api.POST("/conversations", h.createConversation)
+
+		invites := api.Group("/invites")
+		{
+			invites.GET("/:code", h.previewInvite)
+			invites.POST("/:code/accept", h.acceptInvite)
+		}
+
+		admin := api.Group("/admin")
+		{
+			admin.DELETE("/users/:user_id/2fa", h.resetTwoFactor)
 		}
 
 		messages := api.Group("/messages")
@@ -49,8 +107,19 @@ func (h *Handler) InitRoutes() *gin.Engine {
 			messages.POST("/", h.sendMessage)
 			messages.DELETE("/:id", h.deleteMessage)
 			messages.PATCH("/:id", h.updateMessage)
+			messages.GET("/:id/thread", h.getMessageThread)
+			messages.GET("/:id/revisions", h.getMessageRevisions)
+			messages.PUT("/:id/pin", h.pinMessage)
+			messages.DELETE("/:id/pin", h.unpinMessage)
+			messages.PUT("/:id/reactions/:emoji", h.addReaction)
+			messages.DELETE("/:id/reactions/:emoji", h.removeReaction)
 		}
 	}
+	// WebSocket аутентифицируется самостоятельно: браузер не может передать заголовок Authorization
+	router.GET("/api/room/:id/ws", h.handleWebSocket)
+	// Файлы отдаются по подписанной ссылке, чтобы их можно было открыть в <img> и по прямой ссылке
+	router.GET("/files/attachments/:id", h.downloadAttachment)
+
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	router.Run(":8000")
 
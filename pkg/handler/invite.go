@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+type getRoomInvitesResponse struct {
+	Data []model.RoomInvite `json:"data"`
+}
+
+type acceptInviteResponse struct {
+	Room int `json:"room"`
+}
+
+// @Summary Create invite
+// @Security ApiKeyAuth
+// @Tags invites
+// @Description Create an invite code for the room with optional max uses and expiry in seconds
+// @ID create-invite
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Param input body model.CreateInviteInput true "invite limits"
+// @Success 200 {object} model.RoomInvite
+// @Failure 400,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/invites [post]
+func (h *Handler) createInvite(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	var input model.CreateInviteInput
+	if err := c.BindJSON(&input); err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	invite, err := h.services.Invite.CreateInvite(roomId, userId, input)
+	if err != nil {
+		newRoomErrorResponse(c, err, "room not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, invite)
+}
+
+// @Summary Get room invites
+// @Security ApiKeyAuth
+// @Tags invites
+// @Description Get all invites of the room including revoked and expired ones
+// @ID get-room-invites
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Success 200 {object} getRoomInvitesResponse
+// @Failure 400,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/invites [get]
+func (h *Handler) getRoomInvites(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	invites, err := h.services.Invite.GetRoomInvites(roomId, userId)
+	if err != nil {
+		newRoomErrorResponse(c, err, "room not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, getRoomInvitesResponse{
+		Data: invites,
+	})
+}
+
+// @Summary Revoke invite
+// @Security ApiKeyAuth
+// @Tags invites
+// @Description Revoke an active invite of the room
+// @ID revoke-invite
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Param code path string true "Invite code"
+// @Success 200 {object} StatusResponse
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/invites/{code} [delete]
+func (h *Handler) revokeInvite(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	if err := h.services.Invite.RevokeInvite(roomId, userId, c.Param("code")); err != nil {
+		newRoomErrorResponse(c, err, "invite not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// @Summary Preview invite
+// @Security ApiKeyAuth
+// @Tags invites
+// @Description Get the room behind an active invite code
+// @ID preview-invite
+// @Accept json
+// @Produce json
+// @Param code path string true "Invite code"
+// @Success 200 {object} model.InvitePreview
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/invites/{code} [get]
+func (h *Handler) previewInvite(c *gin.Context) {
+	preview, err := h.services.Invite.PreviewInvite(c.Param("code"))
+	if err != nil {
+		newRoomErrorResponse(c, err, "invite not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
+}
+
+// @Summary Accept invite
+// @Security ApiKeyAuth
+// @Tags invites
+// @Description Join the room by invite code
+// @ID accept-invite
+// @Accept json
+// @Produce json
+// @Param code path string true "Invite code"
+// @Success 200 {object} acceptInviteResponse
+// @Failure 403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/invites/{code}/accept [post]
+func (h *Handler) acceptInvite(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := h.services.Invite.AcceptInvite(c.Param("code"), userId)
+	if err != nil {
+		newRoomErrorResponse(c, err, "invite not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, acceptInviteResponse{
+		Room: roomId,
+	})
+}
@@ -0,0 +1,417 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/service"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+type getRoomMembersResponse struct {
+	Data []model.RoomMember `json:"data"`
+}
+
+type getRoomBansResponse struct {
+	Data []model.RoomBan `json:"data"`
+}
+
+// @Summary Join room
+// @Security ApiKeyAuth
+// @Tags room
+// @Description Join a public room
+// @ID join-room
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Success 200 {object} StatusResponse
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/join [post]
+func (h *Handler) joinRoom(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	err = h.services.Room.JoinRoom(roomId, userId)
+	if err != nil {
+		newRoomErrorResponse(c, err, "room not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// @Summary Leave room
+// @Security ApiKeyAuth
+// @Tags room
+// @Description Leave the room
+// @ID leave-room
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Success 200 {object} StatusResponse
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/leave [post]
+func (h *Handler) leaveRoom(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	err = h.services.Room.LeaveRoom(roomId, userId)
+	if err != nil {
+		newRoomErrorResponse(c, err, "room or membership not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// @Summary Get room members
+// @Security ApiKeyAuth
+// @Tags room
+// @Description Get members of the room
+// @ID get-room-members
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Success 200 {object} getRoomMembersResponse
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/members [get]
+func (h *Handler) getRoomMembers(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	if !h.requireRoomMember(c, roomId, userId) {
+		return
+	}
+
+	members, err := h.services.Room.GetRoomMembers(roomId)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, getRoomMembersResponse{
+		Data: members,
+	})
+}
+
+// Проверяет, что пользователь состоит в комнате, иначе отвечает 403
+func (h *Handler) requireRoomMember(c *gin.Context, roomId, userId int) bool {
+	isMember, err := h.services.Room.IsMember(roomId, userId)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return false
+	}
+
+	if !isMember {
+		newErrorResponse(c, http.StatusForbidden, service.ErrNotRoomMember.Error())
+		return false
+	}
+
+	return true
+}
+
+// Переводит ошибки членства и прав в комнате в HTTP-статусы
+func newRoomErrorResponse(c *gin.Context, err error, notFound string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		newErrorResponse(c, http.StatusNotFound, notFound)
+	case errors.Is(err, service.ErrInvalidInvite),
+		errors.Is(err, service.ErrMessageDeleted):
+		newErrorResponse(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, service.ErrForbidden),
+		errors.Is(err, service.ErrNotRoomMember),
+		errors.Is(err, service.ErrUserBanned),
+		errors.Is(err, service.ErrRoomNotJoinable),
+		errors.Is(err, service.ErrOwnerCannotLeave),
+		errors.Is(err, service.ErrConversationFixed),
+		errors.Is(err, service.ErrInvalidSignature):
+		newErrorResponse(c, http.StatusForbidden, err.Error())
+	case errors.Is(err, service.ErrInvalidRole),
+		errors.Is(err, service.ErrInvalidParticipants),
+		errors.Is(err, service.ErrInvalidParent),
+		errors.Is(err, service.ErrInvalidEmoji),
+		errors.Is(err, service.ErrInvalidVisibility),
+		errors.Is(err, service.ErrInvalidAttachments),
+		errors.Is(err, service.ErrInvalidSearch),
+		errors.Is(err, service.ErrUploadIncomplete):
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrInvalidUpload):
+		newErrorResponse(c, http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrAttachmentTooLarge):
+		newErrorResponse(c, http.StatusRequestEntityTooLarge, err.Error())
+	default:
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// Возвращает id комнаты и id пользователя из пути запроса
+func parseRoomMemberParams(c *gin.Context) (int, int, bool) {
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return 0, 0, false
+	}
+
+	targetId, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid user id")
+		return 0, 0, false
+	}
+
+	return roomId, targetId, true
+}
+
+// @Summary Invite member
+// @Security ApiKeyAuth
+// @Tags room
+// @Description Add a user to the room. Works for every visibility
+// @ID invite-member
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Param input body model.MemberInput true "user to invite"
+// @Success 200 {object} StatusResponse
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/members [post]
+func (h *Handler) inviteMember(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	var input model.MemberInput
+	if err := c.BindJSON(&input); err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.services.Room.InviteMember(roomId, userId, input.User); err != nil {
+		newRoomErrorResponse(c, err, "room not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// @Summary Kick member
+// @Security ApiKeyAuth
+// @Tags room
+// @Description Remove a member with a lower role from the room
+// @ID kick-member
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Param user_id path int true "User ID"
+// @Success 200 {object} StatusResponse
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/members/{user_id} [delete]
+func (h *Handler) kickMember(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, targetId, ok := parseRoomMemberParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.services.Room.KickMember(roomId, userId, targetId); err != nil {
+		newRoomErrorResponse(c, err, "member not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// @Summary Update member role
+// @Security ApiKeyAuth
+// @Tags room
+// @Description Change the role of a member. Owner only
+// @ID update-member-role
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Param user_id path int true "User ID"
+// @Param input body model.UpdateMemberRoleInput true "new role"
+// @Success 200 {object} model.RoomMember
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/members/{user_id}/role [put]
+func (h *Handler) updateMemberRole(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, targetId, ok := parseRoomMemberParams(c)
+	if !ok {
+		return
+	}
+
+	var input model.UpdateMemberRoleInput
+	if err := c.BindJSON(&input); err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	member, err := h.services.Room.SetMemberRole(roomId, userId, targetId, input.Role)
+	if err != nil {
+		newRoomErrorResponse(c, err, "member not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+// @Summary Ban member
+// @Security ApiKeyAuth
+// @Tags room
+// @Description Ban a user and remove them from the room
+// @ID ban-member
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Param input body model.MemberInput true "user to ban"
+// @Success 200 {object} StatusResponse
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/bans [post]
+func (h *Handler) banMember(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	var input model.MemberInput
+	if err := c.BindJSON(&input); err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.services.Room.BanMember(roomId, userId, input.User); err != nil {
+		newRoomErrorResponse(c, err, "room not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// @Summary Unban member
+// @Security ApiKeyAuth
+// @Tags room
+// @Description Lift a ban in the room
+// @ID unban-member
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Param user_id path int true "User ID"
+// @Success 200 {object} StatusResponse
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/bans/{user_id} [delete]
+func (h *Handler) unbanMember(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, targetId, ok := parseRoomMemberParams(c)
+	if !ok {
+		return
+	}
+
+	if err := h.services.Room.UnbanMember(roomId, userId, targetId); err != nil {
+		newRoomErrorResponse(c, err, "ban not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// @Summary Get room bans
+// @Security ApiKeyAuth
+// @Tags room
+// @Description Get banned users of the room
+// @ID get-room-bans
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Success 200 {object} getRoomBansResponse
+// @Failure 400,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/bans [get]
+func (h *Handler) getRoomBans(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	bans, err := h.services.Room.GetRoomBans(roomId, userId)
+	if err != nil {
+		newRoomErrorResponse(c, err, "room not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, getRoomBansResponse{
+		Data: bans,
+	})
+}
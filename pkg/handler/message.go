@@ -1,31 +1,57 @@
 package handler
 
 import (
-	"github.com/firstproject/talk-together-app/pkg/middleware"
+	"errors"
+	"github.com/firstproject/talk-together-app/model"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strconv"
 )
 
+const (
+	defaultMessagesLimit = 50
+	maxMessagesLimit     = 100
+)
+
 // @Summary Get room message
 // @Security ApiKeyAuth
 // @Tags messages
-// @Description Get room messages
+// @Description Get room messages page. Use prev_cursor as before to load older messages and next_cursor as after to load newer ones
 // @ID get-room-messages
 // @Accept json
 // @Produce json
-// @Success 200 {object} StatusResponse
-// @Failure 400,404 {object} errorResponse
+// @Param room_id path int true "Room ID"
+// @Param before query int false "Return messages older than this message id"
+// @Param after query int false "Return messages newer than this message id"
+// @Param limit query int false "Page size (default 50, max 100)"
+// @Success 200 {object} model.MessagePage
+// @Failure 400,403,404 {object} errorResponse
 // @Failure 500 {object} errorResponse
-// @Router /api/messages/room_id/{room_id} [get]
+// @Router /api/messages/room/{room_id} [get]
 func (h *Handler) getRoomMessages(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	roomId, err := strconv.Atoi(c.Param("room_id"))
 	if err != nil {
 		newErrorResponse(c, http.StatusBadRequest, " invalid room id")
 		return
 	}
 
-	messages, err := h.services.GetRoomMessages(roomId)
+	if !h.requireRoomMember(c, roomId, userId) {
+		return
+	}
+
+	page, err := parseMessagePageQuery(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	messages, err := h.services.GetRoomMessagesPage(roomId, page)
 	if err != nil {
 		newErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
@@ -34,27 +60,62 @@ func (h *Handler) getRoomMessages(c *gin.Context) {
 	c.JSON(http.StatusOK, messages)
 }
 
+func parseMessagePageQuery(c *gin.Context) (model.MessagePageQuery, error) {
+	page := model.MessagePageQuery{Limit: defaultMessagesLimit}
+
+	var err error
+	if value := c.Query("before"); value != "" {
+		if page.Before, err = strconv.Atoi(value); err != nil || page.Before <= 0 {
+			return page, errors.New("invalid before cursor")
+		}
+	}
+
+	if value := c.Query("after"); value != "" {
+		if page.After, err = strconv.Atoi(value); err != nil || page.After <= 0 {
+			return page, errors.New("invalid after cursor")
+		}
+	}
+
+	if page.Before > 0 && page.After > 0 {
+		return page, errors.New("before and after cannot be used together")
+	}
+
+	if value := c.Query("limit"); value != "" {
+		if page.Limit, err = strconv.Atoi(value); err != nil || page.Limit <= 0 {
+			return page, errors.New("invalid limit")
+		}
+		if page.Limit > maxMessagesLimit {
+			page.Limit = maxMessagesLimit
+		}
+	}
+
+	return page, nil
+}
+
 // @Summary Send message
 // @Security ApiKeyAuth
 // @tags messages
-// @Description Send message
+// @Description Send message. Set parent_id to reply in the thread of a top-level message.
+// @Description attachment_ids link files uploaded to the same room; content may be empty when attachments are present
 // @ID send-message
 // @Accept json
 // @Produce json
 // @Success 200 {object} map[string]interface{}
-// @Failure 400,404 {object} errorResponse
+// @Failure 400,403,404 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Router /api/messages [post]
 func (h *Handler) sendMessage(c *gin.Context) {
-	userId, err := middleware.getUserId(c)
+	userId, err := getUserId(c)
 	if err != nil {
 		newErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	var input struct {
-		Room    int    `json:"room" binding:"required"`
-		Content string `json:"content" binding:"required"`
+		Room          int    `json:"room" binding:"required"`
+		Content       string `json:"content"`
+		ParentId      *int   `json:"parent_id"`
+		AttachmentIds []int  `json:"attachment_ids"`
 	}
 
 	if err := c.BindJSON(&input); err != nil {
@@ -62,9 +123,19 @@ func (h *Handler) sendMessage(c *gin.Context) {
 		return
 	}
 
-	id, err := h.services.CreateMessage(input.Room, userId, input.Content)
+	if input.Content == "" && len(input.AttachmentIds) == 0 {
+		newErrorResponse(c, http.StatusBadRequest, "content cannot be empty")
+		return
+	}
+
+	var id int
+	if input.ParentId != nil {
+		id, err = h.services.CreateReply(input.Room, *input.ParentId, userId, input.Content, input.AttachmentIds)
+	} else {
+		id, err = h.services.CreateMessage(input.Room, userId, input.Content, input.AttachmentIds)
+	}
 	if err != nil {
-		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		newRoomErrorResponse(c, err, "room not found")
 		return
 	}
 
@@ -82,11 +153,11 @@ func (h *Handler) sendMessage(c *gin.Context) {
 // @Produce json
 // @Param id path int true "Message ID"
 // @Success 200 {object} StatusResponse
-// @Failure 400,404 {object} errorResponse
+// @Failure 400,403,404 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Router /api/messages/{id} [delete]
 func (h *Handler) deleteMessage(c *gin.Context) {
-	userId, err := middleware.getUserId(c)
+	userId, err := getUserId(c)
 	if err != nil {
 		newErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
@@ -100,7 +171,7 @@ func (h *Handler) deleteMessage(c *gin.Context) {
 
 	err = h.services.DeleteMessage(messageId, userId)
 	if err != nil {
-		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		newRoomErrorResponse(c, err, "message not found")
 		return
 	}
 
@@ -117,18 +188,18 @@ type updateMessageInput struct {
 // @Summary Update message
 // @Security ApiKeyAuth
 // @Tags messages
-// @Description Update message content
+// @Description Update message content. Only the author can edit, and only while their role still allows writing to the room
 // @ID update-message
 // @Accept json
 // @Produce json
 // @Param id path int true "Message ID"
 // @Param input body updateMessageInput true "Update input"
 // @Success 200 {object} StatusResponse
-// @Failure 400,404 {object} errorResponse
+// @Failure 400,403,404 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Router /api/messages/{id} [patch]
 func (h *Handler) updateMessage(c *gin.Context) {
-	userId, err := middleware.getUserId(c)
+	userId, err := getUserId(c)
 	if err != nil {
 		newErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
@@ -158,7 +229,7 @@ func (h *Handler) updateMessage(c *gin.Context) {
 
 	err = h.services.UpdateMessage(messageId, userId, input.Content)
 	if err != nil {
-		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		newRoomErrorResponse(c, err, "message not found")
 		return
 	}
 
@@ -167,3 +238,86 @@ func (h *Handler) updateMessage(c *gin.Context) {
 	})
 
 }
+
+// @Summary Get message thread
+// @Security ApiKeyAuth
+// @Tags messages
+// @Description Get the parent message and a page of its replies. Cursors work as in room history
+// @ID get-message-thread
+// @Accept json
+// @Produce json
+// @Param id path int true "Parent message ID"
+// @Param before query int false "Return replies older than this id"
+// @Param after query int false "Return replies newer than this id"
+// @Param limit query int false "Page size, default 50, max 100"
+// @Success 200 {object} model.ThreadPage
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/messages/{id}/thread [get]
+func (h *Handler) getMessageThread(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	messageId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid message id")
+		return
+	}
+
+	page, err := parseMessagePageQuery(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	thread, err := h.services.GetThreadPage(messageId, userId, page)
+	if err != nil {
+		newRoomErrorResponse(c, err, "message not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, thread)
+}
+
+type getMessageRevisionsResponse struct {
+	Data []model.MessageRevision `json:"data"`
+}
+
+// @Summary Get message revisions
+// @Security ApiKeyAuth
+// @Tags messages
+// @Description Get previous versions of an edited or deleted message. Room moderators only
+// @ID get-message-revisions
+// @Accept json
+// @Produce json
+// @Param id path int true "Message ID"
+// @Success 200 {object} getMessageRevisionsResponse
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/messages/{id}/revisions [get]
+func (h *Handler) getMessageRevisions(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	messageId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid message id")
+		return
+	}
+
+	revisions, err := h.services.GetMessageRevisions(messageId, userId)
+	if err != nil {
+		newRoomErrorResponse(c, err, "message not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, getMessageRevisionsResponse{
+		Data: revisions,
+	})
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseMessagePageQuery(t *testing.T) {
+	c, _ := CreateTestContext("GET", "/api/messages/room/1?before=10&limit=500", "")
+
+	page, err := parseMessagePageQuery(c)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, page.Before)
+	assert.Equal(t, 0, page.After)
+	assert.Equal(t, maxMessagesLimit, page.Limit)
+}
+
+func TestParseMessagePageQuery_Defaults(t *testing.T) {
+	c, _ := CreateTestContext("GET", "/api/messages/room/1", "")
+
+	page, err := parseMessagePageQuery(c)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultMessagesLimit, page.Limit)
+}
+
+func TestParseMessagePageQuery_Invalid(t *testing.T) {
+	for _, query := range []string{"before=1&after=2", "before=abc", "after=-1", "limit=0"} {
+		c, _ := CreateTestContext("GET", "/api/messages/room/1?"+query, "")
+
+		_, err := parseMessagePageQuery(c)
+		assert.Error(t, err, query)
+	}
+}
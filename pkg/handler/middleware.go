@@ -2,6 +2,7 @@ package handler
 
 import (
 	"errors"
+	"github.com/firstproject/talk-together-app/model"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strings"
@@ -10,6 +11,11 @@ import (
 const (
 	authorizationHeader = "Authorization"
 	userCtx             = "userId"
+	tokenCtx            = "accessToken"
+
+	wsProtocolHeader = "Sec-WebSocket-Protocol"
+	wsBearerProtocol = "bearer"
+	wsTicketQuery    = "ticket"
 )
 
 func (h *Handler) userIdentity(c *gin.Context) {
@@ -30,12 +36,13 @@ func (h *Handler) userIdentity(c *gin.Context) {
 		return
 	}
 
-	userId, err := h.services.Authorization.ParseToken(headerParts[1])
+	token, err := h.services.Authorization.ParseToken(headerParts[1])
 	if err != nil {
 		newErrorResponse(c, http.StatusUnauthorized, err.Error())
 		return
 	}
-	c.Set(userCtx, userId)
+	c.Set(userCtx, token.User)
+	c.Set(tokenCtx, token)
 }
 
 func getUserId(c *gin.Context) (int, error) {
@@ -51,3 +58,52 @@ func getUserId(c *gin.Context) (int, error) {
 
 	return idInt, nil
 }
+
+func getAccessToken(c *gin.Context) (model.AccessToken, error) {
+	token, ok := c.Get(tokenCtx)
+	if !ok {
+		return model.AccessToken{}, errors.New("access token not found")
+	}
+
+	accessToken, ok := token.(model.AccessToken)
+	if !ok {
+		return model.AccessToken{}, errors.New("access token is of invalid type")
+	}
+
+	return accessToken, nil
+}
+
+// Определяет пользователя WebSocket соединения.
+// Браузер не может передать заголовок Authorization, поэтому токен принимается
+// в Sec-WebSocket-Protocol ("bearer, <token>") либо одноразовым тикетом в query.
+// Возвращает токен с сессией соединения и подпротокол, который нужно подтвердить в ответе на upgrade.
+func (h *Handler) wsUserIdentity(c *gin.Context, roomId int) (model.AccessToken, string, error) {
+	if ticket := c.Query(wsTicketQuery); ticket != "" {
+		userId, session, err := h.services.Ticket.RedeemTicket(ticket, roomId)
+		if err != nil {
+			return model.AccessToken{}, "", err
+		}
+		return model.AccessToken{User: userId, Session: session}, "", nil
+	}
+
+	header := c.GetHeader(wsProtocolHeader)
+	if header == "" {
+		return model.AccessToken{}, "", errors.New("empty auth credentials")
+	}
+
+	protocols := strings.Split(header, ",")
+	for i := range protocols {
+		protocols[i] = strings.TrimSpace(protocols[i])
+	}
+
+	if len(protocols) != 2 || protocols[0] != wsBearerProtocol || protocols[1] == "" {
+		return model.AccessToken{}, "", errors.New("invalid websocket protocol header")
+	}
+
+	token, err := h.services.Authorization.ParseToken(protocols[1])
+	if err != nil {
+		return model.AccessToken{}, "", err
+	}
+
+	return token, wsBearerProtocol, nil
+}
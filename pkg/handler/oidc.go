@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"errors"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/firstproject/talk-together-app/pkg/service"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcCookiePath  = "/auth/oidc"
+)
+
+// @Summary SSO providers
+// @Tags auth
+// @Description List configured single sign-on providers
+// @ID oidc-providers
+// @Produce json
+// @Success 200 {array} model.OIDCProvider
+// @Router /auth/oidc/providers [get]
+func (h *Handler) getOIDCProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, h.services.OIDC.Providers())
+}
+
+// @Summary SSO login
+// @Tags auth
+// @Description Redirect to the identity provider. The login state is bound to the browser with a cookie, so the callback must be opened in the same browser
+// @ID oidc-login
+// @Param provider path string true "provider id"
+// @Success 302
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /auth/oidc/{provider}/login [get]
+func (h *Handler) oidcLogin(c *gin.Context) {
+	location, state, err := h.services.OIDC.StartLogin(c.Request.Context(), c.Param("provider"))
+	if errors.Is(err, service.ErrUnknownProvider) {
+		newErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	setOIDCStateCookie(c, state)
+	c.Redirect(http.StatusFound, location)
+}
+
+// @Summary Link SSO account
+// @Security ApiKeyAuth
+// @Tags auth
+// @Description Start linking an identity provider account to the signed-in user. Open the returned url in the same browser; the callback then signs in as this user
+// @ID oidc-link
+// @Produce json
+// @Param provider path string true "provider id"
+// @Success 200 {object} model.OIDCRedirect
+// @Failure 401,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /auth/oidc/{provider}/link [post]
+func (h *Handler) oidcLink(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	location, state, err := h.services.OIDC.StartLink(c.Request.Context(), c.Param("provider"), userId)
+	if errors.Is(err, service.ErrUnknownProvider) {
+		newErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	setOIDCStateCookie(c, state)
+	c.JSON(http.StatusOK, model.OIDCRedirect{URL: location})
+}
+
+// @Summary SSO callback
+// @Tags auth
+// @Description Finish the login with the code returned by the identity provider. A user is created on first login. If the email belongs to an existing account, the login is rejected with 409: its owner signs in and links the provider through /auth/oidc/{provider}/link. With two-factor authentication enabled the response carries a challenge token for /auth/2fa/verify
+// @ID oidc-callback
+// @Produce json
+// @Param provider path string true "provider id"
+// @Param code query string true "authorization code"
+// @Param state query string true "login state"
+// @Success 200 {object} model.SignInResult
+// @Failure 400,401,403,404,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /auth/oidc/{provider}/callback [get]
+func (h *Handler) oidcCallback(c *gin.Context) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		newErrorResponse(c, http.StatusUnauthorized, "identity provider returned "+providerErr)
+		return
+	}
+
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		newErrorResponse(c, http.StatusBadRequest, "code and state are required")
+		return
+	}
+
+	// Без привязки к браузеру злоумышленник мог бы подсунуть жертве вход в свой аккаунт
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil || cookie != state {
+		newErrorResponse(c, http.StatusBadRequest, service.ErrInvalidOIDCState.Error())
+		return
+	}
+	c.SetCookie(oidcStateCookie, "", -1, oidcCookiePath, "", isSecureRequest(c), true)
+
+	result, err := h.services.OIDC.CompleteLogin(c.Request.Context(), c.Param("provider"), code, state)
+	switch {
+	case errors.Is(err, service.ErrInvalidOIDCState):
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, service.ErrUnknownProvider):
+		newErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, service.ErrOIDCLoginFailed):
+		newErrorResponse(c, http.StatusUnauthorized, err.Error())
+		return
+	case errors.Is(err, service.ErrEmailNotVerified):
+		newErrorResponse(c, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, repository.ErrIdentityLinked), errors.Is(err, repository.ErrEmailTaken):
+		newErrorResponse(c, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func setOIDCStateCookie(c *gin.Context, state string) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, 600, oidcCookiePath, "", isSecureRequest(c), true)
+}
+
+func isSecureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
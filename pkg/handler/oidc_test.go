@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestOIDCCallback_RequiresStateCookie(t *testing.T) {
+	for _, cookie := range []string{"", "other-state"} {
+		c, w := CreateTestContext("GET", "/auth/oidc/corp/callback?code=abc&state=state-1", "")
+		c.Params = gin.Params{{Key: "provider", Value: "corp"}}
+		if cookie != "" {
+			c.Request.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: cookie})
+		}
+
+		handler := &Handler{}
+		handler.oidcCallback(c)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, cookie)
+	}
+}
+
+func TestOIDCCallback_ProviderError(t *testing.T) {
+	c, w := CreateTestContext("GET", "/auth/oidc/corp/callback?error=access_denied&state=state-1", "")
+	c.Params = gin.Params{{Key: "provider", Value: "corp"}}
+
+	handler := &Handler{}
+	handler.oidcCallback(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "access_denied")
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+type getPinnedMessagesResponse struct {
+	Data []model.PinnedMessage `json:"data"`
+}
+
+// @Summary Pin message
+// @Security ApiKeyAuth
+// @Tags messages
+// @Description Pin message in its room
+// @ID pin-message
+// @Accept json
+// @Produce json
+// @Param id path int true "Message ID"
+// @Success 200 {object} StatusResponse
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/messages/{id}/pin [put]
+func (h *Handler) pinMessage(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	messageId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid message id")
+		return
+	}
+
+	if err := h.services.Message.PinMessage(messageId, userId); err != nil {
+		newRoomErrorResponse(c, err, "message not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// @Summary Unpin message
+// @Security ApiKeyAuth
+// @Tags messages
+// @Description Unpin message in its room
+// @ID unpin-message
+// @Accept json
+// @Produce json
+// @Param id path int true "Message ID"
+// @Success 200 {object} StatusResponse
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/messages/{id}/pin [delete]
+func (h *Handler) unpinMessage(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	messageId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid message id")
+		return
+	}
+
+	if err := h.services.Message.UnpinMessage(messageId, userId); err != nil {
+		newRoomErrorResponse(c, err, "pinned message not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// @Summary Get pinned messages
+// @Security ApiKeyAuth
+// @Tags room
+// @Description Get pinned messages of the room, newest first
+// @ID get-pinned-messages
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Success 200 {object} getPinnedMessagesResponse
+// @Failure 400,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/pins [get]
+func (h *Handler) getPinnedMessages(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	if !h.requireRoomMember(c, roomId, userId) {
+		return
+	}
+
+	pinned, err := h.services.Message.GetPinnedMessages(roomId)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, getPinnedMessagesResponse{
+		Data: pinned,
+	})
+}
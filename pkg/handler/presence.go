@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Как часто узел ищет пользователей с истекшим статусом
+const presenceSweepInterval = 15 * time.Second
+
+type getRoomPresenceResponse struct {
+	Data []model.Presence `json:"data"`
+}
+
+// @Summary Get room presence
+// @Security ApiKeyAuth
+// @Tags room
+// @Description Get online and away users of the room across all nodes
+// @ID get-room-presence
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Success 200 {object} getRoomPresenceResponse
+// @Failure 400,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/presence [get]
+func (h *Handler) getRoomPresence(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	if !h.requireRoomMember(c, roomId, userId) {
+		return
+	}
+
+	presence, err := h.services.Presence.GetRoomPresence(roomId)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, getRoomPresenceResponse{
+		Data: presence,
+	})
+}
+
+// Периодически переводит в offline пользователей, у которых истек heartbeat,
+// и рассылает presence.changed в их комнаты
+func (h *Handler) RunPresenceSweeper(ctx context.Context) {
+	ticker := time.NewTicker(presenceSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			offline, err := h.services.Presence.SweepExpired()
+			if err != nil {
+				logrus.Errorf("presence sweep error: %s", err.Error())
+			}
+
+			for _, presence := range offline {
+				h.broadcastEvent(model.EventPresenceChanged, presence.Room, presence)
+			}
+		}
+	}
+}
+
+func (h *Handler) presenceConnect(client *model.Client) {
+	presence, changed, err := h.services.Presence.Connect(client.Room, client.User)
+	h.notifyPresence(presence, changed, err)
+}
+
+func (h *Handler) presenceDisconnect(client *model.Client) {
+	presence, changed, err := h.services.Presence.Disconnect(client.Room, client.User)
+	h.notifyPresence(presence, changed, err)
+}
+
+func (h *Handler) handlePresenceHeartbeatEvent(client *model.Client) {
+	presence, changed, err := h.services.Presence.Heartbeat(client.Room, client.User)
+	h.notifyPresence(presence, changed, err)
+}
+
+func (h *Handler) handlePresenceUpdateEvent(client *model.Client, event *model.Event) {
+	var input model.PresenceUpdateInput
+	if err := event.Decode(&input); err != nil {
+		sendEventError(client, "invalid presence.update data")
+		return
+	}
+
+	presence, changed, err := h.services.Presence.SetStatus(client.Room, client.User, input.Status)
+	if err != nil {
+		sendEventError(client, err.Error())
+		return
+	}
+
+	h.notifyPresence(presence, changed, nil)
+}
+
+func (h *Handler) notifyPresence(presence model.Presence, changed bool, err error) {
+	if err != nil {
+		logrus.Errorf("presence error for user %d in room %d: %s", presence.User, presence.Room, err.Error())
+		return
+	}
+
+	if changed {
+		h.broadcastEvent(model.EventPresenceChanged, presence.Room, presence)
+	}
+}
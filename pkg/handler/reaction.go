@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// @Summary Add reaction
+// @Security ApiKeyAuth
+// @Tags messages
+// @Description React to a message with an emoji. Repeating the same reaction has no effect
+// @ID add-reaction
+// @Accept json
+// @Produce json
+// @Param id path int true "Message ID"
+// @Param emoji path string true "Emoji"
+// @Success 200 {object} StatusResponse
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/messages/{id}/reactions/{emoji} [put]
+func (h *Handler) addReaction(c *gin.Context) {
+	h.changeReaction(c, h.services.Message.AddReaction)
+}
+
+// @Summary Remove reaction
+// @Security ApiKeyAuth
+// @Tags messages
+// @Description Remove own emoji reaction from a message
+// @ID remove-reaction
+// @Accept json
+// @Produce json
+// @Param id path int true "Message ID"
+// @Param emoji path string true "Emoji"
+// @Success 200 {object} StatusResponse
+// @Failure 400,403,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/messages/{id}/reactions/{emoji} [delete]
+func (h *Handler) removeReaction(c *gin.Context) {
+	h.changeReaction(c, h.services.Message.RemoveReaction)
+}
+
+func (h *Handler) changeReaction(c *gin.Context, change func(messageId, userId int, emoji string) error) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	messageId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid message id")
+		return
+	}
+
+	if err := change(messageId, userId, c.Param("emoji")); err != nil {
+		newRoomErrorResponse(c, err, "message not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"errors"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+type getRoomReceiptsResponse struct {
+	Data []model.ReadReceipt `json:"data"`
+}
+
+// @Summary Mark room as read
+// @Security ApiKeyAuth
+// @Tags room
+// @Description Advance the user's read cursor in the room up to the given message
+// @ID mark-room-read
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Param input body model.MarkReadInput true "Last read message"
+// @Success 200 {object} model.ReadReceipt
+// @Failure 400,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/read [post]
+func (h *Handler) markRoomRead(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	var input model.MarkReadInput
+	if err := c.BindJSON(&input); err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.requireRoomMember(c, roomId, userId) {
+		return
+	}
+
+	receipt, err := h.services.Receipt.MarkRead(userId, roomId, input.MessageId)
+	if err != nil {
+		if errors.Is(err, repository.ErrMessageNotInRoom) {
+			newErrorResponse(c, http.StatusNotFound, err.Error())
+		} else {
+			newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, receipt)
+}
+
+// @Summary Get room read receipts
+// @Security ApiKeyAuth
+// @Tags room
+// @Description Get last read message of every participant, used to render "seen by"
+// @ID get-room-receipts
+// @Accept json
+// @Produce json
+// @Param id path int true "Room ID"
+// @Success 200 {object} getRoomReceiptsResponse
+// @Failure 400,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/receipts [get]
+func (h *Handler) getRoomReceipts(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	if !h.requireRoomMember(c, roomId, userId) {
+		return
+	}
+
+	receipts, err := h.services.Receipt.GetRoomReceipts(roomId)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, getRoomReceiptsResponse{
+		Data: receipts,
+	})
+}
@@ -4,7 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/firstproject/talk-together-app/model"
-	"github.com/firstproject/talk-together-app/pkg/middleware"
+	"github.com/firstproject/talk-together-app/pkg/service"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strconv"
@@ -23,7 +23,7 @@ import (
 // @Produce json
 // @Router /api/room [post]
 func (h *Handler) createRoom(c *gin.Context) {
-	userId, err := middleware.getUserId(c)
+	userId, err := getUserId(c)
 	if err != nil {
 		newErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
@@ -37,7 +37,11 @@ func (h *Handler) createRoom(c *gin.Context) {
 
 	id, err := h.services.Room.CreateRoom(userId, input)
 	if err != nil {
-		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		if errors.Is(err, service.ErrInvalidVisibility) {
+			newErrorResponse(c, http.StatusBadRequest, err.Error())
+		} else {
+			newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
@@ -51,6 +55,10 @@ type getAllRoomsResponse struct {
 	Data []model.Room `json:"data"`
 }
 
+type getMyRoomsResponse struct {
+	Data []model.RoomListItem `json:"data"`
+}
+
 // @Summary Get all rooms
 // @Security ApiKeyAuth
 // @Tags room
@@ -58,12 +66,12 @@ type getAllRoomsResponse struct {
 // @ID get-all-rooms
 // @Accept json
 // @Produce json
-// @Success 200 {object} getAllRoomsResponse
+// @Success 200 {object} getMyRoomsResponse
 // @Failure 400,404 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Router /api/room [get]
 func (h *Handler) getAllRooms(c *gin.Context) {
-	userId, err := middleware.getUserId(c)
+	userId, err := getUserId(c)
 	if err != nil {
 		newErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
@@ -75,8 +83,24 @@ func (h *Handler) getAllRooms(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, getAllRoomsResponse{
-		Data: rooms,
+	roomIds := make([]int, len(rooms))
+	for i, room := range rooms {
+		roomIds[i] = room.Id
+	}
+
+	unread, err := h.services.Receipt.GetUnreadCounts(userId, roomIds)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	items := make([]model.RoomListItem, len(rooms))
+	for i, room := range rooms {
+		items[i] = model.RoomListItem{Room: room, UnreadCount: unread[room.Id]}
+	}
+
+	c.JSON(http.StatusOK, getMyRoomsResponse{
+		Data: items,
 	})
 }
 
@@ -92,7 +116,7 @@ func (h *Handler) getAllRooms(c *gin.Context) {
 // @Failure 500 {object} errorResponse
 // @Router /api/room/search [get]
 func (h *Handler) searchRoomByName(c *gin.Context) {
-	_, err := middleware.getUserId(c)
+	_, err := getUserId(c)
 	if err != nil {
 		newErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
@@ -132,7 +156,7 @@ type getRoomResponse struct {
 // @Failure 500 {object} errorResponse
 // @Router /api/room/:id [get]
 func (h *Handler) getRoomById(c *gin.Context) {
-	_, err := middleware.getUserId(c)
+	userId, err := getUserId(c)
 	if err != nil {
 		newErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
@@ -154,6 +178,19 @@ func (h *Handler) getRoomById(c *gin.Context) {
 		return
 	}
 
+	// Приватная комната не видна тем, кто в ней не состоит
+	if room.Visibility == model.RoomPrivate {
+		isMember, err := h.services.Room.IsMember(id, userId)
+		if err != nil {
+			newErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !isMember {
+			newErrorResponse(c, http.StatusNotFound, "room not found")
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, getRoomResponse{
 		Data: room,
 	})
@@ -167,7 +204,7 @@ func (h *Handler) getRoomById(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Success 200 {object} StatusResponse
-// @Failure 400,404 {object} errorResponse
+// @Failure 400,403,404 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Router /api/room/:id [put]
 func (h *Handler) updateRoom(c *gin.Context) {
@@ -177,7 +214,7 @@ func (h *Handler) updateRoom(c *gin.Context) {
 		return
 	}
 
-	userId, err := middleware.getUserId(c)
+	userId, err := getUserId(c)
 	if err != nil {
 		newErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
@@ -189,13 +226,14 @@ func (h *Handler) updateRoom(c *gin.Context) {
 		return
 	}
 
-	err = h.services.Room.UpdateRoom(userId, id, input)
+	if err := input.Validate(); err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = h.services.Room.UpdateRoom(id, userId, input)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			newErrorResponse(c, http.StatusNotFound, "room not found")
-		} else {
-			newErrorResponse(c, http.StatusInternalServerError, err.Error())
-		}
+		newRoomErrorResponse(c, err, "room not found")
 		return
 	}
 	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
@@ -209,7 +247,7 @@ func (h *Handler) updateRoom(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Success 200 {object} StatusResponse
-// @Failure 400,404 {object} errorResponse
+// @Failure 400,403,404 {object} errorResponse
 // @Failure 500 {object} errorResponse
 // @Router /api/room/:id [delete]
 func (h *Handler) deleteRoom(c *gin.Context) {
@@ -219,19 +257,15 @@ func (h *Handler) deleteRoom(c *gin.Context) {
 		return
 	}
 
-	userId, err := middleware.getUserId(c)
+	userId, err := getUserId(c)
 	if err != nil {
 		newErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	err = h.services.Room.DeleteRoom(id, userId)
+	err = h.services.Room.DeleteRoom(userId, id)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			newErrorResponse(c, http.StatusNotFound, "room not found")
-		} else {
-			newErrorResponse(c, http.StatusInternalServerError, err.Error())
-		}
+		newRoomErrorResponse(c, err, "room not found")
 		return
 	}
 
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"errors"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// @Summary Search messages
+// @Security ApiKeyAuth
+// @Tags messages
+// @Description Full-text search across messages of the rooms the user belongs to, ordered by relevance.
+// @Description q supports "quoted phrases", OR and -exclusions. headline is HTML-escaped with matches wrapped in <mark>
+// @ID search-messages
+// @Accept json
+// @Produce json
+// @Param q query string true "Search query"
+// @Param room query int false "Room ID"
+// @Param author query int false "Author user ID"
+// @Param from query string false "Created at or after, RFC 3339"
+// @Param to query string false "Created before, RFC 3339"
+// @Param limit query int false "Page size"
+// @Param offset query int false "Offset from next_offset of the previous page"
+// @Success 200 {object} model.MessageSearchPage
+// @Failure 400,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/search/messages [get]
+func (h *Handler) searchMessages(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	search, err := parseMessageSearchQuery(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := h.services.SearchMessages(userId, search)
+	if err != nil {
+		newRoomErrorResponse(c, err, "room not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func parseMessageSearchQuery(c *gin.Context) (model.MessageSearchQuery, error) {
+	search := model.MessageSearchQuery{Query: c.Query("q"), Limit: defaultMessagesLimit}
+
+	var err error
+	if value := c.Query("room"); value != "" {
+		if search.Room, err = strconv.Atoi(value); err != nil || search.Room <= 0 {
+			return search, errors.New("invalid room")
+		}
+	}
+
+	if value := c.Query("author"); value != "" {
+		if search.Author, err = strconv.Atoi(value); err != nil || search.Author <= 0 {
+			return search, errors.New("invalid author")
+		}
+	}
+
+	if value := c.Query("from"); value != "" {
+		from, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return search, errors.New("invalid from date")
+		}
+		search.From = &from
+	}
+
+	if value := c.Query("to"); value != "" {
+		to, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return search, errors.New("invalid to date")
+		}
+		search.To = &to
+	}
+
+	if value := c.Query("limit"); value != "" {
+		if search.Limit, err = strconv.Atoi(value); err != nil || search.Limit <= 0 {
+			return search, errors.New("invalid limit")
+		}
+		if search.Limit > maxMessagesLimit {
+			search.Limit = maxMessagesLimit
+		}
+	}
+
+	if value := c.Query("offset"); value != "" {
+		if search.Offset, err = strconv.Atoi(value); err != nil || search.Offset < 0 {
+			return search, errors.New("invalid offset")
+		}
+	}
+
+	return search, nil
+}
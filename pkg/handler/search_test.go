@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseMessageSearchQuery(t *testing.T) {
+	c, _ := CreateTestContext("GET", "/api/search/messages?q=hello+world&room=3&author=7&from=2024-01-01T00:00:00Z&limit=500&offset=20", "")
+
+	search, err := parseMessageSearchQuery(c)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", search.Query)
+	assert.Equal(t, 3, search.Room)
+	assert.Equal(t, 7, search.Author)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), *search.From)
+	assert.Nil(t, search.To)
+	assert.Equal(t, maxMessagesLimit, search.Limit)
+	assert.Equal(t, 20, search.Offset)
+}
+
+func TestParseMessageSearchQuery_Invalid(t *testing.T) {
+	for _, query := range []string{"room=abc", "author=0", "from=yesterday", "to=2024-13-01", "limit=-1", "offset=-5"} {
+		c, _ := CreateTestContext("GET", "/api/search/messages?q=x&"+query, "")
+
+		_, err := parseMessageSearchQuery(c)
+		assert.Error(t, err, query)
+	}
+}
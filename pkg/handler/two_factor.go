@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/service"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// @Summary Two-factor sign in
+// @Tags auth
+// @Description Second sign-in step: exchange the challenge token and a code from the authenticator app or a recovery code for a token pair
+// @ID two-factor-verify
+// @Accept json
+// @Produce json
+// @Param input body model.TwoFactorSignInInput true "challenge and code"
+// @Success 200 {object} model.TokenPair
+// @Failure 400,401,429 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /auth/2fa/verify [post]
+func (h *Handler) verifyTwoFactor(c *gin.Context) {
+	var input model.TwoFactorSignInInput
+
+	if err := c.BindJSON(&input); err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tokens, err := h.services.Authorization.VerifyTwoFactor(input.ChallengeToken, input.Code)
+	if err != nil {
+		newTwoFactorErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// @Summary Start two-factor enrollment
+// @Security ApiKeyAuth
+// @Tags auth
+// @Description Generate a TOTP secret. It takes effect only after confirmation with a code from the authenticator app
+// @ID two-factor-enroll
+// @Produce json
+// @Success 200 {object} model.TwoFactorEnrollment
+// @Failure 401,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /auth/2fa/enroll [post]
+func (h *Handler) enrollTwoFactor(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	enrollment, err := h.services.Authorization.EnrollTwoFactor(userId)
+	if err != nil {
+		newTwoFactorErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// @Summary Confirm two-factor enrollment
+// @Security ApiKeyAuth
+// @Tags auth
+// @Description Enable two-factor authentication with a code from the authenticator app. Recovery codes are returned only once
+// @ID two-factor-confirm
+// @Accept json
+// @Produce json
+// @Param input body model.TwoFactorCodeInput true "authenticator code"
+// @Success 200 {object} model.RecoveryCodes
+// @Failure 400,401,409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /auth/2fa/confirm [post]
+func (h *Handler) confirmTwoFactor(c *gin.Context) {
+	userId, input, ok := parseTwoFactorCodeInput(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.services.Authorization.ConfirmTwoFactor(userId, input.Code)
+	if err != nil {
+		newTwoFactorErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+// @Summary Regenerate recovery codes
+// @Security ApiKeyAuth
+// @Tags auth
+// @Description Replace all recovery codes. Requires a code from the authenticator app
+// @ID two-factor-recovery-codes
+// @Accept json
+// @Produce json
+// @Param input body model.TwoFactorCodeInput true "authenticator code"
+// @Success 200 {object} model.RecoveryCodes
+// @Failure 400,401,409,429 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /auth/2fa/recovery-codes [post]
+func (h *Handler) regenerateRecoveryCodes(c *gin.Context) {
+	userId, input, ok := parseTwoFactorCodeInput(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.services.Authorization.RegenerateRecoveryCodes(userId, input.Code)
+	if err != nil {
+		newTwoFactorErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+// @Summary Disable two-factor authentication
+// @Security ApiKeyAuth
+// @Tags auth
+// @Description Disable two-factor authentication with a code from the authenticator app or a recovery code
+// @ID two-factor-disable
+// @Accept json
+// @Produce json
+// @Param input body model.TwoFactorCodeInput true "authenticator or recovery code"
+// @Success 200 {object} StatusResponse
+// @Failure 400,401,409,429 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /auth/2fa/disable [post]
+func (h *Handler) disableTwoFactor(c *gin.Context) {
+	userId, input, ok := parseTwoFactorCodeInput(c)
+	if !ok {
+		return
+	}
+
+	if err := h.services.Authorization.DisableTwoFactor(userId, input.Code); err != nil {
+		newTwoFactorErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+// @Summary Reset user's two-factor authentication
+// @Security ApiKeyAuth
+// @Tags admin
+// @Description Disable two-factor authentication of a user who lost both the device and the recovery codes. Administrators only
+// @ID admin-reset-two-factor
+// @Produce json
+// @Param user_id path int true "user id"
+// @Success 200 {object} StatusResponse
+// @Failure 400,403 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/admin/users/{user_id}/2fa [delete]
+func (h *Handler) resetTwoFactor(c *gin.Context) {
+	adminId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	userId, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	if err := h.services.Authorization.ResetTwoFactor(adminId, userId); err != nil {
+		newTwoFactorErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, StatusResponse{Status: "ok"})
+}
+
+func parseTwoFactorCodeInput(c *gin.Context) (int, model.TwoFactorCodeInput, bool) {
+	var input model.TwoFactorCodeInput
+
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return 0, input, false
+	}
+
+	if err := c.BindJSON(&input); err != nil {
+		newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return 0, input, false
+	}
+
+	return userId, input, true
+}
+
+func newTwoFactorErrorResponse(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		newErrorResponse(c, http.StatusNotFound, "user not found")
+	case errors.Is(err, service.ErrInvalidChallenge),
+		errors.Is(err, service.ErrInvalidTwoFactorCode):
+		newErrorResponse(c, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, service.ErrNotAdmin):
+		newErrorResponse(c, http.StatusForbidden, err.Error())
+	case errors.Is(err, service.ErrTwoFactorEnabled),
+		errors.Is(err, service.ErrTwoFactorNotEnabled),
+		errors.Is(err, service.ErrTwoFactorNotPending):
+		newErrorResponse(c, http.StatusConflict, err.Error())
+	case errors.Is(err, service.ErrTooManyAttempts):
+		newErrorResponse(c, http.StatusTooManyRequests, err.Error())
+	default:
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+	}
+}
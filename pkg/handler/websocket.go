@@ -1,30 +1,32 @@
 package handler
 
 import (
-	talk_together_app "github.com/firstproject/talk-together-app/hub"
+	"context"
+	"database/sql"
+	"errors"
 	"github.com/firstproject/talk-together-app/model"
 	"github.com/firstproject/talk-together-app/pkg/middleware/monitoring"
+	"github.com/firstproject/talk-together-app/pkg/service"
 	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
 	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
 	"net/http"
 	"strconv"
 )
 
-var hub *talk_together_app.Hub
-
-func init() {
-	hub = talk_together_app.NewHub()
-}
-
 // @Summary WebSocket для чат-комнаты
-// @Description Real-time WebSocket соединение для обмена сообщениями в комнате
+// @Description Real-time WebSocket соединение для обмена сообщениями в комнате.
+// @Description Пользователь определяется по JWT в заголовке Sec-WebSocket-Protocol ("bearer, <token>")
+// @Description или по одноразовому тикету из POST /api/room/{id}/ws-ticket
 // @Tags chat
-// @Param roomId path int true "ID комнаты" example(1)
-// @Param userId path int true "ID пользователя" example(1)
+// @Param id path int true "ID комнаты" example(1)
+// @Param ticket query string false "Одноразовый тикет"
 // @Success 101 "Switching Protocols"
 // @Failure 400 {object} errorResponse
+// @Failure 401,403 {object} errorResponse
 // @Failure 500 {object} errorResponse
-// @Router /ws/rooms/{roomId}/users/{userId} [get]
+// @Router /api/room/{id}/ws [get]
 func (h *Handler) handleWebSocket(c *gin.Context) {
 	roomId, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -32,17 +34,34 @@ func (h *Handler) handleWebSocket(c *gin.Context) {
 		return
 	}
 
-	userId, err := strconv.Atoi(c.Param("userId"))
+	token, protocol, err := h.wsUserIdentity(c, roomId)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	userId := token.User
+
+	isMember, err := h.services.Room.IsMember(roomId, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": service.ErrNotRoomMember.Error()})
 		return
 	}
+
 	if err := h.services.Client.AddClientToRoom(roomId, userId); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	conn, err := hub.Upgrader.Upgrade(c.Writer, c.Request, nil)
+	var responseHeader http.Header
+	if protocol != "" {
+		responseHeader = http.Header{wsProtocolHeader: {protocol}}
+	}
+
+	conn, err := h.hub.Upgrader.Upgrade(c.Writer, c.Request, responseHeader)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -52,47 +71,189 @@ func (h *Handler) handleWebSocket(c *gin.Context) {
 	defer monitoring.DecrementWebSocketConnections()
 
 	client := &model.Client{
-		Id:   userId,
-		Conn: conn,
-		Room: roomId,
-		User: userId,
-		Send: make(chan []byte, 256),
+		Id:      h.hub.NextClientId(),
+		Conn:    conn,
+		Room:    roomId,
+		User:    userId,
+		Session: token.Session,
+		Send:    make(chan []byte, 256),
 	}
 
-	hub.Register <- client
+	h.hub.Register <- client
+	h.presenceConnect(client)
 
 	go h.readPump(client)
 	go h.writePump(client)
 
 }
 
+type wsTicketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// @Summary Create WebSocket ticket
+// @Security ApiKeyAuth
+// @Tags chat
+// @Description Выдает одноразовый короткоживущий тикет для подключения к WebSocket комнаты
+// @ID create-ws-ticket
+// @Accept json
+// @Produce json
+// @Param id path int true "ID комнаты"
+// @Success 200 {object} wsTicketResponse
+// @Failure 400,404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/room/{id}/ws-ticket [post]
+func (h *Handler) createWsTicket(c *gin.Context) {
+	userId, err := getUserId(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	roomId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		newErrorResponse(c, http.StatusBadRequest, "invalid room id")
+		return
+	}
+
+	if _, err := h.services.Room.GetRoomById(roomId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			newErrorResponse(c, http.StatusNotFound, "room not found")
+		} else {
+			newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	if !h.requireRoomMember(c, roomId, userId) {
+		return
+	}
+
+	token, err := getAccessToken(c)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ticket, ttl, err := h.services.Ticket.IssueTicket(userId, roomId, token.Session)
+	if err != nil {
+		newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, wsTicketResponse{
+		Ticket:    ticket,
+		ExpiresIn: int(ttl.Seconds()),
+	})
+}
+
+// Закрывает WebSocket соединения сессий, отозванных на любом узле
+func (h *Handler) RunSessionRevocationListener(ctx context.Context) {
+	for sessionId := range h.services.Redis.SubscribeRevokedSessions(ctx) {
+		h.hub.DisconnectSession(sessionId)
+	}
+}
+
 func (h *Handler) readPump(client *model.Client) {
 	defer func() {
-		hub.Unregister <- client
+		h.hub.StopTyping(client.Room, client.User)
+		h.hub.Unregister <- client
 		client.Conn.Close()
+		h.presenceDisconnect(client)
+		if err := h.services.Client.RemoveClientFromRoom(client.Room, client.User); err != nil {
+			logrus.Errorf("failed to mark client %d disconnected from room %d: %s", client.User, client.Room, err.Error())
+		}
 	}()
 
 	for {
-		_, message, err := client.Conn.ReadMessage()
+		_, frame, err := client.Conn.ReadMessage()
 		if err != nil {
 			break
 		}
 
-		msgId, err := h.services.Message.CreateMessage(client.Room, client.Id, string(message))
+		event, err := model.ParseEvent(frame)
 		if err != nil {
+			sendEventError(client, err.Error())
 			continue
 		}
 
-		hub.Broadcast <- &model.Message{
-			Id:      msgId,
-			Room:    client.Room,
-			User:    client.User,
-			Content: string(message),
+		switch event.Type {
+		case model.EventMessageCreate:
+			h.handleCreateMessageEvent(client, event)
+		case model.EventPresenceHeartbeat:
+			h.handlePresenceHeartbeatEvent(client)
+		case model.EventPresenceUpdate:
+			h.handlePresenceUpdateEvent(client, event)
+		case model.EventTypingStart:
+			h.hub.StartTyping(client.Room, client.User)
+		case model.EventTypingStop:
+			h.hub.StopTyping(client.Room, client.User)
+		default:
+			sendEventError(client, "unknown event type: "+event.Type)
 		}
 	}
 
 }
 
+func (h *Handler) handleCreateMessageEvent(client *model.Client, event *model.Event) {
+	var input model.CreateMessageInput
+	if err := event.Decode(&input); err != nil {
+		sendEventError(client, "invalid message.create data")
+		return
+	}
+
+	if input.Content == "" && len(input.AttachmentIds) == 0 {
+		sendEventError(client, "content cannot be empty")
+		return
+	}
+
+	// Событие message.created или thread.reply придет в комнату через outbox и Kafka,
+	// как и для сообщений, отправленных через REST
+	var err error
+	if input.ParentId != nil {
+		_, err = h.services.Message.CreateReply(client.Room, *input.ParentId, client.User, input.Content, input.AttachmentIds)
+	} else {
+		_, err = h.services.Message.CreateMessage(client.Room, client.User, input.Content, input.AttachmentIds)
+	}
+	if err != nil {
+		sendEventError(client, err.Error())
+	}
+}
+
+// Рассылает эфемерное событие всем клиентам комнаты через Hub, минуя outbox
+func (h *Handler) broadcastEvent(eventType string, roomId int, data interface{}) {
+	if h.hub == nil {
+		return
+	}
+
+	event, err := model.NewEvent(eventType, roomId, data)
+	if err != nil {
+		logrus.Errorf("failed to build %s event: %s", eventType, err.Error())
+		return
+	}
+
+	h.hub.Broadcast <- event
+}
+
+// Отправляет событие error только указанному клиенту
+func sendEventError(client *model.Client, message string) {
+	event, err := model.NewEvent(model.EventError, client.Room, model.ErrorPayload{Message: message})
+	if err != nil {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	select {
+	case client.Send <- payload:
+	default:
+	}
+}
+
 func (h *Handler) writePump(client *model.Client) {
 	defer client.Conn.Close()
 
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"github.com/firstproject/talk-together-app/model"
 	"github.com/firstproject/talk-together-app/pkg/kafka"
 	"github.com/firstproject/talk-together-app/pkg/redis"
@@ -34,8 +35,8 @@ func (m *MockService) GetRoomClients(roomId int) ([]model.Client, error) {
 	return args.Get(0).([]model.Client), args.Error(1)
 }
 
-func (m *MockService) CreateMessage(roomId, userId int, content string) (int, error) {
-	args := m.Called(roomId, userId, content)
+func (m *MockService) CreateMessage(roomId, userId int, content string, attachmentIds []int) (int, error) {
+	args := m.Called(roomId, userId, content, attachmentIds)
 	return args.Int(0), args.Error(1)
 }
 
@@ -49,9 +50,64 @@ func (m *MockService) CreateUser(user model.User) (int, error) {
 	return args.Int(0), args.Error(1)
 }
 
-func (m *MockService) ParseToken(token string) (int, error) {
+func (m *MockService) GenerateToken(userName, password string) (model.SignInResult, error) {
+	args := m.Called(userName, password)
+	return args.Get(0).(model.SignInResult), args.Error(1)
+}
+
+func (m *MockService) VerifyTwoFactor(challengeToken, code string) (model.TokenPair, error) {
+	args := m.Called(challengeToken, code)
+	return args.Get(0).(model.TokenPair), args.Error(1)
+}
+
+func (m *MockService) RefreshToken(refreshToken string) (model.TokenPair, error) {
+	args := m.Called(refreshToken)
+	return args.Get(0).(model.TokenPair), args.Error(1)
+}
+
+func (m *MockService) ParseToken(token string) (model.AccessToken, error) {
 	args := m.Called(token)
-	return args.Int(0), args.Error(1)
+	return args.Get(0).(model.AccessToken), args.Error(1)
+}
+
+func (m *MockService) Logout(token model.AccessToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockService) LogoutAll(userId int) error {
+	args := m.Called(userId)
+	return args.Error(0)
+}
+
+func (m *MockService) JWKS() model.JSONWebKeySet {
+	args := m.Called()
+	return args.Get(0).(model.JSONWebKeySet)
+}
+
+func (m *MockService) EnrollTwoFactor(userId int) (model.TwoFactorEnrollment, error) {
+	args := m.Called(userId)
+	return args.Get(0).(model.TwoFactorEnrollment), args.Error(1)
+}
+
+func (m *MockService) ConfirmTwoFactor(userId int, code string) (model.RecoveryCodes, error) {
+	args := m.Called(userId, code)
+	return args.Get(0).(model.RecoveryCodes), args.Error(1)
+}
+
+func (m *MockService) RegenerateRecoveryCodes(userId int, code string) (model.RecoveryCodes, error) {
+	args := m.Called(userId, code)
+	return args.Get(0).(model.RecoveryCodes), args.Error(1)
+}
+
+func (m *MockService) DisableTwoFactor(userId int, code string) error {
+	args := m.Called(userId, code)
+	return args.Error(0)
+}
+
+func (m *MockService) ResetTwoFactor(adminId, userId int) error {
+	args := m.Called(adminId, userId)
+	return args.Error(0)
 }
 
 func (m *MockService) CreateRoom(userId int, room model.Room) (int, error) {
@@ -121,20 +177,57 @@ func TestHandleWebSocket_InvalidRoomId(t *testing.T) {
 
 }
 
-func TestHandleWebSocket_InvalidUserId(t *testing.T) {
+func TestHandleWebSocket_MissingCredentials(t *testing.T) {
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
-	c.Request = httptest.NewRequest("GET", "/ws/1/invalid", nil)
+	c.Request = httptest.NewRequest("GET", "/api/room/1/ws", nil)
 	c.Params = gin.Params{
 		{Key: "id", Value: "1"},
-		{Key: "userId", Value: "invalid"},
 	}
 
 	handler := &Handler{}
 
 	handler.handleWebSocket(c)
 
-	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestHandleWebSocket_InvalidProtocolHeader(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/api/room/1/ws", nil)
+	c.Request.Header.Set("Sec-WebSocket-Protocol", "chat")
+	c.Params = gin.Params{
+		{Key: "id", Value: "1"},
+	}
+
+	handler := &Handler{}
+
+	handler.handleWebSocket(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestHandleWebSocket_InvalidToken(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/api/room/1/ws", nil)
+	c.Request.Header.Set("Sec-WebSocket-Protocol", "bearer, broken-token")
+	c.Params = gin.Params{
+		{Key: "id", Value: "1"},
+	}
+
+	mockService := new(MockService)
+	mockService.On("ParseToken", "broken-token").Return(model.AccessToken{}, errors.New("token is malformed"))
+
+	handler := &Handler{
+		services: &service.Service{Authorization: mockService},
+	}
+
+	handler.handleWebSocket(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	mockService.AssertExpectations(t)
 }
 
 func TestHandleWebSocket_ServiceError(t *testing.T) {
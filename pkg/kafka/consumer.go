@@ -1,44 +1,104 @@
 package kafka
 
 import (
+	"context"
+	"errors"
 	"github.com/IBM/sarama"
 	talk_together_app "github.com/firstproject/talk-together-app/hub"
 	"github.com/firstproject/talk-together-app/model"
-	"github.com/goccy/go-json"
-	"log"
+	"github.com/sirupsen/logrus"
 )
 
+// Потребитель топика событий в составе consumer group.
+// Это единственный путь, по которому события чата попадают в Hub.
+// Смещения коммитятся после передачи сообщения в Hub, поэтому после рестарта
+// чтение продолжается с последнего обработанного сообщения.
+// Новая группа или группа с истекшими смещениями начинает с конца топика:
+// история комнат загружается из Postgres, а не проигрывается в открытые соединения.
+// При нескольких узлах партиции распределяются между ними, а доставку
+// во все узлы обеспечивает кластерный режим Hub
 type Consumer struct {
-	consumer sarama.Consumer
-	topic    string
-	hub      *talk_together_app.Hub
+	group sarama.ConsumerGroup
+	topic string
+	hub   *talk_together_app.Hub
 }
 
-func NewKafkaConsumer(brokers []string, topic string, hub *talk_together_app.Hub) (*Consumer, error) {
+func NewKafkaConsumer(brokers []string, topic, groupId string, hub *talk_together_app.Hub) (*Consumer, error) {
 	config := sarama.NewConfig()
-	consumer, err := sarama.NewConsumer(brokers, config)
+	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategySticky()}
+
+	group, err := sarama.NewConsumerGroup(brokers, groupId, config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Consumer{consumer, topic, hub}, nil
+	return &Consumer{group: group, topic: topic, hub: hub}, nil
 }
 
-func (c *Consumer) Start() {
-	partitionConsumer, err := c.consumer.ConsumePartition(c.topic, 0, sarama.OffsetNewest)
-	if err != nil {
-		log.Fatal(err)
+// Запускает чтение топика до отмены контекста.
+// Consume возвращается при каждой ребалансировке, поэтому вызывается в цикле
+func (c *Consumer) Start(ctx context.Context) {
+	go func() {
+		for err := range c.group.Errors() {
+			logrus.Errorf("kafka consumer group error: %s", err.Error())
+		}
+	}()
+
+	handler := &groupHandler{hub: c.hub}
+	for {
+		if err := c.group.Consume(ctx, []string{c.topic}, handler); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return
+			}
+			logrus.Errorf("kafka consume error: %s", err.Error())
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
 	}
+}
 
-	defer partitionConsumer.Close()
+func (c *Consumer) Close() error {
+	return c.group.Close()
+}
+
+// Обработчик сессии consumer group
+type groupHandler struct {
+	hub *talk_together_app.Hub
+}
+
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	logrus.Infof("kafka partitions assigned: %v", session.Claims())
+	return nil
+}
 
+func (h *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	logrus.Infof("kafka partitions revoked: %v", session.Claims())
+	return nil
+}
+
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for {
 		select {
-		case msg := <-partitionConsumer.Messages():
-			var message model.Message
-			if err := json.Unmarshal(msg.Value, &message); err != nil {
-				c.hub.Broadcast <- &message
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
 			}
+
+			event, err := model.ParseEvent(msg.Value)
+			if err != nil {
+				logrus.Errorf("failed to decode kafka event at offset %d: %s", msg.Offset, err.Error())
+				session.MarkMessage(msg, "")
+				continue
+			}
+
+			h.hub.Broadcast <- event
+			session.MarkMessage(msg, "")
+		case <-session.Context().Done():
+			return nil
 		}
 	}
 }
@@ -0,0 +1,76 @@
+package kafka
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	talk_together_app "github.com/firstproject/talk-together-app/hub"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func eventFrame(t *testing.T, roomId int) []byte {
+	event, err := model.NewEvent(model.EventMessageCreated, roomId, model.Message{Id: 1, Room: roomId})
+	require.NoError(t, err)
+	frame, err := json.Marshal(event)
+	require.NoError(t, err)
+	return frame
+}
+
+func TestGroupHandler_BroadcastsAndMarksMessages(t *testing.T) {
+	hub := talk_together_app.NewHub()
+	hub.Broadcast = make(chan *model.Event, 10)
+	session := &fakeSession{ctx: context.Background()}
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 10)}
+
+	claim.messages <- &sarama.ConsumerMessage{Offset: 10, Value: eventFrame(t, 3)}
+	claim.messages <- &sarama.ConsumerMessage{Offset: 11, Value: []byte("not an event")}
+	claim.messages <- &sarama.ConsumerMessage{Offset: 12, Value: eventFrame(t, 5)}
+	close(claim.messages)
+
+	handler := &groupHandler{hub: hub}
+	assert.NoError(t, handler.ConsumeClaim(session, claim))
+
+	// Нераспознанное сообщение пропускается, чтобы не блокировать партицию
+	assert.Equal(t, []int64{10, 11, 12}, session.marked)
+	require.Len(t, hub.Broadcast, 2)
+	assert.Equal(t, 3, (<-hub.Broadcast).Room)
+	assert.Equal(t, 5, (<-hub.Broadcast).Room)
+}
+
+func TestGroupHandler_StopsWhenSessionEnds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	session := &fakeSession{ctx: ctx}
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage)}
+
+	handler := &groupHandler{hub: talk_together_app.NewHub()}
+	assert.NoError(t, handler.ConsumeClaim(session, claim))
+	assert.Empty(t, session.marked)
+}
@@ -2,8 +2,7 @@ package kafka
 
 import (
 	"github.com/IBM/sarama"
-	"github.com/firstproject/talk-together-app/model"
-	"github.com/goccy/go-json"
+	"strconv"
 )
 
 type Producer struct {
@@ -14,6 +13,8 @@ type Producer struct {
 func NewKafkaProducer(brokers []string, topic string) (*Producer, error) {
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Partitioner = sarama.NewHashPartitioner
 
 	producer, err := sarama.NewSyncProducer(brokers, config)
 	if err != nil {
@@ -23,17 +24,23 @@ func NewKafkaProducer(brokers []string, topic string) (*Producer, error) {
 	return &Producer{producer: producer, topic: topic}, nil
 }
 
-func (p *Producer) SendMessage(message interface{}, m model.Message) error {
-	jsonMessage, err := json.Marshal(message)
-	if err != nil {
-		return err
-	}
-
+// Отправляет готовый payload с ключом по id комнаты,
+// поэтому все события комнаты попадают в одну партицию и сохраняют порядок
+func (p *Producer) Send(roomId int, payload []byte) error {
 	msg := &sarama.ProducerMessage{
 		Topic: p.topic,
-		Value: sarama.ByteEncoder(jsonMessage),
+		Key:   sarama.StringEncoder(roomKey(roomId)),
+		Value: sarama.ByteEncoder(payload),
 	}
 
-	_, _, err = p.producer.SendMessage(msg)
+	_, _, err := p.producer.SendMessage(msg)
 	return err
 }
+
+func (p *Producer) Close() error {
+	return p.producer.Close()
+}
+
+func roomKey(roomId int) string {
+	return strconv.Itoa(roomId)
+}
@@ -0,0 +1,40 @@
+package kafka
+
+import (
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestProducer_KeysByRoom(t *testing.T) {
+	var sent []*sarama.ProducerMessage
+	record := func(msg *sarama.ProducerMessage) error {
+		sent = append(sent, msg)
+		return nil
+	}
+
+	mock := mocks.NewSyncProducer(t, nil)
+	mock.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(record)
+	mock.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(record)
+	mock.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(record)
+	p := &Producer{producer: mock, topic: "chat"}
+
+	assert.NoError(t, p.Send(42, []byte(`{"a":1}`)))
+	assert.NoError(t, p.Send(42, []byte(`{"a":2}`)))
+	assert.NoError(t, p.Send(7, []byte(`{"a":3}`)))
+	assert.NoError(t, mock.Close())
+
+	partitioner := sarama.NewHashPartitioner("chat")
+	partitions := make([]int32, 0, len(sent))
+	for _, msg := range sent {
+		assert.Equal(t, "chat", msg.Topic)
+		partition, err := partitioner.Partition(msg, 16)
+		assert.NoError(t, err)
+		partitions = append(partitions, partition)
+	}
+
+	assert.Equal(t, sarama.StringEncoder("42"), sent[0].Key)
+	assert.Equal(t, sarama.StringEncoder("7"), sent[2].Key)
+	assert.Equal(t, partitions[0], partitions[1], "messages of one room share a partition")
+}
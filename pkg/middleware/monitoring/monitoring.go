@@ -39,6 +39,21 @@ var (
 		Name: "redis_operations_total",
 		Help: "Total number of Redis operations",
 	}, []string{"operation", "status"})
+
+	outboxPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "outbox_pending_events",
+		Help: "Current number of unsent outbox events",
+	})
+
+	outboxLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "outbox_lag_seconds",
+		Help: "Age of the oldest unsent outbox event in seconds",
+	})
+
+	outboxPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_events_published_total",
+		Help: "Total number of outbox publish attempts",
+	}, []string{"status"})
 )
 
 func PrometheusMiddleware() gin.HandlerFunc {
@@ -72,9 +87,18 @@ func DecrementWebSocketConnections() {
 }
 
 func IncrementKafkaMessagesSent(topic string) {
-	kafkaMessageSent.WithLabelValues().Inc()
+	kafkaMessageSent.WithLabelValues(topic).Inc()
 }
 
 func IncrementRedisOperations(operation, status string) {
 	redisOperations.WithLabelValues(operation, status).Inc()
 }
+
+func SetOutboxStats(pending int, lagSeconds float64) {
+	outboxPending.Set(float64(pending))
+	outboxLag.Set(lagSeconds)
+}
+
+func IncrementOutboxPublished(status string) {
+	outboxPublished.WithLabelValues(status).Inc()
+}
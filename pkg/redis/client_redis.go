@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"errors"
 	"fmt"
 	"github.com/firstproject/talk-together-app/model"
 	"github.com/firstproject/talk-together-app/pkg/middleware/monitoring"
@@ -8,6 +9,7 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
+	"strconv"
 	"time"
 )
 
@@ -29,26 +31,125 @@ func NewRedisClient(addr, password string, db int) *Client {
 	return &Client{client, context.Background()}
 }
 
-func (c *Client) CacheRoomMessages(roomId int, messages []model.Message) error {
-	key := fmt.Sprintf("room:%d:messages", roomId)
-	jsonData, err := json.Marshal(messages)
+// Последние сообщения комнаты вместе с поколением кеша, при котором они загружены
+type cachedRoomMessages struct {
+	Version  int64           `json:"version"`
+	Messages []cachedMessage `json:"messages"`
+}
+
+// Сообщение в кеше хранится вместе с ключами файлов вложений, которые не отдаются клиентам:
+// по ним после чтения из кеша подписываются ссылки
+type cachedMessage struct {
+	model.Message
+	Attachments []cachedAttachment `json:"attachments,omitempty"`
+}
+
+type cachedAttachment struct {
+	model.Attachment
+	StorageKey   string  `json:"storage_key"`
+	ThumbnailKey *string `json:"thumbnail_key,omitempty"`
+}
+
+func toCachedMessages(messages []model.Message) []cachedMessage {
+	cached := make([]cachedMessage, len(messages))
+	for i, message := range messages {
+		cached[i].Message = message
+		for _, attachment := range message.Attachments {
+			cached[i].Attachments = append(cached[i].Attachments, cachedAttachment{
+				Attachment:   attachment,
+				StorageKey:   attachment.StorageKey,
+				ThumbnailKey: attachment.ThumbnailKey,
+			})
+		}
+	}
+	return cached
+}
+
+func fromCachedMessages(cached []cachedMessage) []model.Message {
+	messages := make([]model.Message, len(cached))
+	for i, message := range cached {
+		messages[i] = message.Message
+		messages[i].Attachments = nil
+		for _, attachment := range message.Attachments {
+			attachment.Attachment.StorageKey = attachment.StorageKey
+			attachment.Attachment.ThumbnailKey = attachment.ThumbnailKey
+			messages[i].Attachments = append(messages[i].Attachments, attachment.Attachment)
+		}
+	}
+	return messages
+}
+
+func roomMessagesKey(roomId int) string {
+	return fmt.Sprintf("room:%d:messages", roomId)
+}
+
+func roomMessagesVersionKey(roomId int) string {
+	return fmt.Sprintf("room:%d:messages:version", roomId)
+}
+
+// Сообщения кешируются вместе с поколением, прочитанным до загрузки из БД
+// Если за время загрузки кеш был сброшен, запись со старым поколением не будет отдана
+func (c *Client) CacheRoomMessages(roomId int, version int64, messages []model.Message) error {
+	jsonData, err := json.Marshal(cachedRoomMessages{Version: version, Messages: toCachedMessages(messages)})
 	if err != nil {
 		return err
 	}
 
-	return c.client.Set(c.ctx, key, jsonData, 10*time.Minute).Err()
+	return c.Set(roomMessagesKey(roomId), jsonData, 10*time.Minute)
+}
+
+// Текущее поколение кеша сообщений комнаты, меняется при каждом сбросе
+func (c *Client) RoomMessagesVersion(roomId int) (int64, error) {
+	version, err := c.client.Get(c.ctx, roomMessagesVersionKey(roomId)).Int64()
+	trackOperation("get", err)
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return version, err
 }
 
-func (c *Client) GetCachedRoomMessages(roomId int) ([]model.Message, error) {
-	key := fmt.Sprintf("room:%d:messages", roomId)
-	data, err := c.client.Get(c.ctx, key).Bytes()
+// Возвращает закешированные сообщения комнаты и признак попадания в кеш
+func (c *Client) GetCachedRoomMessages(roomId int) ([]model.Message, bool, error) {
+	values, err := c.client.MGet(c.ctx, roomMessagesKey(roomId), roomMessagesVersionKey(roomId)).Result()
 	if err != nil {
-		return nil, err
+		monitoring.IncrementRedisOperations("cache_get", "error")
+		return nil, false, err
 	}
 
-	var messages []model.Message
-	err = json.Unmarshal(data, &messages)
-	return messages, err
+	data, ok := values[0].(string)
+	if !ok {
+		monitoring.IncrementRedisOperations("cache_get", "miss")
+		return nil, false, nil
+	}
+
+	var cached cachedRoomMessages
+	if err := json.Unmarshal([]byte(data), &cached); err != nil {
+		monitoring.IncrementRedisOperations("cache_get", "error")
+		return nil, false, err
+	}
+
+	var version int64
+	if value, ok := values[1].(string); ok {
+		version, _ = strconv.ParseInt(value, 10, 64)
+	}
+	if cached.Version != version {
+		monitoring.IncrementRedisOperations("cache_get", "miss")
+		return nil, false, nil
+	}
+
+	monitoring.IncrementRedisOperations("cache_get", "hit")
+	return fromCachedMessages(cached.Messages), true, nil
+}
+
+// Ключ поколения живет без срока: иначе после его истечения поколение могло бы повториться
+func (c *Client) InvalidateRoomMessages(roomId int) error {
+	pipe := c.client.TxPipeline()
+	pipe.Incr(c.ctx, roomMessagesVersionKey(roomId))
+	pipe.Del(c.ctx, roomMessagesKey(roomId))
+	_, err := pipe.Exec(c.ctx)
+
+	trackOperation("del", err)
+	return err
 }
 
 func (c *Client) Set(key string, value interface{}, expiration time.Duration) error {
@@ -89,4 +190,38 @@ func (c *Client) Del(key string) error {
 	return err
 }
 
+// Атомарно читает и удаляет ключ, используется для одноразовых значений
+func (c *Client) GetDel(key string) (string, error) {
+	result, err := c.client.GetDel(c.ctx, key).Result()
+
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+
+	monitoring.IncrementRedisOperations("getdel", status)
+	return result, err
+}
+
+// Увеличивает счетчик; срок жизни задается при создании ключа, поэтому окно отсчитывается от первого увеличения
+func (c *Client) Incr(key string, expiration time.Duration) (int64, error) {
+	count, err := c.client.Incr(c.ctx, key).Result()
+	if err == nil && count == 1 {
+		err = c.client.Expire(c.ctx, key, expiration).Err()
+	}
+
+	trackOperation("incr", err)
+	return count, err
+}
+
+// Учитывает операцию в метрике redis_operations_total
+func trackOperation(operation string, err error) {
+	status := "success"
+	if err != nil && !errors.Is(err, redis.Nil) {
+		status = "error"
+	}
+
+	monitoring.IncrementRedisOperations(operation, status)
+}
+
 //Get, Del, HSet
@@ -0,0 +1,161 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// Ключи присутствия общие для всех узлов:
+// presence:rooms                     - комнаты, в которых есть присутствие
+// presence:room:<id>:users           - пользователи комнаты
+// presence:room:<id>:conns           - число открытых соединений пользователя
+// presence:room:<id>:user:<user_id>  - статус пользователя с TTL, продлевается heartbeat'ом
+const presenceRoomsKey = "presence:rooms"
+
+func presenceUsersKey(roomId int) string {
+	return fmt.Sprintf("presence:room:%d:users", roomId)
+}
+
+func presenceConnsKey(roomId int) string {
+	return fmt.Sprintf("presence:room:%d:conns", roomId)
+}
+
+func presenceUserKey(roomId, userId int) string {
+	return fmt.Sprintf("presence:room:%d:user:%d", roomId, userId)
+}
+
+// Устанавливает статус пользователя и возвращает предыдущий статус
+// Пустая строка означает, что пользователь не был в сети
+func (c *Client) SetPresence(roomId, userId int, status string, ttl time.Duration) (string, error) {
+	pipe := c.client.TxPipeline()
+	prev := pipe.SetArgs(c.ctx, presenceUserKey(roomId, userId), status, redis.SetArgs{TTL: ttl, Get: true})
+	pipe.SAdd(c.ctx, presenceUsersKey(roomId), userId)
+	pipe.SAdd(c.ctx, presenceRoomsKey, roomId)
+	_, err := pipe.Exec(c.ctx)
+	trackOperation("presence_set", err)
+
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", err
+	}
+
+	previous, err := prev.Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return previous, err
+}
+
+// Продлевает TTL статуса, возвращает false если статус уже истек
+func (c *Client) RefreshPresence(roomId, userId int, ttl time.Duration) (bool, error) {
+	ok, err := c.client.Expire(c.ctx, presenceUserKey(roomId, userId), ttl).Result()
+	trackOperation("presence_refresh", err)
+	return ok, err
+}
+
+// Изменяет число соединений пользователя в комнате и возвращает новое значение
+func (c *Client) AddPresenceConnections(roomId, userId int, delta int64) (int64, error) {
+	field := strconv.Itoa(userId)
+	count, err := c.client.HIncrBy(c.ctx, presenceConnsKey(roomId), field, delta).Result()
+	trackOperation("presence_conns", err)
+	if err != nil {
+		return 0, err
+	}
+
+	if count <= 0 {
+		err = c.client.HDel(c.ctx, presenceConnsKey(roomId), field).Err()
+		trackOperation("presence_conns", err)
+	}
+
+	return count, err
+}
+
+// Удаляет присутствие пользователя
+// Возвращает false, если его уже удалил другой узел
+func (c *Client) RemovePresence(roomId, userId int) (bool, error) {
+	pipe := c.client.TxPipeline()
+	pipe.Del(c.ctx, presenceUserKey(roomId, userId))
+	removed := pipe.SRem(c.ctx, presenceUsersKey(roomId), userId)
+	pipe.HDel(c.ctx, presenceConnsKey(roomId), strconv.Itoa(userId))
+	_, err := pipe.Exec(c.ctx)
+	trackOperation("presence_remove", err)
+	if err != nil {
+		return false, err
+	}
+
+	return removed.Val() > 0, nil
+}
+
+// Возвращает статусы пользователей комнаты
+// Пользователи с истекшим статусом возвращаются отдельным списком
+func (c *Client) GetRoomPresence(roomId int) (map[int]string, []int, error) {
+	members, err := c.client.SMembers(c.ctx, presenceUsersKey(roomId)).Result()
+	trackOperation("presence_get", err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	statuses := make(map[int]string, len(members))
+	if len(members) == 0 {
+		return statuses, nil, nil
+	}
+
+	userIds := make([]int, 0, len(members))
+	keys := make([]string, 0, len(members))
+	for _, member := range members {
+		userId, err := strconv.Atoi(member)
+		if err != nil {
+			continue
+		}
+		userIds = append(userIds, userId)
+		keys = append(keys, presenceUserKey(roomId, userId))
+	}
+
+	values, err := c.client.MGet(c.ctx, keys...).Result()
+	trackOperation("presence_get", err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var expired []int
+	for i, value := range values {
+		status, ok := value.(string)
+		if !ok {
+			expired = append(expired, userIds[i])
+			continue
+		}
+		statuses[userIds[i]] = status
+	}
+
+	return statuses, expired, nil
+}
+
+// Возвращает комнаты, в которых есть присутствие, и убирает из списка пустые
+func (c *Client) GetPresenceRooms() ([]int, error) {
+	members, err := c.client.SMembers(c.ctx, presenceRoomsKey).Result()
+	trackOperation("presence_rooms", err)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make([]int, 0, len(members))
+	for _, member := range members {
+		roomId, err := strconv.Atoi(member)
+		if err != nil {
+			continue
+		}
+
+		count, err := c.client.SCard(c.ctx, presenceUsersKey(roomId)).Result()
+		trackOperation("presence_rooms", err)
+		if err == nil && count == 0 {
+			c.client.SRem(c.ctx, presenceRoomsKey, roomId)
+			continue
+		}
+
+		rooms = append(rooms, roomId)
+	}
+
+	return rooms, nil
+}
@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"fmt"
+	talk_together_app "github.com/firstproject/talk-together-app/hub"
+	"github.com/firstproject/talk-together-app/pkg/middleware/monitoring"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+)
+
+const roomChannelPrefix = "room:"
+const roomChannelSuffix = ":events"
+
+// Реализация hub.Cluster поверх Redis Pub/Sub: один канал на комнату
+type RoomPubSub struct {
+	client   *Client
+	pubsub   *redis.PubSub
+	messages chan talk_together_app.ClusterMessage
+}
+
+func (c *Client) NewRoomPubSub() *RoomPubSub {
+	ps := &RoomPubSub{
+		client:   c,
+		pubsub:   c.client.Subscribe(c.ctx),
+		messages: make(chan talk_together_app.ClusterMessage, 256),
+	}
+
+	go ps.listen()
+
+	return ps
+}
+
+func roomChannel(roomId int) string {
+	return fmt.Sprintf("%s%d%s", roomChannelPrefix, roomId, roomChannelSuffix)
+}
+
+func parseRoomChannel(channel string) (int, error) {
+	id := strings.TrimSuffix(strings.TrimPrefix(channel, roomChannelPrefix), roomChannelSuffix)
+	return strconv.Atoi(id)
+}
+
+func (p *RoomPubSub) Publish(roomId int, payload []byte) error {
+	err := p.client.client.Publish(p.client.ctx, roomChannel(roomId), payload).Err()
+
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+
+	monitoring.IncrementRedisOperations("publish", status)
+	return err
+}
+
+func (p *RoomPubSub) Subscribe(roomId int) error {
+	err := p.pubsub.Subscribe(p.client.ctx, roomChannel(roomId))
+
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+
+	monitoring.IncrementRedisOperations("subscribe", status)
+	return err
+}
+
+func (p *RoomPubSub) Unsubscribe(roomId int) error {
+	err := p.pubsub.Unsubscribe(p.client.ctx, roomChannel(roomId))
+
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+
+	monitoring.IncrementRedisOperations("unsubscribe", status)
+	return err
+}
+
+func (p *RoomPubSub) Messages() <-chan talk_together_app.ClusterMessage {
+	return p.messages
+}
+
+func (p *RoomPubSub) Close() error {
+	return p.pubsub.Close()
+}
+
+func (p *RoomPubSub) listen() {
+	defer close(p.messages)
+
+	for msg := range p.pubsub.Channel() {
+		roomId, err := parseRoomChannel(msg.Channel)
+		if err != nil {
+			continue
+		}
+
+		p.messages <- talk_together_app.ClusterMessage{
+			Room:    roomId,
+			Payload: []byte(msg.Payload),
+		}
+	}
+}
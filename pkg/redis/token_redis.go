@@ -0,0 +1,73 @@
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// Отозванные access токены и сессии хранятся до истечения срока их access токенов
+const (
+	denylistPrefix         = "auth:denylist:"
+	revokedSessionsChannel = "auth:revoked-sessions"
+)
+
+func (c *Client) DenyTokens(ids []string, ttl time.Duration) error {
+	pipe := c.client.Pipeline()
+	for _, id := range ids {
+		pipe.Set(c.ctx, denylistPrefix+id, 1, ttl)
+	}
+	_, err := pipe.Exec(c.ctx)
+
+	trackOperation("denylist_add", err)
+	return err
+}
+
+func (c *Client) AnyTokenDenied(ids []string) (bool, error) {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, denylistPrefix+id)
+	}
+
+	count, err := c.client.Exists(c.ctx, keys...).Result()
+
+	trackOperation("denylist_check", err)
+	return count > 0, err
+}
+
+// Сообщает всем узлам об отзыве сессий, чтобы они закрыли свои WebSocket соединения
+func (c *Client) PublishRevokedSessions(sessionIds []string) error {
+	for _, sessionId := range sessionIds {
+		err := c.client.Publish(c.ctx, revokedSessionsChannel, sessionId).Err()
+		trackOperation("publish", err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Возвращает поток отозванных сессий, канал закрывается при отмене ctx
+func (c *Client) SubscribeRevokedSessions(ctx context.Context) <-chan string {
+	pubsub := c.client.Subscribe(ctx, revokedSessionsChannel)
+	sessions := make(chan string, 64)
+
+	go func() {
+		defer close(sessions)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				sessions <- msg.Payload
+			}
+		}
+	}()
+
+	return sessions
+}
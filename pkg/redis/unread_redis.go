@@ -0,0 +1,119 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/firstproject/talk-together-app/pkg/middleware/monitoring"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// Счетчики непрочитанных хранятся в hash на пользователя: поле - id комнаты
+const unreadTTL = 10 * time.Minute
+
+// Увеличивает счетчик только если он уже закеширован,
+// иначе в кеше появилось бы неполное значение
+var incrementIfCached = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 1 then
+	return redis.call('HINCRBY', KEYS[1], ARGV[1], ARGV[2])
+end
+return nil
+`)
+
+func unreadKey(userId int) string {
+	return fmt.Sprintf("user:%d:unread", userId)
+}
+
+// Возвращает закешированные счетчики, отсутствующие комнаты в результат не попадают
+func (c *Client) GetCachedUnreadCounts(userId int, roomIds []int) (map[int]int, error) {
+	counts := make(map[int]int, len(roomIds))
+	if len(roomIds) == 0 {
+		return counts, nil
+	}
+
+	fields := make([]string, len(roomIds))
+	for i, roomId := range roomIds {
+		fields[i] = strconv.Itoa(roomId)
+	}
+
+	values, err := c.client.HMGet(c.ctx, unreadKey(userId), fields...).Result()
+	if err != nil {
+		monitoring.IncrementRedisOperations("cache_get", "error")
+		return nil, err
+	}
+
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			monitoring.IncrementRedisOperations("cache_get", "miss")
+			continue
+		}
+
+		count, err := strconv.Atoi(str)
+		if err != nil {
+			continue
+		}
+
+		monitoring.IncrementRedisOperations("cache_get", "hit")
+		counts[roomIds[i]] = count
+	}
+
+	return counts, nil
+}
+
+func (c *Client) CacheUnreadCounts(userId int, counts map[int]int) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	values := make(map[string]interface{}, len(counts))
+	for roomId, count := range counts {
+		values[strconv.Itoa(roomId)] = count
+	}
+
+	pipe := c.client.TxPipeline()
+	pipe.HSet(c.ctx, unreadKey(userId), values)
+	pipe.Expire(c.ctx, unreadKey(userId), unreadTTL)
+	_, err := pipe.Exec(c.ctx)
+	trackOperation("set", err)
+
+	return err
+}
+
+// Увеличивает закешированные счетчики комнаты у переданных пользователей
+func (c *Client) IncrementUnread(roomId int, userIds []int) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+
+	field := strconv.Itoa(roomId)
+	pipe := c.client.Pipeline()
+	for _, userId := range userIds {
+		incrementIfCached.Eval(c.ctx, pipe, []string{unreadKey(userId)}, field, 1)
+	}
+	_, err := pipe.Exec(c.ctx)
+	if errors.Is(err, redis.Nil) {
+		err = nil
+	}
+	trackOperation("unread_incr", err)
+
+	return err
+}
+
+// Сбрасывает закешированные счетчики комнаты у переданных пользователей
+func (c *Client) InvalidateUnread(roomId int, userIds []int) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+
+	field := strconv.Itoa(roomId)
+	pipe := c.client.Pipeline()
+	for _, userId := range userIds {
+		pipe.HDel(c.ctx, unreadKey(userId), field)
+	}
+	_, err := pipe.Exec(c.ctx)
+	trackOperation("del", err)
+
+	return err
+}
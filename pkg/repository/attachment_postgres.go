@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"time"
+)
+
+var ErrInvalidAttachments = errors.New("attachments not found, already used or uploaded to another room")
+
+const (
+	attachmentColumns = "id, room_id, user_id, message_id, filename, content_type, size, storage_key, thumbnail_key, created_at"
+	uploadColumns     = "id, room_id, user_id, filename, size, received, parts, created_at"
+)
+
+type AttachmentPostgres struct {
+	db *sqlx.DB
+}
+
+func NewAttachmentPostgres(db *sqlx.DB) *AttachmentPostgres {
+	return &AttachmentPostgres{db: db}
+}
+
+func (r *AttachmentPostgres) CreateAttachment(attachment model.Attachment) (model.Attachment, error) {
+	var created model.Attachment
+
+	query := fmt.Sprintf(`INSERT INTO %s (room_id, user_id, filename, content_type, size, storage_key, thumbnail_key)
+						VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING %s`, attachmentsTable, attachmentColumns)
+	err := r.db.Get(&created, query, attachment.Room, attachment.User, attachment.Filename,
+		attachment.ContentType, attachment.Size, attachment.StorageKey, attachment.ThumbnailKey)
+
+	return created, err
+}
+
+// Вложение удаленного сообщения выглядит как несуществующее
+func (r *AttachmentPostgres) GetAttachmentById(attachmentId int) (model.Attachment, error) {
+	var attachment model.Attachment
+
+	query := fmt.Sprintf(`SELECT %s FROM %s a WHERE id = $1
+						AND NOT EXISTS (SELECT 1 FROM %s m WHERE m.id = a.message_id AND m.deleted_at IS NOT NULL)`,
+		attachmentColumns, attachmentsTable, messagesTable)
+	err := r.db.Get(&attachment, query, attachmentId)
+
+	return attachment, err
+}
+
+// Удаляет вложения удаленных сообщений и не привязанные к сообщению дольше unusedBefore
+// Возвращает удаленные записи, чтобы вызывающий удалил их файлы
+func (r *AttachmentPostgres) DeleteExpiredAttachments(unusedBefore time.Time, limit int) ([]model.Attachment, error) {
+	var attachments []model.Attachment
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id IN (
+							SELECT a.id FROM %s a LEFT JOIN %s m ON m.id = a.message_id
+							WHERE (a.message_id IS NULL AND a.created_at < $1) OR m.deleted_at IS NOT NULL
+							LIMIT $2)
+						RETURNING %s`, attachmentsTable, attachmentsTable, messagesTable, attachmentColumns)
+	err := r.db.Select(&attachments, query, unusedBefore, limit)
+
+	return attachments, err
+}
+
+// Вложения удаленных сообщений не возвращаются
+func (r *AttachmentPostgres) GetMessageAttachments(messageIds []int) (map[int][]model.Attachment, error) {
+	var attachments []model.Attachment
+
+	query := fmt.Sprintf(`SELECT %s FROM %s
+						WHERE message_id IN (SELECT id FROM %s WHERE id = ANY($1) AND deleted_at IS NULL)
+						ORDER BY id`, attachmentColumns, attachmentsTable, messagesTable)
+	if err := r.db.Select(&attachments, query, pq.Array(messageIds)); err != nil {
+		return nil, err
+	}
+
+	byMessage := make(map[int][]model.Attachment)
+	for _, attachment := range attachments {
+		byMessage[*attachment.MessageId] = append(byMessage[*attachment.MessageId], attachment)
+	}
+
+	return byMessage, nil
+}
+
+func (r *AttachmentPostgres) CreateUpload(upload model.AttachmentUpload) (model.AttachmentUpload, error) {
+	var created model.AttachmentUpload
+
+	query := fmt.Sprintf(`INSERT INTO %s (id, room_id, user_id, filename, size) VALUES ($1, $2, $3, $4, $5)
+						RETURNING %s`, uploadsTable, uploadColumns)
+	err := r.db.Get(&created, query, upload.Id, upload.Room, upload.User, upload.Filename, upload.Size)
+
+	return created, err
+}
+
+func (r *AttachmentPostgres) GetUpload(uploadId string) (model.AttachmentUpload, error) {
+	var upload model.AttachmentUpload
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE id = $1", uploadColumns, uploadsTable)
+	err := r.db.Get(&upload, query, uploadId)
+
+	return upload, err
+}
+
+// Засчитывает часть, только если она начинается с текущего смещения загрузки
+// write сохраняет часть под блокировкой строки, поэтому одновременные запросы с тем же смещением
+// не перезаписывают файлы друг друга: второй дождется первого и получит sql.ErrNoRows
+func (r *AttachmentPostgres) AdvanceUpload(uploadId string, offset, length int64, write func(part int) error) (model.AttachmentUpload, error) {
+	var upload model.AttachmentUpload
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return upload, err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE id = $1 FOR UPDATE", uploadColumns, uploadsTable)
+	if err := tx.Get(&upload, query, uploadId); err != nil {
+		return upload, err
+	}
+	if upload.Received != offset {
+		return upload, sql.ErrNoRows
+	}
+
+	if err := write(upload.Parts); err != nil {
+		return upload, err
+	}
+
+	query = fmt.Sprintf(`UPDATE %s SET received = received + $2, parts = parts + 1 WHERE id = $1
+						RETURNING %s`, uploadsTable, uploadColumns)
+	if err := tx.Get(&upload, query, uploadId, length); err != nil {
+		return upload, err
+	}
+
+	return upload, tx.Commit()
+}
+
+// Удаляет загрузки, начатые раньше before и так и не завершенные
+func (r *AttachmentPostgres) DeleteExpiredUploads(before time.Time, limit int) ([]model.AttachmentUpload, error) {
+	var uploads []model.AttachmentUpload
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id IN (SELECT id FROM %s WHERE created_at < $1 LIMIT $2)
+						RETURNING %s`, uploadsTable, uploadsTable, uploadColumns)
+	err := r.db.Select(&uploads, query, before, limit)
+
+	return uploads, err
+}
+
+func (r *AttachmentPostgres) DeleteUpload(uploadId string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = $1", uploadsTable)
+	_, err := r.db.Exec(query, uploadId)
+	return err
+}
+
+// Привязывает загруженные автором вложения к новому сообщению и добавляет их в данные события
+func linkAttachments(tx *sqlx.Tx, message *model.Message, attachmentIds []int) error {
+	if len(attachmentIds) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET message_id = $1
+						WHERE id = ANY($2) AND room_id = $3 AND user_id = $4 AND message_id IS NULL
+						RETURNING %s`, attachmentsTable, attachmentColumns)
+	if err := tx.Select(&message.Attachments, query, message.Id, pq.Array(attachmentIds), message.Room, message.User); err != nil {
+		return err
+	}
+
+	if len(message.Attachments) != len(attachmentIds) {
+		return ErrInvalidAttachments
+	}
+
+	return nil
+}
@@ -26,10 +26,29 @@ func (r *AuthPostgres) CreateUser(user model.User) (int, error) {
 	return id, nil
 }
 
-func (r *AuthPostgres) GetUser(userName, password string) (model.User, error) {
+// Пароль проверяется в сервисе, здесь только загружается хеш
+// У пользователей, созданных через единый вход, пароля нет и хеш пустой
+func (r *AuthPostgres) GetUser(userName string) (model.User, error) {
 	var user model.User
-	query := fmt.Sprintf("SELECT id FROM %s WHERE username=$1 AND password_hash=$2", usersTable)
-	err := r.db.Get(&user, query, userName, password)
+	query := fmt.Sprintf("SELECT id, username, coalesce(password_hash, '') AS password_hash FROM %s WHERE username=$1", usersTable)
+	err := r.db.Get(&user, query, userName)
 
 	return user, err
 }
+
+// Хеш заменяется, только если он не изменился с момента проверки пароля
+func (r *AuthPostgres) UpdatePasswordHash(userId int, oldHash, newHash string) error {
+	query := fmt.Sprintf("UPDATE %s SET password_hash=$1 WHERE id=$2 AND password_hash=$3", usersTable)
+	_, err := r.db.Exec(query, newHash, userId, oldHash)
+
+	return err
+}
+
+// Администраторы назначаются вручную записью в таблицу admins
+func (r *AuthPostgres) IsAdmin(userId int) (bool, error) {
+	var isAdmin bool
+	query := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE user_id = $1)", adminsTable)
+	err := r.db.Get(&isAdmin, query, userId)
+
+	return isAdmin, err
+}
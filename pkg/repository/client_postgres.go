@@ -21,7 +21,7 @@ func (r *ClientPostgres) AddClientToRoom(roomId, userId int) error {
 }
 
 func (r *ClientPostgres) RemoveClientFromRoom(roomId, userId int) error {
-	query := fmt.Sprintf("UPDATE %s SET disconnected_at = NOW() WHEERE room_id = $1 AND user_id = $2 AND disconnected_at IS NULL", clientsTable)
+	query := fmt.Sprintf("UPDATE %s SET disconnected_at = NOW() WHERE room_id = $1 AND user_id = $2 AND disconnected_at IS NULL", clientsTable)
 	_, err := r.db.Exec(query, roomId, userId)
 	return err
 }
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrUsernameTaken  = errors.New("username is already taken")
+	ErrIdentityLinked = errors.New("external account is linked to another user")
+	ErrEmailTaken     = errors.New("email belongs to an existing account, sign in and link the provider to it")
+)
+
+type IdentityPostgres struct {
+	db *sqlx.DB
+}
+
+func NewIdentityPostgres(db *sqlx.DB) *IdentityPostgres {
+	return &IdentityPostgres{db: db}
+}
+
+// Возвращает пользователя, к которому привязана внешняя учетная запись
+func (r *IdentityPostgres) GetIdentityUser(provider, subject string) (int, error) {
+	var userId int
+	query := fmt.Sprintf(`SELECT user_id FROM %s WHERE provider = $1 AND subject = $2`, identitiesTable)
+	err := r.db.Get(&userId, query, provider, subject)
+
+	return userId, err
+}
+
+// Привязывает учетную запись к пользователю, который вошел сам; ErrIdentityLinked если она уже принадлежит другому
+func (r *IdentityPostgres) LinkIdentity(userId int, identity model.UserIdentity) error {
+	var ownerId int
+	query := fmt.Sprintf(`INSERT INTO %s (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)
+						ON CONFLICT (provider, subject) DO UPDATE SET email = EXCLUDED.email
+						WHERE %s.user_id = EXCLUDED.user_id
+						RETURNING user_id`, identitiesTable, identitiesTable)
+	err := r.db.Get(&ownerId, query, userId, identity.Provider, identity.Subject, identity.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrIdentityLinked
+	}
+
+	return err
+}
+
+// Создает пользователя без пароля вместе с привязкой внешней учетной записи
+// ErrEmailTaken, если email уже занят: аккаунт с ним привязывается только его владельцем через LinkIdentity
+func (r *IdentityPostgres) CreateUserWithIdentity(user model.User, identity model.UserIdentity) (int, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userId int
+	userQuery := fmt.Sprintf(`INSERT INTO %s (first_name, last_name, username, email) VALUES ($1, $2, $3, $4) RETURNING id`, usersTable)
+	err = tx.Get(&userId, userQuery, user.FirstName, user.LastName, user.Username, user.Email)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		switch pqErr.Constraint {
+		case "users_username_key":
+			return 0, ErrUsernameTaken
+		case "users_email_idx":
+			return 0, ErrEmailTaken
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	identityQuery := fmt.Sprintf(`INSERT INTO %s (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)`, identitiesTable)
+	if _, err := tx.Exec(identityQuery, userId, identity.Provider, identity.Subject, identity.Email); err != nil {
+		return 0, err
+	}
+
+	return userId, tx.Commit()
+}
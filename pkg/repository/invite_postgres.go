@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+var ErrInviteInactive = errors.New("invite is revoked, expired or used up")
+
+type InvitePostgres struct {
+	db *sqlx.DB
+}
+
+func NewInvitePostgres(db *sqlx.DB) *InvitePostgres {
+	return &InvitePostgres{db: db}
+}
+
+func (r *InvitePostgres) CreateInvite(invite model.RoomInvite) (model.RoomInvite, error) {
+	var created model.RoomInvite
+
+	query := fmt.Sprintf(`INSERT INTO %s (room_id, code, created_by, max_uses, expires_at)
+						VALUES ($1, $2, $3, $4, $5) RETURNING *`, invitesTable)
+	err := r.db.Get(&created, query, invite.Room, invite.Code, invite.CreatedBy, invite.MaxUses, invite.ExpiresAt)
+
+	return created, err
+}
+
+func (r *InvitePostgres) GetInviteByCode(code string) (model.RoomInvite, error) {
+	var invite model.RoomInvite
+
+	query := fmt.Sprintf("SELECT * FROM %s WHERE code = $1", invitesTable)
+	err := r.db.Get(&invite, query, code)
+
+	return invite, err
+}
+
+func (r *InvitePostgres) GetRoomInvites(roomId int) ([]model.RoomInvite, error) {
+	var invites []model.RoomInvite
+
+	query := fmt.Sprintf("SELECT * FROM %s WHERE room_id = $1 ORDER BY created_at DESC", invitesTable)
+	err := r.db.Select(&invites, query, roomId)
+
+	return invites, err
+}
+
+func (r *InvitePostgres) RevokeInvite(roomId int, code string) error {
+	query := fmt.Sprintf("UPDATE %s SET revoked_at = NOW() WHERE room_id = $1 AND code = $2 AND revoked_at IS NULL", invitesTable)
+
+	result, err := r.db.Exec(query, roomId, code)
+	if err != nil {
+		return err
+	}
+
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Добавляет пользователя в комнату по приглашению и записывает принятие для аудита
+// Приглашение блокируется до конца транзакции, чтобы не превысить max_uses
+// Возвращает false, если пользователь уже состоял в комнате - использование не засчитывается
+// Бан проверяется в той же транзакции, заблокированному пользователю возвращается ErrMemberBanned
+func (r *InvitePostgres) AcceptInvite(code string, userId int) (model.RoomInvite, bool, error) {
+	var invite model.RoomInvite
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return invite, false, err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("SELECT * FROM %s WHERE code = $1 FOR UPDATE", invitesTable)
+	if err := tx.Get(&invite, query, code); err != nil {
+		return invite, false, err
+	}
+
+	if !invite.Active(time.Now()) {
+		return invite, false, ErrInviteInactive
+	}
+
+	if err := lockMembership(tx, invite.Room, userId); err != nil {
+		return invite, false, err
+	}
+
+	if err := checkNotBanned(tx, invite.Room, userId); err != nil {
+		return invite, false, err
+	}
+
+	added, err := addMember(tx, invite.Room, userId)
+	if err != nil || !added {
+		return invite, false, err
+	}
+
+	usesQuery := fmt.Sprintf("UPDATE %s SET uses = uses + 1 WHERE id = $1 RETURNING uses", invitesTable)
+	if err := tx.Get(&invite.Uses, usesQuery, invite.Id); err != nil {
+		return invite, false, err
+	}
+
+	auditQuery := fmt.Sprintf(`INSERT INTO %s (invite_id, user_id) VALUES ($1, $2)
+						ON CONFLICT (invite_id, user_id) DO UPDATE SET accepted_at = NOW()`, acceptancesTable)
+	if _, err := tx.Exec(auditQuery, invite.Id, userId); err != nil {
+		return invite, false, err
+	}
+
+	return invite, true, tx.Commit()
+}
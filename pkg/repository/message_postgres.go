@@ -1,11 +1,22 @@
 package repository
 
 import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/firstproject/talk-together-app/model"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"strings"
 )
 
+var ErrInvalidParent = errors.New("parent message not found in room or is a reply itself")
+
+// Колонки сообщения для SELECT и RETURNING
+const messageColumns = "id, room_id, user_id, content, parent_id, reply_count, last_reply_at, edited_at, deleted_at, created_at"
+
 type MessagePostgres struct {
 	db *sqlx.DB
 }
@@ -14,39 +25,195 @@ func NewMessagePostgres(db *sqlx.DB) *MessagePostgres {
 	return &MessagePostgres{db: db}
 }
 
-func (r *MessagePostgres) CreateMessage(roomId, userId int, content string) (int, error) {
-	var id int
-	query := fmt.Sprintf("INSERT INTO %s (room_id, user_id, content) VALUES ($1, $2, $3) RETURNING id", messagesTable)
-	err := r.db.Get(&id, query, roomId, userId, content)
+// Сохраняет сообщение и событие message.created в outbox в одной транзакции
+func (r *MessagePostgres) CreateMessage(roomId, userId int, content string, attachmentIds []int) (int, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var message model.Message
+	query := fmt.Sprintf(`INSERT INTO %s (room_id, user_id, content) VALUES ($1, $2, $3)
+						RETURNING %s`, messagesTable, messageColumns)
+	if err := tx.Get(&message, query, roomId, userId, content); err != nil {
+		return 0, err
+	}
+
+	if err := linkAttachments(tx, &message, attachmentIds); err != nil {
+		return 0, err
+	}
+
+	if err := insertOutboxEvent(tx, model.EventMessageCreated, roomId, message); err != nil {
+		return 0, err
+	}
+
+	return message.Id, tx.Commit()
+}
+
+// Сохраняет ответ в ветке, обновляет счетчик ответов родителя и пишет thread.reply в outbox
+// Родитель блокируется до конца транзакции, чтобы счетчик не терял ответы
+func (r *MessagePostgres) CreateReply(roomId, parentId, userId int, content string, attachmentIds []int) (int, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var parent model.Message
+	parentQuery := fmt.Sprintf("SELECT %s FROM %s WHERE id = $1 FOR UPDATE", messageColumns, messagesTable)
+	if err := tx.Get(&parent, parentQuery, parentId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidParent
+		}
+		return 0, err
+	}
+
+	if parent.Room != roomId || parent.ParentId != nil || parent.Deleted() {
+		return 0, ErrInvalidParent
+	}
+
+	var reply model.Message
+	query := fmt.Sprintf(`INSERT INTO %s (room_id, user_id, content, parent_id) VALUES ($1, $2, $3, $4)
+						RETURNING %s`, messagesTable, messageColumns)
+	if err := tx.Get(&reply, query, roomId, userId, content, parentId); err != nil {
+		return 0, err
+	}
+
+	if err := linkAttachments(tx, &reply, attachmentIds); err != nil {
+		return 0, err
+	}
 
-	return id, err
+	payload := model.ThreadReplyPayload{Parent: parentId, Reply: reply}
+	updateQuery := fmt.Sprintf(`UPDATE %s SET reply_count = reply_count + 1, last_reply_at = $1
+						WHERE id = $2 RETURNING reply_count, last_reply_at`, messagesTable)
+	if err := tx.QueryRowx(updateQuery, reply.CreatedAt, parentId).Scan(&payload.ReplyCount, &payload.LastReplyAt); err != nil {
+		return 0, err
+	}
+
+	if err := insertOutboxEvent(tx, model.EventThreadReply, roomId, payload); err != nil {
+		return 0, err
+	}
+
+	return reply.Id, tx.Commit()
 }
 
 func (r *MessagePostgres) GetRoomMessages(roomId int) ([]model.Message, error) {
 	var messages []model.Message
 	query := fmt.Sprintf(`
-						SELECT m.id, m.room_id, m.user_id, m.content, m.created_at
-						FROM %s m WHERE m.room_id = $1
-						ORDER BY m.created_at`, messagesTable)
+						SELECT %s
+						FROM %s WHERE room_id = $1 AND parent_id IS NULL
+						ORDER BY created_at`, messageColumns, messagesTable)
 
 	err := r.db.Select(&messages, query, roomId)
 	return messages, err
 }
 
-func (r *MessagePostgres) DeleteMessage(messageId, userId int) error {
-	query := fmt.Sprintf("DELETE FROM %s WHERE id = $1 AND user_id = $2", messagesTable)
+// Возвращает до limit сообщений основной ленты комнаты по ключу (room_id, id) в порядке возрастания id.
+// before - сообщения старше курсора, after - новее курсора, без курсоров - последние сообщения
+// Ответы в ветках в ленту не попадают
+func (r *MessagePostgres) GetRoomMessagesPage(roomId int, page model.MessagePageQuery) ([]model.Message, error) {
+	return r.selectPage("room_id = $1 AND parent_id IS NULL", roomId, page)
+}
 
-	result, err := r.db.Exec(query, messageId, userId)
+// Возвращает страницу ответов ветки в порядке возрастания id, курсоры как в GetRoomMessagesPage
+func (r *MessagePostgres) GetThreadMessagesPage(parentId int, page model.MessagePageQuery) ([]model.Message, error) {
+	return r.selectPage("parent_id = $1", parentId, page)
+}
+
+func (r *MessagePostgres) selectPage(filter string, filterValue int, page model.MessagePageQuery) ([]model.Message, error) {
+	var messages []model.Message
+
+	var err error
+	switch {
+	case page.After > 0:
+		query := fmt.Sprintf(`SELECT %s FROM %s
+							WHERE %s AND id > $2
+							ORDER BY id ASC LIMIT $3`, messageColumns, messagesTable, filter)
+		err = r.db.Select(&messages, query, filterValue, page.After, page.Limit)
+		return messages, err
+	case page.Before > 0:
+		query := fmt.Sprintf(`SELECT %s FROM %s
+							WHERE %s AND id < $2
+							ORDER BY id DESC LIMIT $3`, messageColumns, messagesTable, filter)
+		err = r.db.Select(&messages, query, filterValue, page.Before, page.Limit)
+	default:
+		query := fmt.Sprintf(`SELECT %s FROM %s
+							WHERE %s
+							ORDER BY id DESC LIMIT $2`, messageColumns, messagesTable, filter)
+		err = r.db.Select(&messages, query, filterValue, page.Limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
+
+// Помечает сообщение удаленным и записывает событие message.deleted в outbox
+// Текст переносится в историю правок, в ленте остается надгробие, реакции и закрепление снимаются
+// Снятие закрепления записывает message.unpinned в той же транзакции
+// Права на удаление чужих сообщений проверяются в сервисе по роли участника
+func (r *MessagePostgres) DeleteMessage(messageId, deletedBy int) error {
+	tx, err := r.db.Beginx()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
-	if rowsAffected == 0 {
-		return fmt.Errorf("message not found")
+	message, err := lockLiveMessage(tx, messageId)
+	if err != nil {
+		return err
+	}
+
+	if err := insertRevision(tx, message, deletedBy); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET content = '', deleted_at = NOW(), deleted_by = $1 WHERE id = $2`, messagesTable)
+	if _, err := tx.Exec(query, deletedBy, messageId); err != nil {
+		return err
+	}
+
+	reactionsQuery := fmt.Sprintf("DELETE FROM %s WHERE message_id = $1", reactionsTable)
+	if _, err := tx.Exec(reactionsQuery, messageId); err != nil {
+		return err
+	}
+
+	// Клиенты убирают сообщение из закрепленных по событию message.unpinned
+	var unpinned []model.PinnedMessage
+	unpinQuery := fmt.Sprintf(`DELETE FROM %s WHERE message_id = $1
+						RETURNING room_id, message_id, COALESCE(pinned_by, 0) AS pinned_by, pinned_at`, pinnedTable)
+	if err := tx.Select(&unpinned, unpinQuery, messageId); err != nil {
+		return err
+	}
+	for _, pinned := range unpinned {
+		if err := insertOutboxEvent(tx, model.EventMessageUnpinned, pinned.Room, pinned); err != nil {
+			return err
+		}
+	}
+
+	deleted := model.MessageDeletedPayload{Id: message.Id, Room: message.Room, Parent: message.ParentId}
+
+	// Счетчик и последний ответ ветки учитывают только неудаленные ответы
+	if deleted.Parent != nil {
+		parentQuery := fmt.Sprintf(`UPDATE %s SET reply_count = reply_count - 1,
+							last_reply_at = (SELECT MAX(created_at) FROM %s WHERE parent_id = $1 AND deleted_at IS NULL)
+							WHERE id = $1`, messagesTable, messagesTable)
+		if _, err := tx.Exec(parentQuery, *deleted.Parent); err != nil {
+			return err
+		}
+	}
+
+	if err := insertOutboxEvent(tx, model.EventMessageDeleted, deleted.Room, deleted); err != nil {
+		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (r *MessagePostgres) GetMessageOwener(messageId int) (int, error) {
@@ -58,29 +225,283 @@ func (r *MessagePostgres) GetMessageOwener(messageId int) (int, error) {
 	return userId, err
 }
 
+// Обновляет сообщение, сохраняет прежний текст в истории правок
+// и записывает событие message.updated в outbox
 func (r *MessagePostgres) UpdateMessage(messageId, userId int, content string) error {
-	query := fmt.Sprintf("UPDATE %s SET content = $1 WHERE id = $2 AND user_id = $3", messagesTable)
-	result, err := r.db.Exec(query, content, messageId, userId)
+	tx, err := r.db.Beginx()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
-	if rowsAffected == 0 {
+	previous, err := lockLiveMessage(tx, messageId)
+	if err != nil {
+		return err
+	}
+
+	if previous.User != userId {
 		return fmt.Errorf("message not found")
 	}
 
-	return nil
+	if err := insertRevision(tx, previous, userId); err != nil {
+		return err
+	}
+
+	var message model.Message
+	query := fmt.Sprintf(`UPDATE %s SET content = $1, edited_at = NOW() WHERE id = $2
+						RETURNING %s`, messagesTable, messageColumns)
+	if err := tx.Get(&message, query, content, messageId); err != nil {
+		return err
+	}
+
+	if err := insertOutboxEvent(tx, model.EventMessageUpdated, message.Room, message); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Блокирует неудаленное сообщение до конца транзакции
+func lockLiveMessage(tx *sqlx.Tx, messageId int) (model.Message, error) {
+	var message model.Message
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", messageColumns, messagesTable)
+	if err := tx.Get(&message, query, messageId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return message, fmt.Errorf("message not found")
+		}
+		return message, err
+	}
+
+	return message, nil
+}
+
+func insertRevision(tx *sqlx.Tx, message model.Message, editedBy int) error {
+	query := fmt.Sprintf("INSERT INTO %s (message_id, content, edited_by) VALUES ($1, $2, $3)", revisionsTable)
+	_, err := tx.Exec(query, message.Id, message.Content, editedBy)
+	return err
+}
+
+// Возвращает прежние версии сообщения от старых к новым
+func (r *MessagePostgres) GetMessageRevisions(messageId int) ([]model.MessageRevision, error) {
+	var revisions []model.MessageRevision
+
+	query := fmt.Sprintf(`SELECT id, message_id, content, COALESCE(edited_by, 0) AS edited_by, created_at
+						FROM %s WHERE message_id = $1 ORDER BY id`, revisionsTable)
+	err := r.db.Select(&revisions, query, messageId)
+
+	return revisions, err
 }
 
 func (r *MessagePostgres) GetMessageById(messageId int) (model.Message, error) {
 	var message model.Message
 
 	query := fmt.Sprintf(`
-			SELECT m.id, m.room_id, m.user_id, m.content, m.created_at
-			FROM %s m WHERE m.id = $1`, messagesTable)
+			SELECT %s
+			FROM %s WHERE id = $1`, messageColumns, messagesTable)
 
 	err := r.db.Get(&message, query, messageId)
 
 	return message, err
 }
+
+// Закрепляет сообщение и записывает message.pinned в outbox
+// Возвращает false, если сообщение уже закреплено
+func (r *MessagePostgres) PinMessage(roomId, messageId, userId int) (bool, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var pinned model.PinnedMessage
+	query := fmt.Sprintf(`INSERT INTO %s (room_id, message_id, pinned_by) VALUES ($1, $2, $3)
+						ON CONFLICT (room_id, message_id) DO NOTHING
+						RETURNING room_id, message_id, pinned_by, pinned_at`, pinnedTable)
+	if err := tx.Get(&pinned, query, roomId, messageId, userId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := insertOutboxEvent(tx, model.EventMessagePinned, roomId, pinned); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// Открепляет сообщение и записывает message.unpinned в outbox
+func (r *MessagePostgres) UnpinMessage(roomId, messageId int) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var pinned model.PinnedMessage
+	query := fmt.Sprintf(`DELETE FROM %s WHERE room_id = $1 AND message_id = $2
+						RETURNING room_id, message_id, COALESCE(pinned_by, 0) AS pinned_by, pinned_at`, pinnedTable)
+	if err := tx.Get(&pinned, query, roomId, messageId); err != nil {
+		return err
+	}
+
+	if err := insertOutboxEvent(tx, model.EventMessageUnpinned, roomId, pinned); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *MessagePostgres) GetPinnedMessages(roomId int) ([]model.PinnedMessage, error) {
+	var pinned []model.PinnedMessage
+
+	query := fmt.Sprintf(`SELECT room_id, message_id, COALESCE(pinned_by, 0) AS pinned_by, pinned_at
+						FROM %s WHERE room_id = $1 ORDER BY pinned_at DESC`, pinnedTable)
+	err := r.db.Select(&pinned, query, roomId)
+
+	return pinned, err
+}
+
+// Добавляет реакцию и записывает reaction.added с новым счетчиком в outbox
+// Возвращает false, если пользователь уже поставил эту реакцию
+func (r *MessagePostgres) AddReaction(messageId, userId int, emoji string) (bool, error) {
+	return r.changeReaction(messageId, userId, emoji, true)
+}
+
+// Удаляет реакцию и записывает reaction.removed с новым счетчиком в outbox
+// Возвращает false, если такой реакции не было
+func (r *MessagePostgres) RemoveReaction(messageId, userId int, emoji string) (bool, error) {
+	return r.changeReaction(messageId, userId, emoji, false)
+}
+
+func (r *MessagePostgres) changeReaction(messageId, userId int, emoji string, add bool) (bool, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var roomId int
+	roomQuery := fmt.Sprintf("SELECT room_id FROM %s WHERE id = $1 AND deleted_at IS NULL", messagesTable)
+	if err := tx.Get(&roomId, roomQuery, messageId); err != nil {
+		return false, err
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (message_id, user_id, emoji) VALUES ($1, $2, $3)
+						ON CONFLICT (message_id, user_id, emoji) DO NOTHING`, reactionsTable)
+	eventType := model.EventReactionAdded
+	if !add {
+		query = fmt.Sprintf("DELETE FROM %s WHERE message_id = $1 AND user_id = $2 AND emoji = $3", reactionsTable)
+		eventType = model.EventReactionRemoved
+	}
+
+	result, err := tx.Exec(query, messageId, userId, emoji)
+	if err != nil {
+		return false, err
+	}
+
+	rowAffected, err := result.RowsAffected()
+	if err != nil || rowAffected == 0 {
+		return false, err
+	}
+
+	payload := model.ReactionPayload{MessageId: messageId, Room: roomId, User: userId, Emoji: emoji}
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE message_id = $1 AND emoji = $2", reactionsTable)
+	if err := tx.Get(&payload.Count, countQuery, messageId, emoji); err != nil {
+		return false, err
+	}
+
+	if err := insertOutboxEvent(tx, eventType, roomId, payload); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// Возвращает счетчики реакций по id сообщений, эмодзи в порядке первой реакции
+func (r *MessagePostgres) GetReactionCounts(messageIds []int) (map[int][]model.ReactionCount, error) {
+	var rows []struct {
+		MessageId int `db:"message_id"`
+		model.ReactionCount
+	}
+
+	query := fmt.Sprintf(`SELECT message_id, emoji, COUNT(*) AS count FROM %s
+						WHERE message_id = ANY($1)
+						GROUP BY message_id, emoji
+						ORDER BY message_id, MIN(created_at)`, reactionsTable)
+	if err := r.db.Select(&rows, query, pq.Array(messageIds)); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int][]model.ReactionCount)
+	for _, row := range rows {
+		counts[row.MessageId] = append(counts[row.MessageId], row.ReactionCount)
+	}
+
+	return counts, nil
+}
+
+// Границы подсветки в headline. Случайные значения не встречаются в тексте сообщений,
+// поэтому после экранирования текста их можно безопасно заменить тегами
+var (
+	HeadlineStart = newHeadlineSentinel("hlstart")
+	HeadlineStop  = newHeadlineSentinel("hlstop")
+)
+
+// Параметры фрагмента с подсветкой для ts_headline
+var searchHeadlineOptions = fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=30, MinWords=10, MaxFragments=2", HeadlineStart, HeadlineStop)
+
+func newHeadlineSentinel(prefix string) string {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return prefix + hex.EncodeToString(buf)
+}
+
+// Ищет по search_vector только в комнатах, где userId состоит участником, удаленные сообщения не ищутся
+// Запрос разбирается websearch_to_tsquery: поддерживаются "фразы", OR и -исключения
+func (r *MessagePostgres) SearchMessages(userId int, search model.MessageSearchQuery) ([]model.MessageSearchResult, error) {
+	var results []model.MessageSearchResult
+
+	args := []interface{}{search.Query, userId}
+	filters := []string{
+		"search_vector @@ search_query",
+		"deleted_at IS NULL",
+		fmt.Sprintf("room_id IN (SELECT room_id FROM %s WHERE user_id = $2)", roomMembersTable),
+	}
+
+	addFilter := func(condition string, value interface{}) {
+		args = append(args, value)
+		filters = append(filters, fmt.Sprintf(condition, len(args)))
+	}
+
+	if search.Room > 0 {
+		addFilter("room_id = $%d", search.Room)
+	}
+	if search.Author > 0 {
+		addFilter("user_id = $%d", search.Author)
+	}
+	if search.From != nil {
+		addFilter("created_at >= $%d", *search.From)
+	}
+	if search.To != nil {
+		addFilter("created_at < $%d", *search.To)
+	}
+
+	args = append(args, search.Limit, search.Offset)
+	query := fmt.Sprintf(`SELECT %s, ts_headline('simple', content, search_query, '%s') AS headline,
+							ts_rank(search_vector, search_query) AS rank
+						FROM %s, websearch_to_tsquery('simple', $1) search_query
+						WHERE %s
+						ORDER BY rank DESC, id DESC LIMIT $%d OFFSET $%d`,
+		messageColumns, searchHeadlineOptions, messagesTable, strings.Join(filters, " AND "), len(args)-1, len(args))
+
+	if err := r.db.Select(&results, query, args...); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
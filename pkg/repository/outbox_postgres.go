@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/goccy/go-json"
+	"github.com/jmoiron/sqlx"
+)
+
+// Ключ advisory lock, которым узлы договариваются, кто публикует outbox
+const outboxRelayLock = 0x6f7574626f78
+
+type OutboxPostgres struct {
+	db *sqlx.DB
+}
+
+func NewOutboxPostgres(db *sqlx.DB) *OutboxPostgres {
+	return &OutboxPostgres{db: db}
+}
+
+// Записывает событие в outbox в рамках транзакции вызывающего.
+// Payload хранится уже в виде конверта WebSocket протокола, id конверта совпадает с id строки,
+// чтобы получатели могли отбросить повторную доставку любого события
+func insertOutboxEvent(tx *sqlx.Tx, eventType string, aggregateId int, data interface{}) error {
+	event, err := model.NewEvent(eventType, aggregateId, data)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (id, aggregate_id, event_type, payload)
+						SELECT seq.id, $1, $2, jsonb_set($3::jsonb, '{id}', to_jsonb(seq.id))
+						FROM nextval(pg_get_serial_sequence('%s', 'id')) AS seq(id)`, outboxTable, outboxTable)
+	_, err = tx.Exec(query, aggregateId, eventType, payload)
+	return err
+}
+
+// Публикует пачку неотправленных событий по порядку через publish.
+// Публикует только узел, получивший advisory lock: иначе пачки разных узлов уходили бы в Kafka
+// одновременно и события комнаты могли бы поменяться местами. Остальные узлы получают 0 событий.
+// Блокировка держится транзакцией без блокировок строк и снимается при ее завершении или обрыве соединения.
+// На первой ошибке обработка пачки прекращается, чтобы не нарушить порядок событий
+func (r *OutboxPostgres) ProcessPending(limit int, publish func(event model.OutboxEvent) error) (int, error) {
+	lock, err := r.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Rollback()
+
+	var leader bool
+	if err := lock.Get(&leader, "SELECT pg_try_advisory_xact_lock($1)", outboxRelayLock); err != nil {
+		return 0, err
+	}
+	if !leader {
+		return 0, nil
+	}
+
+	var events []model.OutboxEvent
+	selectQuery := fmt.Sprintf(`SELECT id, aggregate_id, event_type, payload, attempts, created_at
+								FROM %s WHERE sent_at IS NULL ORDER BY id LIMIT $1`, outboxTable)
+	if err := r.db.Select(&events, selectQuery, limit); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, event := range events {
+		if publishErr := publish(event); publishErr != nil {
+			failQuery := fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, last_error = $1 WHERE id = $2", outboxTable)
+			if _, err := r.db.Exec(failQuery, publishErr.Error(), event.Id); err != nil {
+				return sent, err
+			}
+			return sent, publishErr
+		}
+
+		sentQuery := fmt.Sprintf("UPDATE %s SET sent_at = NOW() WHERE id = $1", outboxTable)
+		if _, err := r.db.Exec(sentQuery, event.Id); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+func (r *OutboxPostgres) GetStats() (model.OutboxStats, error) {
+	var stats model.OutboxStats
+
+	query := fmt.Sprintf(`SELECT COUNT(*) AS pending,
+						COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(created_at)), 0) AS lag_seconds
+						FROM %s WHERE sent_at IS NULL`, outboxTable)
+	err := r.db.Get(&stats, query)
+
+	return stats, err
+}
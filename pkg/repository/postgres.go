@@ -6,10 +6,26 @@ import (
 )
 
 const (
-	usersTable    = "users"
-	roomsTable    = "rooms"
-	clientsTable  = "clients"
-	messagesTable = "messages"
+	usersTable         = "users"
+	roomsTable         = "rooms"
+	clientsTable       = "clients"
+	messagesTable      = "messages"
+	outboxTable        = "outbox"
+	readCursorsTable   = "read_cursors"
+	roomMembersTable   = "room_members"
+	roomBansTable      = "room_bans"
+	pinnedTable        = "pinned_messages"
+	invitesTable       = "room_invites"
+	acceptancesTable   = "room_invite_acceptances"
+	reactionsTable     = "message_reactions"
+	revisionsTable     = "message_revisions"
+	attachmentsTable   = "attachments"
+	uploadsTable       = "attachment_uploads"
+	refreshTokensTable = "refresh_tokens"
+	identitiesTable    = "user_identities"
+	twoFactorTable     = "user_two_factor"
+	recoveryCodesTable = "recovery_codes"
+	adminsTable        = "admins"
 )
 
 type Config struct {
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var ErrMessageNotInRoom = errors.New("message not found in room")
+
+type ReceiptPostgres struct {
+	db *sqlx.DB
+}
+
+func NewReceiptPostgres(db *sqlx.DB) *ReceiptPostgres {
+	return &ReceiptPostgres{db: db}
+}
+
+// Сдвигает отметку о прочтении вперед и записывает receipt.updated в outbox
+// Возвращает false и текущую отметку, если она уже стоит на этом или более новом сообщении
+func (r *ReceiptPostgres) AdvanceReadCursor(userId, roomId, messageId int) (model.ReadReceipt, bool, error) {
+	var receipt model.ReadReceipt
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return receipt, false, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	existsQuery := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE id = $1 AND room_id = $2)", messagesTable)
+	if err := tx.Get(&exists, existsQuery, messageId, roomId); err != nil {
+		return receipt, false, err
+	}
+	if !exists {
+		return receipt, false, ErrMessageNotInRoom
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (user_id, room_id, last_read_message_id) VALUES ($1, $2, $3)
+						ON CONFLICT (user_id, room_id) DO UPDATE
+						SET last_read_message_id = EXCLUDED.last_read_message_id, updated_at = NOW()
+						WHERE %s.last_read_message_id < EXCLUDED.last_read_message_id
+						RETURNING user_id, room_id, last_read_message_id, updated_at`, readCursorsTable, readCursorsTable)
+	rows, err := tx.Queryx(query, userId, roomId, messageId)
+	if err != nil {
+		return receipt, false, err
+	}
+
+	advanced := rows.Next()
+	if advanced {
+		err = rows.StructScan(&receipt)
+	}
+	rows.Close()
+	if err != nil {
+		return receipt, false, err
+	}
+
+	// Отметка уже дальше: возвращается текущая
+	if !advanced {
+		currentQuery := fmt.Sprintf(`SELECT user_id, room_id, last_read_message_id, updated_at
+								FROM %s WHERE user_id = $1 AND room_id = $2`, readCursorsTable)
+		err := tx.Get(&receipt, currentQuery, userId, roomId)
+		return receipt, false, err
+	}
+
+	if err := insertOutboxEvent(tx, model.EventReceiptUpdated, roomId, receipt); err != nil {
+		return receipt, false, err
+	}
+
+	return receipt, true, tx.Commit()
+}
+
+func (r *ReceiptPostgres) GetRoomReceipts(roomId int) ([]model.ReadReceipt, error) {
+	var receipts []model.ReadReceipt
+
+	query := fmt.Sprintf(`SELECT user_id, room_id, last_read_message_id, updated_at
+						FROM %s WHERE room_id = $1 ORDER BY last_read_message_id DESC`, readCursorsTable)
+	err := r.db.Select(&receipts, query, roomId)
+
+	return receipts, err
+}
+
+// Считает непрочитанные сообщения пользователя во всех переданных комнатах одним запросом
+// Собственные сообщения пользователя и ответы в тредах непрочитанными не считаются:
+// курсор основной ленты не может отметить ответы прочитанными
+func (r *ReceiptPostgres) GetUnreadCounts(userId int, roomIds []int) ([]model.UnreadCount, error) {
+	var counts []model.UnreadCount
+
+	query := fmt.Sprintf(`SELECT r.room_id,
+						rc.last_read_message_id IS NOT NULL AS tracked,
+						(SELECT COUNT(*) FROM %s m
+							WHERE m.room_id = r.room_id
+							AND m.id > COALESCE(rc.last_read_message_id, 0)
+							AND m.user_id <> $1
+							AND m.parent_id IS NULL
+							AND m.deleted_at IS NULL) AS unread
+						FROM unnest($2::int[]) AS r(room_id)
+						LEFT JOIN %s rc ON rc.room_id = r.room_id AND rc.user_id = $1`, messagesTable, readCursorsTable)
+	err := r.db.Select(&counts, query, userId, pq.Array(roomIds))
+
+	return counts, err
+}
+
+// Возвращает пользователей, у которых есть отметка о прочтении в комнате
+func (r *ReceiptPostgres) GetRoomReaders(roomId int) ([]int, error) {
+	var userIds []int
+
+	query := fmt.Sprintf("SELECT user_id FROM %s WHERE room_id = $1", readCursorsTable)
+	err := r.db.Select(&userIds, query, roomId)
+
+	return userIds, err
+}
@@ -3,20 +3,36 @@ package repository
 import (
 	"github.com/firstproject/talk-together-app/model"
 	"github.com/jmoiron/sqlx"
+	"time"
 )
 
 type Authorization interface {
 	CreateUser(user model.User) (int, error)
-	GetUser(userName, password string) (model.User, error)
+	GetUser(userName string) (model.User, error)
+	UpdatePasswordHash(userId int, oldHash, newHash string) error
+	IsAdmin(userId int) (bool, error)
 }
 
 type Room interface {
 	CreateRoom(userId int, room model.Room) (int, error)
+	CreateConversation(userId int, kind, participantKey string, participants []int) (model.Room, error)
+	UsersExist(userIds []int) (bool, error)
 	GetAllRooms(userId int) ([]model.Room, error)
 	SearchRoomByName(name string) ([]model.Room, error)
 	GetRoomById(roomId int) (model.Room, error)
-	UpdateRoom(roomId, userId int, input model.UpdateRoomInput) error
-	DeleteRoom(userId, roomId int) error
+	UpdateRoom(roomId int, input model.UpdateRoomInput) error
+	DeleteRoom(roomId int) error
+	AddMember(roomId, userId int) (bool, error)
+	RemoveMember(roomId, userId int) error
+	IsMember(roomId, userId int) (bool, error)
+	GetRoomMembers(roomId int) ([]model.RoomMember, error)
+	CountMembers(roomId int) (int, error)
+	GetMember(roomId, userId int) (model.RoomMember, error)
+	SetMemberRole(roomId, userId int, role string) (model.RoomMember, error)
+	BanMember(roomId, userId, bannedBy int) error
+	UnbanMember(roomId, userId int) error
+	IsBanned(roomId, userId int) (bool, error)
+	GetRoomBans(roomId int) ([]model.RoomBan, error)
 }
 
 type Client interface {
@@ -26,12 +42,78 @@ type Client interface {
 }
 
 type Message interface {
-	CreateMessage(roomId, userId int, content string) (int, error)
+	CreateMessage(roomId, userId int, content string, attachmentIds []int) (int, error)
+	CreateReply(roomId, parentId, userId int, content string, attachmentIds []int) (int, error)
 	GetRoomMessages(roomId int) ([]model.Message, error)
-	DeleteMessage(messageId, userId int) error
+	GetRoomMessagesPage(roomId int, page model.MessagePageQuery) ([]model.Message, error)
+	GetThreadMessagesPage(parentId int, page model.MessagePageQuery) ([]model.Message, error)
+	DeleteMessage(messageId, deletedBy int) error
 	GetMessageOwener(messageId int) (int, error)
 	UpdateMessage(messageId, userId int, content string) error
 	GetMessageById(messageId int) (model.Message, error)
+	GetMessageRevisions(messageId int) ([]model.MessageRevision, error)
+	PinMessage(roomId, messageId, userId int) (bool, error)
+	UnpinMessage(roomId, messageId int) error
+	GetPinnedMessages(roomId int) ([]model.PinnedMessage, error)
+	AddReaction(messageId, userId int, emoji string) (bool, error)
+	RemoveReaction(messageId, userId int, emoji string) (bool, error)
+	GetReactionCounts(messageIds []int) (map[int][]model.ReactionCount, error)
+	SearchMessages(userId int, search model.MessageSearchQuery) ([]model.MessageSearchResult, error)
+}
+
+type Outbox interface {
+	ProcessPending(limit int, publish func(event model.OutboxEvent) error) (int, error)
+	GetStats() (model.OutboxStats, error)
+}
+
+type Receipt interface {
+	AdvanceReadCursor(userId, roomId, messageId int) (model.ReadReceipt, bool, error)
+	GetRoomReceipts(roomId int) ([]model.ReadReceipt, error)
+	GetUnreadCounts(userId int, roomIds []int) ([]model.UnreadCount, error)
+	GetRoomReaders(roomId int) ([]int, error)
+}
+
+type Invite interface {
+	CreateInvite(invite model.RoomInvite) (model.RoomInvite, error)
+	GetInviteByCode(code string) (model.RoomInvite, error)
+	GetRoomInvites(roomId int) ([]model.RoomInvite, error)
+	RevokeInvite(roomId int, code string) error
+	AcceptInvite(code string, userId int) (model.RoomInvite, bool, error)
+}
+
+type Attachment interface {
+	CreateAttachment(attachment model.Attachment) (model.Attachment, error)
+	GetAttachmentById(attachmentId int) (model.Attachment, error)
+	GetMessageAttachments(messageIds []int) (map[int][]model.Attachment, error)
+	CreateUpload(upload model.AttachmentUpload) (model.AttachmentUpload, error)
+	GetUpload(uploadId string) (model.AttachmentUpload, error)
+	AdvanceUpload(uploadId string, offset, length int64, write func(part int) error) (model.AttachmentUpload, error)
+	DeleteExpiredAttachments(unusedBefore time.Time, limit int) ([]model.Attachment, error)
+	DeleteExpiredUploads(before time.Time, limit int) ([]model.AttachmentUpload, error)
+	DeleteUpload(uploadId string) error
+}
+
+type Token interface {
+	CreateRefreshToken(token model.RefreshToken) error
+	RotateRefreshToken(tokenHash string, next model.RefreshToken) (model.RefreshToken, error)
+	RevokeSession(userId int, sessionId string) error
+	RevokeUserSessions(userId int) ([]string, error)
+}
+
+type Identity interface {
+	GetIdentityUser(provider, subject string) (int, error)
+	LinkIdentity(userId int, identity model.UserIdentity) error
+	CreateUserWithIdentity(user model.User, identity model.UserIdentity) (int, error)
+}
+
+type TwoFactor interface {
+	GetTwoFactor(userId int) (model.TwoFactor, error)
+	SetPendingTwoFactor(userId int, secret string) (bool, error)
+	EnableTwoFactor(userId int, secret string, step int64, codeHashes []string) (bool, error)
+	UseTotpStep(userId int, step int64) (bool, error)
+	ReplaceRecoveryCodes(userId int, codeHashes []string) error
+	UseRecoveryCode(userId int, codeHash string) (bool, error)
+	DisableTwoFactor(userId int) error
 }
 
 type Repository struct {
@@ -39,6 +121,13 @@ type Repository struct {
 	Client
 	Room
 	Message
+	Outbox
+	Receipt
+	Invite
+	Attachment
+	Token
+	Identity
+	TwoFactor
 }
 
 func NewRepository(db *sqlx.DB) *Repository {
@@ -47,5 +136,12 @@ func NewRepository(db *sqlx.DB) *Repository {
 		Room:          NewRoomPostgres(db),
 		Message:       NewMessagePostgres(db),
 		Client:        NewClientPostgres(db),
+		Outbox:        NewOutboxPostgres(db),
+		Receipt:       NewReceiptPostgres(db),
+		Invite:        NewInvitePostgres(db),
+		Attachment:    NewAttachmentPostgres(db),
+		Token:         NewTokenPostgres(db),
+		Identity:      NewIdentityPostgres(db),
+		TwoFactor:     NewTwoFactorPostgres(db),
 	}
 }
@@ -6,9 +6,15 @@ import (
 	"fmt"
 	"github.com/firstproject/talk-together-app/model"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"strings"
 )
 
+var (
+	ErrParticipantBanned = errors.New("participant is banned in the conversation")
+	ErrMemberBanned      = errors.New("user is banned in the room")
+)
+
 type RoomPostgres struct {
 	db *sqlx.DB
 }
@@ -35,21 +41,96 @@ func (r *RoomPostgres) CreateRoom(userId int, room model.Room) (int, error) {
 	}
 
 	var id int
-	createRoomQuery := fmt.Sprintf("INSERT INTO %s (name, description, created_by) VALUES ($1, $2, $3) RETURNING id", roomsTable)
-	row := tx.QueryRow(createRoomQuery, room.Name, room.Description, userId)
+	createRoomQuery := fmt.Sprintf("INSERT INTO %s (name, description, visibility, created_by) VALUES ($1, $2, $3, $4) RETURNING id", roomsTable)
+	row := tx.QueryRow(createRoomQuery, room.Name, room.Description, room.Visibility, userId)
 	if err := row.Scan(&id); err != nil {
 		tx.Rollback()
 		return 0, err
 	}
 
+	addOwnerQuery := fmt.Sprintf("INSERT INTO %s (room_id, user_id, role) VALUES ($1, $2, $3)", roomMembersTable)
+	if _, err := tx.Exec(addOwnerQuery, id, userId, model.RoleOwner); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
 	return id, tx.Commit()
 
 }
 
+// Создает переписку или возвращает существующую с тем же набором участников
+// В существующую переписку возвращается только ее инициатор: другие покинувшие ее участники
+// сами решили уйти. Если кто-то из участников заблокирован в ней, возвращается ErrParticipantBanned
+func (r *RoomPostgres) CreateConversation(userId int, kind, participantKey string, participants []int) (model.Room, error) {
+	var room model.Room
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return room, err
+	}
+	defer tx.Rollback()
+
+	createQuery := fmt.Sprintf(`INSERT INTO %s (name, description, visibility, kind, participant_key, created_by)
+						VALUES ('', '', $1, $2, $3, $4)
+						ON CONFLICT (participant_key) DO NOTHING RETURNING *`, roomsTable)
+	err = tx.Get(&room, createQuery, model.RoomPrivate, kind, participantKey, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r.rejoinConversation(tx, userId, participantKey, participants)
+	}
+	if err != nil {
+		return room, err
+	}
+
+	for _, participant := range participants {
+		if _, err := addMember(tx, room.Id, participant); err != nil {
+			return room, err
+		}
+	}
+
+	return room, tx.Commit()
+}
+
+func (r *RoomPostgres) rejoinConversation(tx *sqlx.Tx, userId int, participantKey string, participants []int) (model.Room, error) {
+	var room model.Room
+
+	existingQuery := fmt.Sprintf("SELECT * FROM %s WHERE participant_key = $1", roomsTable)
+	if err := tx.Get(&room, existingQuery, participantKey); err != nil {
+		return room, err
+	}
+
+	var banned bool
+	bannedQuery := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE room_id = $1 AND user_id = ANY($2))", roomBansTable)
+	if err := tx.Get(&banned, bannedQuery, room.Id, pq.Array(participants)); err != nil {
+		return room, err
+	}
+	if banned {
+		return model.Room{}, ErrParticipantBanned
+	}
+
+	if _, err := addMember(tx, room.Id, userId); err != nil {
+		return room, err
+	}
+
+	return room, tx.Commit()
+}
+
+func (r *RoomPostgres) UsersExist(userIds []int) (bool, error) {
+	var count int
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id = ANY($1)", usersTable)
+	if err := r.db.Get(&count, query, pq.Array(userIds)); err != nil {
+		return false, err
+	}
+
+	return count == len(userIds), nil
+}
+
 func (r *RoomPostgres) GetAllRooms(userId int) ([]model.Room, error) {
 	var rooms []model.Room
 
-	query := fmt.Sprintf("SELECT * FROM %s WHERE created_by = $1", roomsTable)
+	query := fmt.Sprintf(`SELECT r.* FROM %s r
+						INNER JOIN %s rm ON rm.room_id = r.id
+						WHERE rm.user_id = $1 ORDER BY r.name`, roomsTable, roomMembersTable)
 	err := r.db.Select(&rooms, query, userId)
 
 	return rooms, err
@@ -58,11 +139,11 @@ func (r *RoomPostgres) GetAllRooms(userId int) ([]model.Room, error) {
 func (r *RoomPostgres) SearchRoomByName(name string) ([]model.Room, error) {
 	var rooms []model.Room
 
-	query := fmt.Sprintf(`SELECT * FROM %s WHERE name ILIKE $1 ORDER BY name`, roomsTable)
+	query := fmt.Sprintf(`SELECT * FROM %s WHERE name ILIKE $1 AND visibility <> $2 AND kind = $3 ORDER BY name`, roomsTable)
 
 	searchPattern := "%" + name + "%"
 
-	err := r.db.Select(&rooms, query, searchPattern)
+	err := r.db.Select(&rooms, query, searchPattern, model.RoomPrivate, model.RoomKindRoom)
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +161,8 @@ func (r *RoomPostgres) GetRoomById(id int) (model.Room, error) {
 	return room, err
 }
 
-func (r *RoomPostgres) UpdateRoom(roomId, userId int, input model.UpdateRoomInput) error {
+// Права на изменение проверяются в сервисе по роли участника
+func (r *RoomPostgres) UpdateRoom(roomId int, input model.UpdateRoomInput) error {
 	setValues := make([]string, 0)
 	args := make([]interface{}, 0)
 	argId := 1
@@ -97,16 +179,43 @@ func (r *RoomPostgres) UpdateRoom(roomId, userId int, input model.UpdateRoomInpu
 		argId++
 	}
 
+	if input.Visibility != nil {
+		setValues = append(setValues, fmt.Sprintf("visibility = $%d", argId))
+		args = append(args, *input.Visibility)
+		argId++
+	}
+
 	if len(setValues) == 0 {
 		return errors.New("no fields to update")
 	}
 
 	setQuery := strings.Join(setValues, ", ")
-	query := fmt.Sprintf("UPDATE %s SET %s WHERE id = $%d AND created_by = $%d", roomsTable, setQuery, argId, argId+1)
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE id = $%d RETURNING *", roomsTable, setQuery, argId)
+
+	args = append(args, roomId)
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var room model.Room
+	if err := tx.Get(&room, query, args...); err != nil {
+		return err
+	}
+
+	if err := insertOutboxEvent(tx, model.EventRoomUpdated, room.Id, room); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
-	args = append(args, roomId, userId)
+func (r *RoomPostgres) DeleteRoom(roomId int) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = $1", roomsTable)
 
-	result, err := r.db.Exec(query, args...)
+	result, err := r.db.Exec(query, roomId)
 	if err != nil {
 		return err
 	}
@@ -121,10 +230,177 @@ func (r *RoomPostgres) UpdateRoom(roomId, userId int, input model.UpdateRoomInpu
 	}
 
 	return nil
+
+}
+
+// Добавляет участника и записывает member.joined в outbox
+// Возвращает false, если пользователь уже состоит в комнате
+func (r *RoomPostgres) AddMember(roomId, userId int) (bool, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	added, err := addMember(tx, roomId, userId)
+	if err != nil || !added {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// Сериализует вступление и блокировку одного пользователя в комнате до конца транзакции,
+// иначе бан, выданный между проверкой и вставкой участника, не увидел бы новую строку
+func lockMembership(tx *sqlx.Tx, roomId, userId int) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock($1, $2)", roomId, userId)
+	return err
+}
+
+// Проверяет бан под lockMembership. Если бан есть, возвращает ErrMemberBanned
+func checkNotBanned(tx *sqlx.Tx, roomId, userId int) error {
+	var banned bool
+	query := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE room_id = $1 AND user_id = $2 FOR SHARE)", roomBansTable)
+	if err := tx.Get(&banned, query, roomId, userId); err != nil {
+		return err
+	}
+	if banned {
+		return ErrMemberBanned
+	}
+	return nil
 }
 
-func (r *RoomPostgres) DeleteRoom(userId, roomId int) error {
-	query := fmt.Sprintf("DELETE FROM %s WHERE id = $1 AND created_by = $2", roomsTable)
+func addMember(tx *sqlx.Tx, roomId, userId int) (bool, error) {
+	var member model.RoomMember
+	query := fmt.Sprintf(`INSERT INTO %s (room_id, user_id) VALUES ($1, $2)
+						ON CONFLICT (room_id, user_id) DO NOTHING
+						RETURNING room_id, user_id, role, joined_at`, roomMembersTable)
+	if err := tx.Get(&member, query, roomId, userId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := insertOutboxEvent(tx, model.EventMemberJoined, roomId, member); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Удаляет участника и записывает member.left в outbox
+func (r *RoomPostgres) RemoveMember(roomId, userId int) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := removeMember(tx, roomId, userId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func removeMember(tx *sqlx.Tx, roomId, userId int) error {
+	var member model.RoomMember
+	query := fmt.Sprintf(`DELETE FROM %s WHERE room_id = $1 AND user_id = $2
+						RETURNING room_id, user_id, role, joined_at`, roomMembersTable)
+	if err := tx.Get(&member, query, roomId, userId); err != nil {
+		return err
+	}
+
+	return insertOutboxEvent(tx, model.EventMemberLeft, roomId, member)
+}
+
+func (r *RoomPostgres) IsMember(roomId, userId int) (bool, error) {
+	var exists bool
+
+	query := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE room_id = $1 AND user_id = $2)", roomMembersTable)
+	err := r.db.Get(&exists, query, roomId, userId)
+
+	return exists, err
+}
+
+func (r *RoomPostgres) GetRoomMembers(roomId int) ([]model.RoomMember, error) {
+	var members []model.RoomMember
+
+	query := fmt.Sprintf("SELECT room_id, user_id, role, joined_at FROM %s WHERE room_id = $1 ORDER BY joined_at", roomMembersTable)
+	err := r.db.Select(&members, query, roomId)
+
+	return members, err
+}
+
+func (r *RoomPostgres) CountMembers(roomId int) (int, error) {
+	var count int
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE room_id = $1", roomMembersTable)
+	err := r.db.Get(&count, query, roomId)
+
+	return count, err
+}
+
+// Возвращает sql.ErrNoRows, если пользователь не состоит в комнате
+func (r *RoomPostgres) GetMember(roomId, userId int) (model.RoomMember, error) {
+	var member model.RoomMember
+
+	query := fmt.Sprintf("SELECT room_id, user_id, role, joined_at FROM %s WHERE room_id = $1 AND user_id = $2", roomMembersTable)
+	err := r.db.Get(&member, query, roomId, userId)
+
+	return member, err
+}
+
+// Меняет роль участника и записывает member.updated в outbox
+func (r *RoomPostgres) SetMemberRole(roomId, userId int, role string) (model.RoomMember, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return model.RoomMember{}, err
+	}
+	defer tx.Rollback()
+
+	var member model.RoomMember
+	query := fmt.Sprintf(`UPDATE %s SET role = $1 WHERE room_id = $2 AND user_id = $3
+						RETURNING room_id, user_id, role, joined_at`, roomMembersTable)
+	if err := tx.Get(&member, query, role, roomId, userId); err != nil {
+		return model.RoomMember{}, err
+	}
+
+	if err := insertOutboxEvent(tx, model.EventMemberUpdated, roomId, member); err != nil {
+		return model.RoomMember{}, err
+	}
+
+	return member, tx.Commit()
+}
+
+// Блокирует пользователя и исключает его из комнаты, если он в ней состоит
+func (r *RoomPostgres) BanMember(roomId, userId, bannedBy int) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockMembership(tx, roomId, userId); err != nil {
+		return err
+	}
+
+	banQuery := fmt.Sprintf(`INSERT INTO %s (room_id, user_id, banned_by) VALUES ($1, $2, $3)
+						ON CONFLICT (room_id, user_id) DO NOTHING`, roomBansTable)
+	if _, err := tx.Exec(banQuery, roomId, userId, bannedBy); err != nil {
+		return err
+	}
+
+	if err := removeMember(tx, roomId, userId); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *RoomPostgres) UnbanMember(roomId, userId int) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE room_id = $1 AND user_id = $2", roomBansTable)
 
 	result, err := r.db.Exec(query, roomId, userId)
 	if err != nil {
@@ -141,5 +417,22 @@ func (r *RoomPostgres) DeleteRoom(userId, roomId int) error {
 	}
 
 	return nil
+}
+
+func (r *RoomPostgres) IsBanned(roomId, userId int) (bool, error) {
+	var exists bool
+
+	query := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE room_id = $1 AND user_id = $2)", roomBansTable)
+	err := r.db.Get(&exists, query, roomId, userId)
+
+	return exists, err
+}
+
+func (r *RoomPostgres) GetRoomBans(roomId int) ([]model.RoomBan, error) {
+	var bans []model.RoomBan
+
+	query := fmt.Sprintf("SELECT room_id, user_id, COALESCE(banned_by, 0) AS banned_by, created_at FROM %s WHERE room_id = $1 ORDER BY created_at", roomBansTable)
+	err := r.db.Select(&bans, query, roomId)
 
+	return bans, err
 }
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+var (
+	ErrRefreshTokenInactive = errors.New("refresh token is expired or revoked")
+	ErrRefreshTokenReused   = errors.New("refresh token has already been used")
+)
+
+type TokenPostgres struct {
+	db *sqlx.DB
+}
+
+func NewTokenPostgres(db *sqlx.DB) *TokenPostgres {
+	return &TokenPostgres{db: db}
+}
+
+func (r *TokenPostgres) CreateRefreshToken(token model.RefreshToken) error {
+	query := fmt.Sprintf(`INSERT INTO %s (user_id, session_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)`, refreshTokensTable)
+	_, err := r.db.Exec(query, token.User, token.Session, token.TokenHash, token.ExpiresAt)
+
+	return err
+}
+
+// Погашает refresh токен и сохраняет следующий в той же сессии, возвращает погашенный токен
+// Повторное предъявление погашенного токена означает утечку: вся сессия отзывается
+func (r *TokenPostgres) RotateRefreshToken(tokenHash string, next model.RefreshToken) (model.RefreshToken, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return model.RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	var current model.RefreshToken
+	query := fmt.Sprintf(`SELECT * FROM %s WHERE token_hash = $1 FOR UPDATE`, refreshTokensTable)
+	if err := tx.Get(&current, query, tokenHash); err != nil {
+		return current, err
+	}
+
+	if current.RevokedAt != nil || !current.ExpiresAt.After(time.Now()) {
+		return current, ErrRefreshTokenInactive
+	}
+
+	if current.UsedAt != nil {
+		revokeQuery := fmt.Sprintf(`UPDATE %s SET revoked_at = NOW() WHERE session_id = $1 AND revoked_at IS NULL`, refreshTokensTable)
+		if _, err := tx.Exec(revokeQuery, current.Session); err != nil {
+			return current, err
+		}
+		if err := tx.Commit(); err != nil {
+			return current, err
+		}
+		return current, ErrRefreshTokenReused
+	}
+
+	useQuery := fmt.Sprintf(`UPDATE %s SET used_at = NOW() WHERE id = $1`, refreshTokensTable)
+	if _, err := tx.Exec(useQuery, current.Id); err != nil {
+		return current, err
+	}
+
+	insertQuery := fmt.Sprintf(`INSERT INTO %s (user_id, session_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)`, refreshTokensTable)
+	if _, err := tx.Exec(insertQuery, current.User, current.Session, next.TokenHash, next.ExpiresAt); err != nil {
+		return current, err
+	}
+
+	return current, tx.Commit()
+}
+
+func (r *TokenPostgres) RevokeSession(userId int, sessionId string) error {
+	query := fmt.Sprintf(`UPDATE %s SET revoked_at = NOW() WHERE user_id = $1 AND session_id = $2 AND revoked_at IS NULL`, refreshTokensTable)
+	_, err := r.db.Exec(query, userId, sessionId)
+
+	return err
+}
+
+// Отзывает все сессии пользователя и возвращает их идентификаторы
+func (r *TokenPostgres) RevokeUserSessions(userId int) ([]string, error) {
+	var sessions []string
+	query := fmt.Sprintf(`WITH revoked AS (
+							UPDATE %s SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL
+							RETURNING session_id
+						)
+						SELECT DISTINCT session_id FROM revoked`, refreshTokensTable)
+	err := r.db.Select(&sessions, query, userId)
+
+	return sessions, err
+}
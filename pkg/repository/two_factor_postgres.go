@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type TwoFactorPostgres struct {
+	db *sqlx.DB
+}
+
+func NewTwoFactorPostgres(db *sqlx.DB) *TwoFactorPostgres {
+	return &TwoFactorPostgres{db: db}
+}
+
+// Состояние второго фактора, для пользователя без 2FA секрет пустой
+func (r *TwoFactorPostgres) GetTwoFactor(userId int) (model.TwoFactor, error) {
+	var twoFactor model.TwoFactor
+	query := fmt.Sprintf(`SELECT u.id AS user_id, u.username, coalesce(t.secret, '') AS secret, t.enabled_at, coalesce(t.last_step, 0) AS last_step
+						FROM %s u
+						LEFT JOIN %s t ON t.user_id = u.id
+						WHERE u.id = $1`, usersTable, twoFactorTable)
+	err := r.db.Get(&twoFactor, query, userId)
+
+	return twoFactor, err
+}
+
+// Запоминает секрет до подтверждения кодом; включенный второй фактор не перезаписывается
+func (r *TwoFactorPostgres) SetPendingTwoFactor(userId int, secret string) (bool, error) {
+	query := fmt.Sprintf(`INSERT INTO %s (user_id, secret) VALUES ($1, $2)
+						ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = NOW()
+						WHERE %s.enabled_at IS NULL`, twoFactorTable, twoFactorTable)
+	return execAffected(r.db, query, userId, secret)
+}
+
+// Включает второй фактор для ожидающего подтверждения секрета и заменяет коды восстановления
+func (r *TwoFactorPostgres) EnableTwoFactor(userId int, secret string, step int64, codeHashes []string) (bool, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`UPDATE %s SET enabled_at = NOW(), last_step = $3
+						WHERE user_id = $1 AND secret = $2 AND enabled_at IS NULL`, twoFactorTable)
+	enabled, err := execAffected(tx, query, userId, secret, step)
+	if err != nil || !enabled {
+		return false, err
+	}
+
+	if err := replaceRecoveryCodes(tx, userId, codeHashes); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// Принимает шаг TOTP, только если он новее последнего принятого, так код нельзя использовать дважды
+func (r *TwoFactorPostgres) UseTotpStep(userId int, step int64) (bool, error) {
+	query := fmt.Sprintf(`UPDATE %s SET last_step = $2 WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_step < $2`, twoFactorTable)
+	return execAffected(r.db, query, userId, step)
+}
+
+func (r *TwoFactorPostgres) ReplaceRecoveryCodes(userId int, codeHashes []string) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userId, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Погашает код восстановления, false если код неверный или уже использован
+func (r *TwoFactorPostgres) UseRecoveryCode(userId int, codeHash string) (bool, error) {
+	query := fmt.Sprintf(`UPDATE %s SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, recoveryCodesTable)
+	return execAffected(r.db, query, userId, codeHash)
+}
+
+// Отключает второй фактор и удаляет коды восстановления
+func (r *TwoFactorPostgres) DisableTwoFactor(userId int) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1`, recoveryCodesTable), userId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1`, twoFactorTable), userId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sqlx.Tx, userId int, codeHashes []string) error {
+	if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1`, recoveryCodesTable), userId); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (user_id, code_hash) SELECT $1, unnest($2::varchar[])`, recoveryCodesTable)
+	_, err := tx.Exec(query, userId, pq.Array(codeHashes))
+
+	return err
+}
+
+func execAffected(db sqlx.Execer, query string, args ...interface{}) (bool, error) {
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	rowAffected, err := result.RowsAffected()
+	return rowAffected > 0, err
+}
@@ -0,0 +1,515 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/firstproject/talk-together-app/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// Сколько байт читается для определения типа файла, больше http.DetectContentType не использует
+	sniffLength = 512
+	// Наибольшая длина имени файла в байтах, как в большинстве файловых систем
+	maxFilenameLength = 255
+
+	attachmentCleanupInterval = 10 * time.Minute
+	attachmentCleanupBatch    = 100
+)
+
+var (
+	ErrAttachmentTooLarge = errors.New("attachment exceeds the size limit")
+	ErrInvalidUpload      = errors.New("chunk does not match the upload offset or size")
+	ErrUploadIncomplete   = errors.New("upload is not complete")
+	ErrInvalidSignature   = errors.New("download link is invalid or expired")
+	ErrInvalidAttachments = errors.New("attachments not found, already used or uploaded to another room")
+)
+
+// UnusedTTL - сколько живут вложения, не отправленные в сообщении, и незавершенные загрузки
+type AttachmentConfig struct {
+	MaxSize    int64
+	ChunkSize  int64
+	URLTTL     time.Duration
+	UnusedTTL  time.Duration
+	SigningKey []byte
+}
+
+type AttachmentService struct {
+	repo    repository.Attachment
+	members memberLookup
+	store   storage.BlobStore
+	cfg     AttachmentConfig
+	now     func() time.Time
+}
+
+func NewAttachmentService(repo repository.Attachment, members memberLookup, store storage.BlobStore, cfg AttachmentConfig) *AttachmentService {
+	if cfg.UnusedTTL == 0 {
+		cfg.UnusedTTL = 24 * time.Hour
+	}
+	return &AttachmentService{repo: repo, members: members, store: store, cfg: cfg, now: time.Now}
+}
+
+func (s *AttachmentService) MaxSize() int64 {
+	return s.cfg.MaxSize
+}
+
+// Загружает файл целиком, size - заявленный размер из multipart
+func (s *AttachmentService) Upload(ctx context.Context, roomId, userId int, filename string, size int64, r io.Reader) (model.Attachment, error) {
+	if _, err := authorize(s.members, roomId, userId, model.PermSendMessage); err != nil {
+		return model.Attachment{}, err
+	}
+
+	if size > s.cfg.MaxSize {
+		return model.Attachment{}, ErrAttachmentTooLarge
+	}
+
+	return s.save(ctx, roomId, userId, filename, size, r)
+}
+
+// Тип файла определяется по содержимому, заявленный клиентом тип не используется
+func (s *AttachmentService) save(ctx context.Context, roomId, userId int, filename string, size int64, r io.Reader) (model.Attachment, error) {
+	buffered := bufio.NewReaderSize(r, sniffLength)
+	head, err := buffered.Peek(sniffLength)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return model.Attachment{}, err
+	}
+
+	key, err := newStorageKey(fmt.Sprintf("attachments/%d", roomId))
+	if err != nil {
+		return model.Attachment{}, err
+	}
+
+	attachment := model.Attachment{
+		Room:        roomId,
+		User:        userId,
+		Filename:    sanitizeFilename(filename),
+		ContentType: http.DetectContentType(head),
+		Size:        size,
+		StorageKey:  key,
+	}
+
+	if err := s.putExact(ctx, key, buffered, size, attachment.ContentType); err != nil {
+		return model.Attachment{}, err
+	}
+
+	if thumbnailTypes[attachment.ContentType] {
+		s.storeThumbnail(ctx, &attachment)
+	}
+
+	created, err := s.repo.CreateAttachment(attachment)
+	if err != nil {
+		s.deleteBlobs(attachment)
+		return model.Attachment{}, err
+	}
+
+	s.sign(&created)
+	return created, nil
+}
+
+// Сохраняет ровно size байт, при расхождении с фактическим размером файл удаляется
+func (s *AttachmentService) putExact(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	counter := &countingReader{r: io.LimitReader(r, size+1)}
+	if err := s.store.Put(ctx, key, counter, size, contentType); err != nil {
+		return err
+	}
+
+	if counter.n != size {
+		s.store.Delete(ctx, key)
+		return ErrInvalidUpload
+	}
+
+	return nil
+}
+
+// Ошибка построения миниатюры не мешает загрузке - вложение просто остается без нее
+func (s *AttachmentService) storeThumbnail(ctx context.Context, attachment *model.Attachment) {
+	original, err := s.store.Get(ctx, attachment.StorageKey)
+	if err != nil {
+		logrus.Errorf("failed to read attachment %s for thumbnail: %s", attachment.StorageKey, err.Error())
+		return
+	}
+	defer original.Close()
+
+	thumb, contentType, err := makeThumbnail(original, attachment.ContentType)
+	if err != nil {
+		if !errors.Is(err, errThumbnailUnsupported) {
+			logrus.Errorf("failed to build thumbnail for %s: %s", attachment.StorageKey, err.Error())
+		}
+		return
+	}
+
+	key := attachment.StorageKey + "-thumb"
+	if err := s.store.Put(ctx, key, bytes.NewReader(thumb), int64(len(thumb)), contentType); err != nil {
+		logrus.Errorf("failed to store thumbnail %s: %s", key, err.Error())
+		return
+	}
+
+	attachment.ThumbnailKey = &key
+}
+
+func (s *AttachmentService) deleteBlobs(attachment model.Attachment) {
+	ctx := context.Background()
+	if err := s.store.Delete(ctx, attachment.StorageKey); err != nil {
+		logrus.Errorf("failed to delete blob %s: %s", attachment.StorageKey, err.Error())
+	}
+	if attachment.ThumbnailKey != nil {
+		s.store.Delete(ctx, *attachment.ThumbnailKey)
+	}
+}
+
+// Начинает загрузку по частям, части отправляются последовательно размером до ChunkSize
+func (s *AttachmentService) StartUpload(roomId, userId int, input model.CreateUploadInput) (model.AttachmentUpload, error) {
+	if _, err := authorize(s.members, roomId, userId, model.PermSendMessage); err != nil {
+		return model.AttachmentUpload{}, err
+	}
+
+	if input.Size <= 0 {
+		return model.AttachmentUpload{}, ErrInvalidUpload
+	}
+
+	if input.Size > s.cfg.MaxSize {
+		return model.AttachmentUpload{}, ErrAttachmentTooLarge
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return model.AttachmentUpload{}, err
+	}
+
+	upload, err := s.repo.CreateUpload(model.AttachmentUpload{
+		Id:       hex.EncodeToString(buf),
+		Room:     roomId,
+		User:     userId,
+		Filename: input.Filename,
+		Size:     input.Size,
+	})
+	upload.ChunkSize = s.cfg.ChunkSize
+
+	return upload, err
+}
+
+// Принимает часть, начинающуюся с текущего смещения загрузки
+// Повтор уже принятой части отклоняется - клиент продолжает с received из ответа
+func (s *AttachmentService) UploadChunk(ctx context.Context, uploadId string, userId int, offset, length int64, r io.Reader) (model.AttachmentUpload, error) {
+	upload, err := s.ownUpload(uploadId, userId)
+	if err != nil {
+		return upload, err
+	}
+
+	if offset != upload.Received || length <= 0 || length > s.cfg.ChunkSize || offset+length > upload.Size {
+		return upload, ErrInvalidUpload
+	}
+
+	upload, err = s.repo.AdvanceUpload(uploadId, offset, length, func(part int) error {
+		return s.putExact(ctx, uploadPartKey(uploadId, part), r, length, "application/octet-stream")
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return upload, ErrInvalidUpload
+	}
+	upload.ChunkSize = s.cfg.ChunkSize
+
+	return upload, err
+}
+
+// Склеивает части в один файл и создает вложение, части и запись о загрузке удаляются
+func (s *AttachmentService) CompleteUpload(ctx context.Context, uploadId string, userId int) (model.Attachment, error) {
+	upload, err := s.ownUpload(uploadId, userId)
+	if err != nil {
+		return model.Attachment{}, err
+	}
+
+	if _, err := authorize(s.members, upload.Room, userId, model.PermSendMessage); err != nil {
+		return model.Attachment{}, err
+	}
+
+	if upload.Received != upload.Size {
+		return model.Attachment{}, ErrUploadIncomplete
+	}
+
+	keys := make([]string, 0, upload.Parts)
+	for i := 0; i < upload.Parts; i++ {
+		keys = append(keys, uploadPartKey(uploadId, i))
+	}
+
+	parts := &partsReader{ctx: ctx, store: s.store, keys: keys}
+	attachment, err := s.save(ctx, upload.Room, upload.User, upload.Filename, upload.Size, parts)
+	parts.Close()
+	if err != nil {
+		return model.Attachment{}, err
+	}
+
+	for _, key := range keys {
+		if err := s.store.Delete(ctx, key); err != nil {
+			logrus.Errorf("failed to delete upload part %s: %s", key, err.Error())
+		}
+	}
+
+	if err := s.repo.DeleteUpload(uploadId); err != nil {
+		logrus.Errorf("failed to delete upload %s: %s", uploadId, err.Error())
+	}
+
+	return attachment, nil
+}
+
+// Периодически удаляет файлы вложений удаленных сообщений, так и не отправленных вложений
+// и брошенных загрузок. Записи удаляются раньше файлов: ссылка не должна вести на удаленный файл
+func (s *AttachmentService) RunCleanup(ctx context.Context) {
+	ticker := time.NewTicker(attachmentCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Cleanup(ctx); err != nil {
+				logrus.Errorf("attachment cleanup error: %s", err.Error())
+			}
+		}
+	}
+}
+
+func (s *AttachmentService) Cleanup(ctx context.Context) error {
+	before := s.now().Add(-s.cfg.UnusedTTL)
+
+	for {
+		attachments, err := s.repo.DeleteExpiredAttachments(before, attachmentCleanupBatch)
+		if err != nil {
+			return err
+		}
+		for _, attachment := range attachments {
+			s.deleteBlobs(attachment)
+		}
+		if len(attachments) < attachmentCleanupBatch {
+			break
+		}
+	}
+
+	for {
+		uploads, err := s.repo.DeleteExpiredUploads(before, attachmentCleanupBatch)
+		if err != nil {
+			return err
+		}
+		for _, upload := range uploads {
+			for i := 0; i < upload.Parts; i++ {
+				if err := s.store.Delete(ctx, uploadPartKey(upload.Id, i)); err != nil {
+					logrus.Errorf("failed to delete upload part %s: %s", uploadPartKey(upload.Id, i), err.Error())
+				}
+			}
+		}
+		if len(uploads) < attachmentCleanupBatch {
+			return nil
+		}
+	}
+}
+
+// Чужая загрузка выглядит как несуществующая
+func (s *AttachmentService) ownUpload(uploadId string, userId int) (model.AttachmentUpload, error) {
+	upload, err := s.repo.GetUpload(uploadId)
+	if err != nil {
+		return upload, err
+	}
+
+	if upload.User != userId {
+		return model.AttachmentUpload{}, sql.ErrNoRows
+	}
+
+	return upload, nil
+}
+
+// Возвращает вложение с подписанными ссылками, если пользователь состоит в комнате
+func (s *AttachmentService) GetAttachment(attachmentId, userId int) (model.Attachment, error) {
+	attachment, err := s.repo.GetAttachmentById(attachmentId)
+	if err != nil {
+		return attachment, err
+	}
+
+	if err := requireMember(s.members, attachment.Room, userId); err != nil {
+		return model.Attachment{}, err
+	}
+
+	s.sign(&attachment)
+	return attachment, nil
+}
+
+func (s *AttachmentService) GetMessageAttachments(messageIds []int) (map[int][]model.Attachment, error) {
+	return s.repo.GetMessageAttachments(messageIds)
+}
+
+func (s *AttachmentService) SignAttachments(attachments []model.Attachment) {
+	for i := range attachments {
+		s.sign(&attachments[i])
+	}
+}
+
+func (s *AttachmentService) sign(attachment *model.Attachment) {
+	expires := s.now().Add(s.cfg.URLTTL).Unix()
+
+	attachment.URL = s.signedURL(attachment.Id, model.AttachmentOriginal, expires)
+	if attachment.ThumbnailKey != nil {
+		attachment.ThumbnailURL = s.signedURL(attachment.Id, model.AttachmentThumbnail, expires)
+	}
+}
+
+func (s *AttachmentService) signedURL(attachmentId int, variant string, expires int64) string {
+	return fmt.Sprintf("/files/attachments/%d?variant=%s&expires=%d&signature=%s",
+		attachmentId, variant, expires, s.signature(attachmentId, variant, expires))
+}
+
+func (s *AttachmentService) signature(attachmentId int, variant string, expires int64) string {
+	mac := hmac.New(sha256.New, s.cfg.SigningKey)
+	fmt.Fprintf(mac, "%d:%s:%d", attachmentId, variant, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Открывает файл по подписанной ссылке, членство проверено при выдаче ссылки
+// Возвращает поток, вложение и тип содержимого варианта
+func (s *AttachmentService) OpenAttachment(ctx context.Context, attachmentId int, variant string, expires int64, signature string) (io.ReadCloser, model.Attachment, string, error) {
+	expected := s.signature(attachmentId, variant, expires)
+	if !hmac.Equal([]byte(expected), []byte(signature)) || s.now().Unix() >= expires {
+		return nil, model.Attachment{}, "", ErrInvalidSignature
+	}
+
+	attachment, err := s.repo.GetAttachmentById(attachmentId)
+	if err != nil {
+		return nil, attachment, "", err
+	}
+
+	key, contentType := attachment.StorageKey, attachment.ContentType
+	if variant == model.AttachmentThumbnail {
+		if attachment.ThumbnailKey == nil {
+			return nil, attachment, "", sql.ErrNoRows
+		}
+		key, contentType = *attachment.ThumbnailKey, "image/png"
+		if attachment.ContentType == "image/jpeg" {
+			contentType = "image/jpeg"
+		}
+	}
+
+	blob, err := s.store.Get(ctx, key)
+	if errors.Is(err, storage.ErrBlobNotFound) {
+		return nil, attachment, "", sql.ErrNoRows
+	}
+
+	return blob, attachment, contentType, err
+}
+
+func newStorageKey(prefix string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return prefix + "/" + hex.EncodeToString(buf), nil
+}
+
+func uploadPartKey(uploadId string, part int) string {
+	return fmt.Sprintf("uploads/%s/%06d", uploadId, part)
+}
+
+// Оставляет только имя файла без пути и управляющих символов
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+
+	return truncateFilename(name, maxFilenameLength)
+}
+
+// Укорачивает имя по границе символа, сохраняя расширение
+func truncateFilename(name string, limit int) string {
+	if len(name) <= limit {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	if len(ext) > limit/2 {
+		ext = ""
+	}
+
+	base := name[:len(name)-len(ext)]
+	cut := limit - len(ext)
+	for cut > 0 && !utf8.RuneStart(base[cut]) {
+		cut--
+	}
+
+	return base[:cut] + ext
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Последовательно читает части загрузки, открывая каждую только когда до нее дошла очередь
+type partsReader struct {
+	ctx     context.Context
+	store   storage.BlobStore
+	keys    []string
+	current io.ReadCloser
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.current == nil {
+			if len(p.keys) == 0 {
+				return 0, io.EOF
+			}
+
+			part, err := p.store.Get(p.ctx, p.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			p.current, p.keys = part, p.keys[1:]
+		}
+
+		n, err := p.current.Read(b)
+		if errors.Is(err, io.EOF) {
+			p.current.Close()
+			p.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.current != nil {
+		return p.current.Close()
+	}
+	return nil
+}
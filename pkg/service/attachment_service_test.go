@@ -0,0 +1,320 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/firstproject/talk-together-app/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+type attachmentRepo struct {
+	repository.Attachment
+	attachments     map[int]model.Attachment
+	uploads         map[string]model.AttachmentUpload
+	deletedMessages map[int]bool
+	lastId          int
+}
+
+func newAttachmentRepo() *attachmentRepo {
+	return &attachmentRepo{
+		attachments:     make(map[int]model.Attachment),
+		uploads:         make(map[string]model.AttachmentUpload),
+		deletedMessages: make(map[int]bool),
+	}
+}
+
+func (r *attachmentRepo) CreateAttachment(attachment model.Attachment) (model.Attachment, error) {
+	r.lastId++
+	attachment.Id, attachment.CreatedAt = r.lastId, time.Now()
+	r.attachments[attachment.Id] = attachment
+	return attachment, nil
+}
+
+func (r *attachmentRepo) GetAttachmentById(attachmentId int) (model.Attachment, error) {
+	attachment, ok := r.attachments[attachmentId]
+	if !ok || attachment.MessageId != nil && r.deletedMessages[*attachment.MessageId] {
+		return model.Attachment{}, sql.ErrNoRows
+	}
+	return attachment, nil
+}
+
+func (r *attachmentRepo) DeleteExpiredAttachments(unusedBefore time.Time, limit int) ([]model.Attachment, error) {
+	var expired []model.Attachment
+	for id, attachment := range r.attachments {
+		if len(expired) == limit {
+			break
+		}
+		if attachment.MessageId == nil && attachment.CreatedAt.Before(unusedBefore) ||
+			attachment.MessageId != nil && r.deletedMessages[*attachment.MessageId] {
+			expired = append(expired, attachment)
+			delete(r.attachments, id)
+		}
+	}
+	return expired, nil
+}
+
+func (r *attachmentRepo) CreateUpload(upload model.AttachmentUpload) (model.AttachmentUpload, error) {
+	upload.CreatedAt = time.Now()
+	r.uploads[upload.Id] = upload
+	return upload, nil
+}
+
+func (r *attachmentRepo) GetUpload(uploadId string) (model.AttachmentUpload, error) {
+	upload, ok := r.uploads[uploadId]
+	if !ok {
+		return upload, sql.ErrNoRows
+	}
+	return upload, nil
+}
+
+func (r *attachmentRepo) AdvanceUpload(uploadId string, offset, length int64, write func(part int) error) (model.AttachmentUpload, error) {
+	upload := r.uploads[uploadId]
+	if upload.Received != offset {
+		return upload, sql.ErrNoRows
+	}
+	if err := write(upload.Parts); err != nil {
+		return upload, err
+	}
+	upload.Received += length
+	upload.Parts++
+	r.uploads[uploadId] = upload
+	return upload, nil
+}
+
+func (r *attachmentRepo) DeleteUpload(uploadId string) error {
+	delete(r.uploads, uploadId)
+	return nil
+}
+
+func (r *attachmentRepo) DeleteExpiredUploads(before time.Time, limit int) ([]model.AttachmentUpload, error) {
+	var expired []model.AttachmentUpload
+	for id, upload := range r.uploads {
+		if len(expired) < limit && upload.CreatedAt.Before(before) {
+			expired = append(expired, upload)
+			delete(r.uploads, id)
+		}
+	}
+	return expired, nil
+}
+
+func newTestAttachmentService(t *testing.T) (*AttachmentService, *attachmentRepo) {
+	store, err := storage.NewLocalStore(t.TempDir())
+	require.NoError(t, err)
+
+	repo := newAttachmentRepo()
+	members := newMemberRepo(map[int]string{1: model.RoleMember, 2: model.RoleReadOnly})
+	s := NewAttachmentService(repo, members, store, AttachmentConfig{
+		MaxSize:    1 << 20,
+		ChunkSize:  1024,
+		URLTTL:     time.Minute,
+		SigningKey: []byte("test-key"),
+	})
+	return s, repo
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// Разбирает подписанную ссылку на параметры OpenAttachment
+func parseSignedURL(t *testing.T, raw string) (string, int64, string) {
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+
+	expires, err := strconv.ParseInt(u.Query().Get("expires"), 10, 64)
+	require.NoError(t, err)
+	return u.Query().Get("variant"), expires, u.Query().Get("signature")
+}
+
+func TestUploadAttachment_Permissions(t *testing.T) {
+	s, _ := newTestAttachmentService(t)
+	ctx := context.Background()
+
+	_, err := s.Upload(ctx, 1, 2, "a.txt", 5, bytes.NewReader([]byte("hello")))
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	_, err = s.Upload(ctx, 1, 3, "a.txt", 5, bytes.NewReader([]byte("hello")))
+	assert.ErrorIs(t, err, ErrNotRoomMember)
+
+	_, err = s.Upload(ctx, 1, 1, "a.txt", 2<<20, bytes.NewReader(nil))
+	assert.ErrorIs(t, err, ErrAttachmentTooLarge)
+
+	// Заявленный размер должен совпадать с фактическим
+	_, err = s.Upload(ctx, 1, 1, "a.txt", 10, bytes.NewReader([]byte("hello")))
+	assert.ErrorIs(t, err, ErrInvalidUpload)
+
+	attachment, err := s.Upload(ctx, 1, 1, "../../etc/a.txt", 5, bytes.NewReader([]byte("hello")))
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt", attachment.Filename)
+	assert.Equal(t, "text/plain; charset=utf-8", attachment.ContentType)
+	assert.Nil(t, attachment.ThumbnailKey)
+	assert.Empty(t, attachment.ThumbnailURL)
+}
+
+func TestChunkedUpload_ImageThumbnail(t *testing.T) {
+	s, repo := newTestAttachmentService(t)
+	ctx := context.Background()
+	data := testPNG(t, 640, 200)
+
+	upload, err := s.StartUpload(1, 1, model.CreateUploadInput{Filename: "photo.png", Size: int64(len(data))})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), upload.ChunkSize)
+
+	_, err = s.CompleteUpload(ctx, upload.Id, 1)
+	assert.ErrorIs(t, err, ErrUploadIncomplete)
+
+	_, err = s.UploadChunk(ctx, upload.Id, 1, 10, 10, bytes.NewReader(data[10:20]))
+	assert.ErrorIs(t, err, ErrInvalidUpload)
+
+	_, err = s.UploadChunk(ctx, upload.Id, 2, 0, 10, bytes.NewReader(data[:10]))
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	for offset := int64(0); offset < int64(len(data)); offset += upload.ChunkSize {
+		end := min(offset+upload.ChunkSize, int64(len(data)))
+		upload, err = s.UploadChunk(ctx, upload.Id, 1, offset, end-offset, bytes.NewReader(data[offset:end]))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(len(data)), upload.Received)
+
+	attachment, err := s.CompleteUpload(ctx, upload.Id, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", attachment.ContentType)
+	assert.NotNil(t, attachment.ThumbnailKey)
+	assert.Empty(t, repo.uploads)
+
+	variant, expires, signature := parseSignedURL(t, attachment.URL)
+	blob, _, contentType, err := s.OpenAttachment(ctx, attachment.Id, variant, expires, signature)
+	require.NoError(t, err)
+	original, _ := io.ReadAll(blob)
+	blob.Close()
+	assert.Equal(t, "image/png", contentType)
+	assert.Equal(t, data, original)
+
+	variant, expires, signature = parseSignedURL(t, attachment.ThumbnailURL)
+	blob, _, _, err = s.OpenAttachment(ctx, attachment.Id, variant, expires, signature)
+	require.NoError(t, err)
+	thumb, err := png.DecodeConfig(blob)
+	blob.Close()
+	require.NoError(t, err)
+	assert.Equal(t, thumbnailSide, thumb.Width)
+	assert.Equal(t, 100, thumb.Height)
+}
+
+func TestOpenAttachment_Signature(t *testing.T) {
+	s, _ := newTestAttachmentService(t)
+	ctx := context.Background()
+
+	attachment, err := s.Upload(ctx, 1, 1, "a.txt", 5, bytes.NewReader([]byte("hello")))
+	require.NoError(t, err)
+	variant, expires, signature := parseSignedURL(t, attachment.URL)
+
+	_, _, _, err = s.OpenAttachment(ctx, attachment.Id+1, variant, expires, signature)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, _, _, err = s.OpenAttachment(ctx, attachment.Id, model.AttachmentThumbnail, expires, signature)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, _, _, err = s.OpenAttachment(ctx, attachment.Id, variant, expires+60, signature)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	s.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	_, _, _, err = s.OpenAttachment(ctx, attachment.Id, variant, expires, signature)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, err = s.GetAttachment(attachment.Id, 3)
+	assert.ErrorIs(t, err, ErrNotRoomMember)
+
+	fresh, err := s.GetAttachment(attachment.Id, 2)
+	require.NoError(t, err)
+	variant, expires, signature = parseSignedURL(t, fresh.URL)
+	blob, _, _, err := s.OpenAttachment(ctx, attachment.Id, variant, expires, signature)
+	require.NoError(t, err)
+	blob.Close()
+}
+
+func TestCleanup_RemovesDeletedAndUnusedAttachments(t *testing.T) {
+	s, repo := newTestAttachmentService(t)
+	ctx := context.Background()
+
+	upload := func(messageId int) model.Attachment {
+		attachment, err := s.Upload(ctx, 1, 1, "a.txt", 5, bytes.NewReader([]byte("hello")))
+		require.NoError(t, err)
+		if messageId != 0 {
+			attachment.MessageId = &messageId
+			repo.attachments[attachment.Id] = attachment
+		}
+		return attachment
+	}
+	unused, deleted, live := upload(0), upload(7), upload(8)
+	repo.deletedMessages[7] = true
+
+	// Вложение удаленного сообщения не отдается, даже если ссылка была выдана раньше
+	_, err := s.GetAttachment(deleted.Id, 1)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	variant, expires, signature := parseSignedURL(t, deleted.URL)
+	_, _, _, err = s.OpenAttachment(ctx, deleted.Id, variant, expires, signature)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	started, err := s.StartUpload(1, 1, model.CreateUploadInput{Filename: "big.bin", Size: 2048})
+	require.NoError(t, err)
+	_, err = s.UploadChunk(ctx, started.Id, 1, 0, 1024, bytes.NewReader(make([]byte, 1024)))
+	require.NoError(t, err)
+
+	// Свежие неотправленные вложения и загрузки остаются
+	require.NoError(t, s.Cleanup(ctx))
+	assert.Len(t, repo.attachments, 2)
+	assert.Contains(t, repo.uploads, started.Id)
+
+	s.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	require.NoError(t, s.Cleanup(ctx))
+
+	assert.Equal(t, map[int]model.Attachment{live.Id: repo.attachments[live.Id]}, repo.attachments)
+	assert.Empty(t, repo.uploads)
+	for _, key := range []string{unused.StorageKey, deleted.StorageKey, uploadPartKey(started.Id, 0)} {
+		_, err := s.store.Get(ctx, key)
+		assert.ErrorIs(t, err, storage.ErrBlobNotFound, key)
+	}
+	blob, err := s.store.Get(ctx, live.StorageKey)
+	require.NoError(t, err)
+	blob.Close()
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	long := strings.Repeat("отчет", 60) + ".pdf"
+
+	name := sanitizeFilename("C:\\Users\\alice\\" + long)
+	assert.True(t, utf8.ValidString(name))
+	assert.LessOrEqual(t, len(name), maxFilenameLength)
+	assert.True(t, strings.HasPrefix(name, "отчет"))
+	assert.True(t, strings.HasSuffix(name, ".pdf"))
+	assert.Len(t, name, 254, "the half of a two-byte letter that does not fit is dropped")
+
+	name = sanitizeFilename(strings.Repeat("я", 200))
+	assert.True(t, utf8.ValidString(name))
+	assert.Equal(t, strings.Repeat("я", 127), name)
+
+	assert.Equal(t, "report.pdf", sanitizeFilename("/tmp/\x00report.pdf"))
+}
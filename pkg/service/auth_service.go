@@ -1,73 +1,350 @@
 package service
 
 import (
-	"crypto/sha1"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/firstproject/talk-together-app/model"
 	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/sirupsen/logrus"
 	"time"
 )
 
-const (
-	signInKey = "k9r2u9ur03uf894f"
-	tokenTTL  = 2 * time.Hour
+var (
+	ErrInvalidCredentials  = errors.New("invalid username or password")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrTokenRevoked        = errors.New("token has been revoked")
 )
 
+// Время жизни токенов: access проверяется без обращения к БД, поэтому живет недолго
+type TokenConfig struct {
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+func (c TokenConfig) withDefaults() TokenConfig {
+	if c.AccessTTL == 0 {
+		c.AccessTTL = 15 * time.Minute
+	}
+	if c.RefreshTTL == 0 {
+		c.RefreshTTL = 30 * 24 * time.Hour
+	}
+	return c
+}
+
+// Список отозванных токенов и сессий с рассылкой отзыва по узлам, реализуется redis.Client
+type tokenDenylist interface {
+	DenyTokens(ids []string, ttl time.Duration) error
+	AnyTokenDenied(ids []string) (bool, error)
+	PublishRevokedSessions(sessionIds []string) error
+}
+
 type tokenClaims struct {
 	jwt.StandardClaims
-	UserId int `json:"user_id"`
+	UserId  int    `json:"user_id"`
+	Session string `json:"sid"`
 }
+
 type AuthService struct {
-	repo repository.Authorization
+	repo         repository.Authorization
+	tokens       repository.Token
+	twoFactor    repository.TwoFactor
+	denylist     tokenDenylist
+	challenges   twoFactorStore
+	keys         *KeySet
+	passwords    PasswordConfig
+	ttl          TokenConfig
+	twoFactorCfg TwoFactorConfig
+	// Хеш для проверки при несуществующем пользователе, чтобы время ответа не выдавало логины
+	dummyHash string
+	now       func() time.Time
 }
 
-func NewAuthService(repo repository.Authorization) *AuthService {
-	return &AuthService{repo: repo}
+func NewAuthService(repo repository.Authorization, tokens repository.Token, twoFactor repository.TwoFactor, denylist tokenDenylist, challenges twoFactorStore, keys *KeySet, passwords PasswordConfig, ttl TokenConfig, twoFactorCfg TwoFactorConfig) *AuthService {
+	passwords = passwords.withDefaults()
+	dummyHash, err := hashPassword("", passwords)
+	if err != nil {
+		logrus.Errorf("failed to prepare dummy password hash: %s", err.Error())
+	}
+
+	return &AuthService{
+		repo:         repo,
+		tokens:       tokens,
+		twoFactor:    twoFactor,
+		denylist:     denylist,
+		challenges:   challenges,
+		keys:         keys,
+		passwords:    passwords,
+		ttl:          ttl.withDefaults(),
+		twoFactorCfg: twoFactorCfg.withDefaults(),
+		dummyHash:    dummyHash,
+		now:          time.Now,
+	}
 }
 
 func (s *AuthService) CreateUser(user model.User) (int, error) {
-	user.Password = generatePasswordHash(user.Password)
+	hash, err := hashPassword(user.Password, s.passwords)
+	if err != nil {
+		return 0, err
+	}
+
+	user.Password = hash
 	return s.repo.CreateUser(user)
 }
 
-func (s *AuthService) GenerateToken(userName, password string) (string, error) {
-	user, err := s.repo.GetUser(userName, generatePasswordHash(password))
+// Каждый вход открывает новую сессию со своей цепочкой refresh токенов
+// При включенной 2FA токены выдаются только после второго шага, см. VerifyTwoFactor
+func (s *AuthService) GenerateToken(userName, password string) (model.SignInResult, error) {
+	user, err := s.authenticate(userName, password)
 	if err != nil {
-		return "", err
+		return model.SignInResult{}, err
+	}
+
+	return s.signIn(user.Id)
+}
+
+// Завершает первый шаг входа любым способом: пароль или внешний провайдер
+// не заменяют второй фактор, если он включен
+func (s *AuthService) signIn(userId int) (model.SignInResult, error) {
+	twoFactor, err := s.twoFactor.GetTwoFactor(userId)
+	if err != nil {
+		return model.SignInResult{}, err
+	}
+	if twoFactor.Enabled() {
+		return s.newTwoFactorChallenge(userId)
+	}
+
+	tokens, err := s.startSession(userId)
+	if err != nil {
+		return model.SignInResult{}, err
+	}
+
+	return model.SignInResult{TokenPair: &tokens}, nil
+}
+
+// Открывает сессию для уже проверенного пользователя
+func (s *AuthService) startSession(userId int) (model.TokenPair, error) {
+	session, err := randomHex(16)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	refreshToken, refresh, err := s.newRefreshToken()
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	refresh.User, refresh.Session = userId, session
+
+	if err := s.tokens.CreateRefreshToken(refresh); err != nil {
+		return model.TokenPair{}, err
+	}
+
+	return s.newTokenPair(userId, session, refreshToken)
+}
+
+// Обменивает refresh токен на новую пару, старый токен становится недействительным
+// Повторное использование токена отзывает всю сессию вместе с выданными по ней access токенами
+func (s *AuthService) RefreshToken(refreshToken string) (model.TokenPair, error) {
+	nextToken, next, err := s.newRefreshToken()
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	used, err := s.tokens.RotateRefreshToken(hashToken(refreshToken), next)
+	switch {
+	case errors.Is(err, repository.ErrRefreshTokenReused):
+		logrus.Warnf("refresh token reuse detected for user %d, session %s revoked", used.User, used.Session)
+		if err := s.revokeSessions([]string{used.Session}); err != nil {
+			return model.TokenPair{}, err
+		}
+		return model.TokenPair{}, ErrInvalidRefreshToken
+	case errors.Is(err, repository.ErrRefreshTokenInactive), errors.Is(err, sql.ErrNoRows):
+		return model.TokenPair{}, ErrInvalidRefreshToken
+	case err != nil:
+		return model.TokenPair{}, err
+	}
+
+	return s.newTokenPair(used.User, used.Session, nextToken)
+}
+
+// Завершает сессию токена: refresh токены отзываются, access токен попадает в denylist
+func (s *AuthService) Logout(token model.AccessToken) error {
+	if err := s.tokens.RevokeSession(token.User, token.Session); err != nil {
+		return err
+	}
+
+	if err := s.denylist.DenyTokens([]string{tokenDenyKey(token.Id)}, s.ttl.AccessTTL); err != nil {
+		return err
+	}
+
+	return s.revokeSessions([]string{token.Session})
+}
+
+// Завершает все сессии пользователя на всех устройствах
+func (s *AuthService) LogoutAll(userId int) error {
+	sessions, err := s.tokens.RevokeUserSessions(userId)
+	if err != nil {
+		return err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &tokenClaims{
+
+	return s.revokeSessions(sessions)
+}
+
+// Access токены отозванных сессий отклоняются до истечения их срока, открытые WebSocket закрываются
+func (s *AuthService) revokeSessions(sessions []string) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		keys = append(keys, sessionDenyKey(session))
+	}
+
+	if err := s.denylist.DenyTokens(keys, s.ttl.AccessTTL); err != nil {
+		return err
+	}
+
+	return s.denylist.PublishRevokedSessions(sessions)
+}
+
+func (s *AuthService) newTokenPair(userId int, session, refreshToken string) (model.TokenPair, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	now := time.Now()
+	accessToken, err := s.keys.Sign(&tokenClaims{
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(tokenTTL).Unix(),
-			IssuedAt:  time.Now().Unix(),
+			Id:        id,
+			ExpiresAt: now.Add(s.ttl.AccessTTL).Unix(),
+			IssuedAt:  now.Unix(),
 		},
-		UserId: user.Id,
+		UserId:  userId,
+		Session: session,
 	})
-	return token.SignedString([]byte(signInKey))
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	return model.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(s.ttl.AccessTTL.Seconds()),
+	}, nil
 }
 
-func (s *AuthService) ParseToken(accessToken string) (int, error) {
-	token, err := jwt.ParseWithClaims(accessToken, &tokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("invalid signing method")
-		}
-		return []byte(signInKey), nil
-	})
+// Возвращает refresh токен для клиента и запись с его хешем для хранения
+func (s *AuthService) newRefreshToken() (string, model.RefreshToken, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", model.RefreshToken{}, err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, model.RefreshToken{
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(s.ttl.RefreshTTL),
+	}, nil
+}
+
+// Токены отзываются вместе с сессией, поэтому проверяется и jti, и идентификатор сессии
+func (s *AuthService) ParseToken(accessToken string) (model.AccessToken, error) {
+	token, err := jwt.ParseWithClaims(accessToken, &tokenClaims{}, s.keys.Keyfunc)
 	if err != nil {
-		return 0, err
+		return model.AccessToken{}, err
 	}
 	claims, ok := token.Claims.(*tokenClaims)
 	if !ok {
-		return 0, errors.New("token claims are not of type *tokenClaims")
+		return model.AccessToken{}, errors.New("token claims are not of type *tokenClaims")
+	}
+
+	if claims.Id == "" || claims.Session == "" {
+		return model.AccessToken{}, errors.New("token has no id or session")
+	}
+
+	denied, err := s.denylist.AnyTokenDenied([]string{tokenDenyKey(claims.Id), sessionDenyKey(claims.Session)})
+	if err != nil {
+		return model.AccessToken{}, err
+	}
+	if denied {
+		return model.AccessToken{}, ErrTokenRevoked
 	}
 
-	return claims.UserId, nil
+	return model.AccessToken{
+		Id:        claims.Id,
+		User:      claims.UserId,
+		Session:   claims.Session,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+// Публичные ключи для проверки токенов другими сервисами
+func (s *AuthService) JWKS() model.JSONWebKeySet {
+	return s.keys.JWKS()
+}
+
+func tokenDenyKey(id string) string {
+	return "jti:" + id
+}
+
+func sessionDenyKey(session string) string {
+	return "sid:" + session
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
 }
 
-func generatePasswordHash(password string) string {
-	hash := sha1.New()
-	hash.Write([]byte(password))
+// Проверяет пароль и при необходимости пересчитывает хеш с текущими параметрами
+// Ошибка пересчета не мешает входу: хеш обновится при следующей попытке
+func (s *AuthService) authenticate(userName, password string) (model.User, error) {
+	user, err := s.repo.GetUser(userName)
+	if errors.Is(err, sql.ErrNoRows) {
+		verifyPassword(password, s.dummyHash, s.passwords)
+		return model.User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return model.User{}, err
+	}
+
+	// Пользователь единого входа не может войти по паролю
+	if user.Password == "" {
+		verifyPassword(password, s.dummyHash, s.passwords)
+		return model.User{}, ErrInvalidCredentials
+	}
+
+	ok, rehash, err := verifyPassword(password, user.Password, s.passwords)
+	if err != nil {
+		return model.User{}, err
+	}
+	if !ok {
+		return model.User{}, ErrInvalidCredentials
+	}
+
+	if rehash {
+		hash, err := hashPassword(password, s.passwords)
+		if err == nil {
+			err = s.repo.UpdatePasswordHash(user.Id, user.Password, hash)
+		}
+		if err != nil {
+			logrus.Errorf("failed to rehash password of user %d: %s", user.Id, err.Error())
+		}
+	}
 
-	return fmt.Sprintf("%x", hash.Sum(nil))
+	return user, nil
 }
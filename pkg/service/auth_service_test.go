@@ -0,0 +1,268 @@
+package service
+
+import (
+	"database/sql"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Облегченные параметры, чтобы тесты не тратили время на argon2id
+var testPasswords = PasswordConfig{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+type userRepo struct {
+	repository.Authorization
+	users  map[string]model.User
+	admins map[int]bool
+}
+
+func (r *userRepo) GetUser(userName string) (model.User, error) {
+	user, ok := r.users[userName]
+	if !ok {
+		return user, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (r *userRepo) UpdatePasswordHash(userId int, oldHash, newHash string) error {
+	for name, user := range r.users {
+		if user.Id == userId && user.Password == oldHash {
+			user.Password = newHash
+			r.users[name] = user
+		}
+	}
+	return nil
+}
+
+func (r *userRepo) IsAdmin(userId int) (bool, error) {
+	return r.admins[userId], nil
+}
+
+func TestPasswordHash_RoundTrip(t *testing.T) {
+	hash, err := hashPassword("secret", testPasswords)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	other, err := hashPassword("secret", testPasswords)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "salt must differ")
+
+	ok, rehash, err := verifyPassword("secret", hash, testPasswords)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _, err = verifyPassword("wrong", hash, testPasswords)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	stronger := testPasswords
+	stronger.Iterations = 2
+	ok, rehash, _ = verifyPassword("secret", hash, stronger)
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	_, _, err = verifyPassword("secret", "$argon2id$v=19$broken", testPasswords)
+	assert.Error(t, err)
+}
+
+func TestAuthenticate_UpgradesLegacyHash(t *testing.T) {
+	repo := &userRepo{users: map[string]model.User{
+		// SHA-1 от "secret"
+		"alice": {Id: 1, Username: "alice", Password: "e5e9fa1ba31ecd1ae84f75caaa474f3a663f05f4"},
+	}}
+	s := NewAuthService(repo, nil, newTwoFactorRepo(), nil, nil, testKeySet(t), testPasswords, TokenConfig{}, TwoFactorConfig{})
+
+	_, err := s.authenticate("alice", "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	assert.Equal(t, "e5e9fa1ba31ecd1ae84f75caaa474f3a663f05f4", repo.users["alice"].Password)
+
+	_, err = s.authenticate("bob", "secret")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	user, err := s.authenticate("alice", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, 1, user.Id)
+	assert.True(t, strings.HasPrefix(repo.users["alice"].Password, "$argon2id$"))
+
+	upgraded := repo.users["alice"].Password
+	_, err = s.authenticate("alice", "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, upgraded, repo.users["alice"].Password)
+}
+
+func TestAuthenticate_RejectsPasswordlessUser(t *testing.T) {
+	// Пользователь создан через единый вход и пароля не имеет
+	repo := &userRepo{users: map[string]model.User{"alice": {Id: 1, Username: "alice"}}}
+	s := NewAuthService(repo, nil, newTwoFactorRepo(), nil, nil, testKeySet(t), testPasswords, TokenConfig{}, TwoFactorConfig{})
+
+	for _, password := range []string{"", "secret"} {
+		_, err := s.authenticate("alice", password)
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+	}
+}
+
+// Хранилище refresh токенов в памяти с той же семантикой ротации, что и TokenPostgres
+type tokenRepo struct {
+	repository.Token
+	tokens map[string]model.RefreshToken
+}
+
+func (r *tokenRepo) CreateRefreshToken(token model.RefreshToken) error {
+	r.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (r *tokenRepo) RotateRefreshToken(tokenHash string, next model.RefreshToken) (model.RefreshToken, error) {
+	current, ok := r.tokens[tokenHash]
+	switch {
+	case !ok:
+		return current, sql.ErrNoRows
+	case current.RevokedAt != nil:
+		return current, repository.ErrRefreshTokenInactive
+	case current.UsedAt != nil:
+		r.RevokeSession(current.User, current.Session)
+		return current, repository.ErrRefreshTokenReused
+	}
+
+	now := time.Now()
+	current.UsedAt = &now
+	r.tokens[tokenHash] = current
+
+	next.User, next.Session = current.User, current.Session
+	r.tokens[next.TokenHash] = next
+	return current, nil
+}
+
+func (r *tokenRepo) RevokeSession(userId int, sessionId string) error {
+	now := time.Now()
+	for hash, token := range r.tokens {
+		if token.Session == sessionId && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			r.tokens[hash] = token
+		}
+	}
+	return nil
+}
+
+func (r *tokenRepo) RevokeUserSessions(userId int) ([]string, error) {
+	now := time.Now()
+	var sessions []string
+	for hash, token := range r.tokens {
+		if token.User == userId && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			r.tokens[hash] = token
+			sessions = append(sessions, token.Session)
+		}
+	}
+	return sessions, nil
+}
+
+type denylist struct {
+	denied    map[string]bool
+	published []string
+}
+
+func (d *denylist) DenyTokens(ids []string, ttl time.Duration) error {
+	for _, id := range ids {
+		d.denied[id] = true
+	}
+	return nil
+}
+
+func (d *denylist) AnyTokenDenied(ids []string) (bool, error) {
+	for _, id := range ids {
+		if d.denied[id] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d *denylist) PublishRevokedSessions(sessionIds []string) error {
+	d.published = append(d.published, sessionIds...)
+	return nil
+}
+
+func newTestAuthService(t *testing.T) (*AuthService, *denylist) {
+	hash, err := hashPassword("secret", testPasswords)
+	require.NoError(t, err)
+
+	users := &userRepo{users: map[string]model.User{"alice": {Id: 1, Username: "alice", Password: hash}}}
+	denied := &denylist{denied: make(map[string]bool)}
+	tokens := &tokenRepo{tokens: make(map[string]model.RefreshToken)}
+
+	return NewAuthService(users, tokens, newTwoFactorRepo(), denied, stateStore{}, testKeySet(t), testPasswords, TokenConfig{}, TwoFactorConfig{}), denied
+}
+
+func TestRefreshToken_Rotation(t *testing.T) {
+	s, _ := newTestAuthService(t)
+
+	first, err := s.GenerateToken("alice", "secret")
+	require.NoError(t, err)
+
+	access, err := s.ParseToken(first.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, 1, access.User)
+	assert.NotEmpty(t, access.Id)
+
+	second, err := s.RefreshToken(first.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	refreshed, err := s.ParseToken(second.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, access.Session, refreshed.Session)
+	assert.NotEqual(t, access.Id, refreshed.Id)
+
+	_, err = s.RefreshToken("unknown")
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+}
+
+func TestRefreshToken_ReuseRevokesSession(t *testing.T) {
+	s, denied := newTestAuthService(t)
+
+	first, err := s.GenerateToken("alice", "secret")
+	require.NoError(t, err)
+	other, err := s.GenerateToken("alice", "secret")
+	require.NoError(t, err)
+
+	second, err := s.RefreshToken(first.RefreshToken)
+	require.NoError(t, err)
+
+	// Украденный первый токен предъявлен повторно
+	_, err = s.RefreshToken(first.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+	_, err = s.RefreshToken(second.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+	_, err = s.ParseToken(second.AccessToken)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+	assert.Len(t, denied.published, 1)
+
+	_, err = s.ParseToken(other.AccessToken)
+	assert.NoError(t, err, "other sessions stay valid")
+}
+
+func TestLogout(t *testing.T) {
+	s, denied := newTestAuthService(t)
+
+	pair, err := s.GenerateToken("alice", "secret")
+	require.NoError(t, err)
+	access, err := s.ParseToken(pair.AccessToken)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Logout(access))
+
+	_, err = s.ParseToken(pair.AccessToken)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+
+	_, err = s.RefreshToken(pair.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	assert.Equal(t, []string{access.Session}, denied.published)
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"time"
+)
+
+// Длина кода приглашения в байтах до кодирования в hex
+const inviteCodeBytes = 8
+
+var ErrInvalidInvite = errors.New("invite is invalid or expired")
+
+type InviteService struct {
+	repo  repository.Invite
+	rooms repository.Room
+}
+
+func NewInviteService(repo repository.Invite, rooms repository.Room) *InviteService {
+	return &InviteService{repo: repo, rooms: rooms}
+}
+
+func (s *InviteService) CreateInvite(roomId, userId int, input model.CreateInviteInput) (model.RoomInvite, error) {
+	if _, err := authorize(s.rooms, roomId, userId, model.PermManageInvites); err != nil {
+		return model.RoomInvite{}, err
+	}
+
+	buf := make([]byte, inviteCodeBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return model.RoomInvite{}, err
+	}
+
+	invite := model.RoomInvite{
+		Room:      roomId,
+		Code:      hex.EncodeToString(buf),
+		CreatedBy: userId,
+		MaxUses:   input.MaxUses,
+	}
+
+	if input.ExpiresIn != nil {
+		expiresAt := time.Now().Add(time.Duration(*input.ExpiresIn) * time.Second)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	return s.repo.CreateInvite(invite)
+}
+
+func (s *InviteService) GetRoomInvites(roomId, userId int) ([]model.RoomInvite, error) {
+	if _, err := authorize(s.rooms, roomId, userId, model.PermManageInvites); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetRoomInvites(roomId)
+}
+
+func (s *InviteService) RevokeInvite(roomId, userId int, code string) error {
+	if _, err := authorize(s.rooms, roomId, userId, model.PermManageInvites); err != nil {
+		return err
+	}
+
+	return s.repo.RevokeInvite(roomId, code)
+}
+
+// Показывает комнату по действующему коду, даже если она скрыта из поиска
+func (s *InviteService) PreviewInvite(code string) (model.InvitePreview, error) {
+	invite, err := s.repo.GetInviteByCode(code)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.InvitePreview{}, ErrInvalidInvite
+		}
+		return model.InvitePreview{}, err
+	}
+
+	if !invite.Active(time.Now()) {
+		return model.InvitePreview{}, ErrInvalidInvite
+	}
+
+	room, err := s.rooms.GetRoomById(invite.Room)
+	if err != nil {
+		return model.InvitePreview{}, err
+	}
+
+	members, err := s.rooms.CountMembers(invite.Room)
+	if err != nil {
+		return model.InvitePreview{}, err
+	}
+
+	return model.InvitePreview{
+		Code:        invite.Code,
+		Room:        room.Id,
+		Name:        room.Name,
+		Description: room.Description,
+		Members:     members,
+		ExpiresAt:   invite.ExpiresAt,
+	}, nil
+}
+
+// Возвращает id комнаты, в которую вступил пользователь
+func (s *InviteService) AcceptInvite(code string, userId int) (int, error) {
+	invite, _, err := s.repo.AcceptInvite(code, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, repository.ErrInviteInactive) {
+			return 0, ErrInvalidInvite
+		}
+		if errors.Is(err, repository.ErrMemberBanned) {
+			return 0, ErrUserBanned
+		}
+		return 0, err
+	}
+
+	return invite.Room, nil
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"database/sql"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type inviteRepo struct {
+	repository.Invite
+	invites map[string]model.RoomInvite
+	banned  map[int]bool
+}
+
+func (r *inviteRepo) CreateInvite(invite model.RoomInvite) (model.RoomInvite, error) {
+	r.invites[invite.Code] = invite
+	return invite, nil
+}
+
+func (r *inviteRepo) GetInviteByCode(code string) (model.RoomInvite, error) {
+	invite, ok := r.invites[code]
+	if !ok {
+		return model.RoomInvite{}, sql.ErrNoRows
+	}
+	return invite, nil
+}
+
+func (r *inviteRepo) AcceptInvite(code string, userId int) (model.RoomInvite, bool, error) {
+	invite, ok := r.invites[code]
+	if !ok {
+		return invite, false, sql.ErrNoRows
+	}
+	if !invite.Active(time.Now()) {
+		return invite, false, repository.ErrInviteInactive
+	}
+	if r.banned[userId] {
+		return invite, false, repository.ErrMemberBanned
+	}
+	invite.Uses++
+	r.invites[code] = invite
+	return invite, true, nil
+}
+
+func TestCreateInvite_OwnerOnly(t *testing.T) {
+	members := newMemberRepo(map[int]string{1: model.RoleOwner, 2: model.RoleModerator})
+	s := NewInviteService(&inviteRepo{invites: make(map[string]model.RoomInvite)}, members)
+
+	_, err := s.CreateInvite(1, 2, model.CreateInviteInput{})
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	expiresIn := 60
+	invite, err := s.CreateInvite(1, 1, model.CreateInviteInput{ExpiresIn: &expiresIn})
+	assert.NoError(t, err)
+	assert.Len(t, invite.Code, inviteCodeBytes*2)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *invite.ExpiresAt, time.Second)
+}
+
+func TestAcceptInvite_Limits(t *testing.T) {
+	maxUses := 1
+	past := time.Now().Add(-time.Minute)
+	repo := &inviteRepo{invites: map[string]model.RoomInvite{
+		"single":  {Room: 1, Code: "single", MaxUses: &maxUses},
+		"expired": {Room: 1, Code: "expired", ExpiresAt: &past},
+	}, banned: map[int]bool{3: true}}
+	s := NewInviteService(repo, newMemberRepo(map[int]string{1: model.RoleOwner}))
+
+	_, err := s.AcceptInvite("single", 3)
+	assert.ErrorIs(t, err, ErrUserBanned)
+	assert.Equal(t, 0, repo.invites["single"].Uses, "banned user does not spend a use")
+
+	roomId, err := s.AcceptInvite("single", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, roomId)
+
+	_, err = s.AcceptInvite("single", 4)
+	assert.ErrorIs(t, err, ErrInvalidInvite, "max uses reached")
+
+	_, err = s.AcceptInvite("expired", 2)
+	assert.ErrorIs(t, err, ErrInvalidInvite)
+
+	_, err = s.AcceptInvite("missing", 2)
+	assert.ErrorIs(t, err, ErrInvalidInvite)
+}
@@ -1,61 +1,508 @@
 package service
 
 import (
+	"database/sql"
+	"errors"
 	"github.com/firstproject/talk-together-app/model"
-	"github.com/firstproject/talk-together-app/pkg/kafka"
-	"github.com/firstproject/talk-together-app/pkg/middleware/monitoring"
 	"github.com/firstproject/talk-together-app/pkg/repository"
-	"time"
+	"github.com/sirupsen/logrus"
+	"html"
+	"strings"
 )
 
+const (
+	// Количество последних сообщений комнаты, хранимых в кеше
+	recentMessagesCached = 100
+	maxSearchQueryLength = 256
+)
+
+var (
+	ErrInvalidParent  = errors.New("replies can be posted only to top-level messages of the same room")
+	ErrInvalidEmoji   = errors.New("invalid reaction emoji")
+	ErrMessageDeleted = errors.New("message has been deleted")
+	ErrInvalidSearch  = errors.New("search query must be 1 to 256 characters and from must precede to")
+)
+
+// Кеш последних сообщений комнаты, реализуется redis.Client
+type messageCache interface {
+	RoomMessagesVersion(roomId int) (int64, error)
+	CacheRoomMessages(roomId int, version int64, messages []model.Message) error
+	GetCachedRoomMessages(roomId int) ([]model.Message, bool, error)
+	InvalidateRoomMessages(roomId int) error
+}
+
+// Получает уведомления об изменениях истории для поддержки счетчиков непрочитанных
+type unreadTracker interface {
+	MessageCreated(roomId, authorId int)
+	MessageDeleted(roomId int)
+}
+
+// Источник вложений сообщений, реализуется AttachmentService
+type attachmentSource interface {
+	GetMessageAttachments(messageIds []int) (map[int][]model.Attachment, error)
+	SignAttachments(attachments []model.Attachment)
+}
+
 type MessageService struct {
-	repo  repository.Message
-	kafka *kafka.Producer
+	repo        repository.Message
+	members     memberLookup
+	cache       messageCache
+	unread      unreadTracker
+	attachments attachmentSource
 }
 
-func NewMessageService(repo repository.Message, kafkaProducer *kafka.Producer) *MessageService {
-	service := &MessageService{
-		repo:  repo,
-		kafka: kafkaProducer,
+func NewMessageService(repo repository.Message, members memberLookup, cache messageCache, unread unreadTracker, attachments attachmentSource) *MessageService {
+	return &MessageService{repo: repo, members: members, cache: cache, unread: unread, attachments: attachments}
+}
+
+// Сообщение и событие для Kafka сохраняются в одной транзакции,
+// публикацию выполняет OutboxRelay
+// Вложения должны быть загружены автором в эту же комнату и еще не привязаны к сообщениям
+func (s *MessageService) CreateMessage(roomId, userId int, content string, attachmentIds []int) (int, error) {
+	if _, err := authorize(s.members, roomId, userId, model.PermSendMessage); err != nil {
+		return 0, err
 	}
 
-	return service
+	id, err := s.repo.CreateMessage(roomId, userId, content, attachmentIds)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidAttachments) {
+			return 0, ErrInvalidAttachments
+		}
+		return 0, err
+	}
+
+	s.invalidateRoom(roomId)
+	if s.unread != nil {
+		s.unread.MessageCreated(roomId, userId)
+	}
+	return id, nil
 }
 
-func (s *MessageService) CreateMessage(roomId, userId int, content string) (int, error) {
-	id, err := s.repo.CreateMessage(roomId, userId, content)
+// Ответ не попадает в основную ленту, но меняет счетчик ответов родителя в кеше ленты.
+// Счетчики непрочитанных ответ не меняет, они считаются только по основной ленте
+func (s *MessageService) CreateReply(roomId, parentId, userId int, content string, attachmentIds []int) (int, error) {
+	if _, err := authorize(s.members, roomId, userId, model.PermSendMessage); err != nil {
+		return 0, err
+	}
+
+	id, err := s.repo.CreateReply(roomId, parentId, userId, content, attachmentIds)
 	if err != nil {
+		if errors.Is(err, repository.ErrInvalidParent) {
+			return 0, ErrInvalidParent
+		}
+		if errors.Is(err, repository.ErrInvalidAttachments) {
+			return 0, ErrInvalidAttachments
+		}
 		return 0, err
 	}
 
-	message := model.Message{
-		Id:        id,
-		Room:      roomId,
-		User:      userId,
-		Content:   content,
-		CreatedAt: time.Now(),
+	s.invalidateRoom(roomId)
+	return id, nil
+}
+
+func (s *MessageService) GetRoomMessages(roomId int) ([]model.Message, error) {
+	messages, err := s.repo.GetRoomMessages(roomId)
+	if err != nil {
+		return nil, err
 	}
 
-	if s.kafka != nil {
-		err := s.kafka.SendMessage("messages", message)
-		if err != nil {
-			monitoring.IncrementKafkaMessagesSent("messages_error")
-			return 0, nil
+	if err := s.attachDetails(messages); err != nil {
+		return nil, err
+	}
+
+	s.signAttachments(messages)
+	return messages, nil
+}
+
+// Дополняет сообщения счетчиками реакций и вложениями, по одному запросу на всю выборку
+func (s *MessageService) attachDetails(messages []model.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]int, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.Id)
+	}
+
+	counts, err := s.repo.GetReactionCounts(ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Reactions = counts[messages[i].Id]
+	}
+
+	if s.attachments == nil {
+		return nil
+	}
+
+	attachments, err := s.attachments.GetMessageAttachments(ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Attachments = attachments[messages[i].Id]
+	}
+
+	return nil
+}
+
+// Ссылки на файлы живут ограниченное время, поэтому подписываются при каждой выдаче,
+// в том числе для сообщений из кеша
+func (s *MessageService) signAttachments(messages []model.Message) {
+	if s.attachments == nil {
+		return
+	}
+
+	for i := range messages {
+		s.attachments.SignAttachments(messages[i].Attachments)
+	}
+}
+
+// Запрашивает на одно сообщение больше лимита, чтобы понять, есть ли следующая страница
+// Последняя страница комнаты без курсоров отдается из кеша
+func (s *MessageService) GetRoomMessagesPage(roomId int, page model.MessagePageQuery) (model.MessagePage, error) {
+	limit := page.Limit
+	page.Limit = limit + 1
+
+	var messages []model.Message
+	var err error
+	if page.Before == 0 && page.After == 0 && limit <= recentMessagesCached {
+		messages, err = s.getRecentMessages(roomId)
+		if len(messages) > page.Limit {
+			messages = messages[len(messages)-page.Limit:]
+		}
+	} else {
+		messages, err = s.repo.GetRoomMessagesPage(roomId, page)
+		if err == nil {
+			err = s.attachDetails(messages)
 		}
-		monitoring.IncrementKafkaMessagesSent("messages_success")
 	}
+	if err != nil {
+		return model.MessagePage{}, err
+	}
+
+	s.signAttachments(messages)
 
-	return message.Id, nil
+	return buildMessagePage(messages, limit, page), nil
 }
 
-func (s *MessageService) GetRoomMessages(roomId int) ([]model.Message, error) {
-	return s.repo.GetRoomMessages(roomId)
+// Ответы ветки не кешируются: ветки читают реже основной ленты
+// Ответы загружаются только после проверки членства в комнате родительского сообщения
+func (s *MessageService) GetThreadPage(parentId, userId int, page model.MessagePageQuery) (model.ThreadPage, error) {
+	parent, err := s.repo.GetMessageById(parentId)
+	if err != nil {
+		return model.ThreadPage{}, err
+	}
+
+	if err := requireMember(s.members, parent.Room, userId); err != nil {
+		return model.ThreadPage{}, err
+	}
+
+	limit := page.Limit
+	page.Limit = limit + 1
+
+	replies, err := s.repo.GetThreadMessagesPage(parentId, page)
+	if err != nil {
+		return model.ThreadPage{}, err
+	}
+
+	withParent := append([]model.Message{parent}, replies...)
+	if err := s.attachDetails(withParent); err != nil {
+		return model.ThreadPage{}, err
+	}
+	s.signAttachments(withParent)
+	parent, replies = withParent[0], withParent[1:]
+
+	return model.ThreadPage{
+		Parent:      parent,
+		MessagePage: buildMessagePage(replies, limit, page),
+	}, nil
 }
 
+// Собирает страницу из выборки размером до limit+1 и вычисляет курсоры
+func buildMessagePage(messages []model.Message, limit int, page model.MessagePageQuery) model.MessagePage {
+	hasMore := len(messages) > limit
+	if hasMore {
+		// Лишнее сообщение находится на дальнем от курсора конце страницы
+		if page.After > 0 {
+			messages = messages[:limit]
+		} else {
+			messages = messages[1:]
+		}
+	}
+
+	result := model.MessagePage{Data: messages}
+	if len(messages) == 0 {
+		return result
+	}
+
+	oldest, newest := messages[0].Id, messages[len(messages)-1].Id
+
+	switch {
+	case page.After > 0:
+		result.PrevCursor = &oldest
+		if hasMore {
+			result.NextCursor = &newest
+		}
+	case page.Before > 0:
+		result.NextCursor = &newest
+		if hasMore {
+			result.PrevCursor = &oldest
+		}
+	default:
+		if hasMore {
+			result.PrevCursor = &oldest
+		}
+	}
+
+	return result
+}
+
+// Возвращает последние сообщения комнаты из кеша, при промахе загружает их из БД
+// Кешируются сообщения вместе с реакциями и вложениями, поэтому изменение реакций сбрасывает кеш
+// Ошибки кеша не прерывают запрос - данные берутся из БД
+// Поколение читается до загрузки: если запись сбросила кеш во время загрузки, устаревшие данные не будут отданы
+func (s *MessageService) getRecentMessages(roomId int) ([]model.Message, error) {
+	var version int64
+	cacheable := false
+	if s.cache != nil {
+		messages, ok, err := s.cache.GetCachedRoomMessages(roomId)
+		if err == nil && ok {
+			return messages, nil
+		}
+
+		version, err = s.cache.RoomMessagesVersion(roomId)
+		cacheable = err == nil
+	}
+
+	// Лишнее сообщение нужно, чтобы и для полной страницы понять, есть ли более старые
+	messages, err := s.repo.GetRoomMessagesPage(roomId, model.MessagePageQuery{Limit: recentMessagesCached + 1})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.attachDetails(messages); err != nil {
+		return nil, err
+	}
+
+	if cacheable {
+		if err := s.cache.CacheRoomMessages(roomId, version, messages); err != nil {
+			logrus.Errorf("failed to cache messages of room %d: %s", roomId, err.Error())
+		}
+	}
+
+	return messages, nil
+}
+
+func (s *MessageService) invalidateRoom(roomId int) {
+	if s.cache == nil {
+		return
+	}
+
+	if err := s.cache.InvalidateRoomMessages(roomId); err != nil {
+		logrus.Errorf("failed to invalidate messages cache of room %d: %s", roomId, err.Error())
+	}
+}
+
+// Автор удаляет свое сообщение, чужие - только участник с правом модерации и более высокой ролью
 func (s *MessageService) DeleteMessage(messageId, userId int) error {
-	return s.repo.DeleteMessage(messageId, userId)
+	message, err := s.repo.GetMessageById(messageId)
+	if err != nil {
+		return err
+	}
+
+	if message.User != userId {
+		actor, err := authorize(s.members, message.Room, userId, model.PermDeleteAnyMessage)
+		if err != nil {
+			return err
+		}
+
+		// Сообщения старших по роли удалить нельзя, покинувший комнату автор ролью не защищен
+		author, err := s.members.GetMember(message.Room, message.User)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err == nil && !model.RoleOutranks(actor.Role, author.Role) {
+			return ErrForbidden
+		}
+	}
+
+	if err := s.repo.DeleteMessage(messageId, userId); err != nil {
+		return err
+	}
+
+	s.invalidateRoom(message.Room)
+	if s.unread != nil {
+		s.unread.MessageDeleted(message.Room)
+	}
+	return nil
 }
 
+// Править свои сообщения может только автор, у которого осталось право писать в комнату
 func (s *MessageService) UpdateMessage(messageId, userId int, content string) error {
-	return s.repo.UpdateMessage(messageId, userId, content)
+	message, err := s.repo.GetMessageById(messageId)
+	if err != nil {
+		return err
+	}
+
+	if _, err := authorize(s.members, message.Room, userId, model.PermSendMessage); err != nil {
+		return err
+	}
+
+	if err := s.repo.UpdateMessage(messageId, userId, content); err != nil {
+		return err
+	}
+
+	s.invalidateRoom(message.Room)
+	return nil
+}
+
+func (s *MessageService) GetMessageById(messageId int) (model.Message, error) {
+	message, err := s.repo.GetMessageById(messageId)
+	if err != nil {
+		return message, err
+	}
+
+	messages := []model.Message{message}
+	if err := s.attachDetails(messages); err != nil {
+		return message, err
+	}
+
+	s.signAttachments(messages)
+	return messages[0], nil
+}
+
+func (s *MessageService) PinMessage(messageId, userId int) error {
+	message, err := s.repo.GetMessageById(messageId)
+	if err != nil {
+		return err
+	}
+
+	if message.Deleted() {
+		return ErrMessageDeleted
+	}
+
+	if _, err := authorize(s.members, message.Room, userId, model.PermPin); err != nil {
+		return err
+	}
+
+	_, err = s.repo.PinMessage(message.Room, messageId, userId)
+	return err
+}
+
+func (s *MessageService) UnpinMessage(messageId, userId int) error {
+	message, err := s.repo.GetMessageById(messageId)
+	if err != nil {
+		return err
+	}
+
+	if _, err := authorize(s.members, message.Room, userId, model.PermPin); err != nil {
+		return err
+	}
+
+	return s.repo.UnpinMessage(message.Room, messageId)
+}
+
+// История правок доступна только модераторам комнаты
+func (s *MessageService) GetMessageRevisions(messageId, userId int) ([]model.MessageRevision, error) {
+	message, err := s.repo.GetMessageById(messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := authorize(s.members, message.Room, userId, model.PermViewRevisions); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetMessageRevisions(messageId)
+}
+
+func (s *MessageService) GetPinnedMessages(roomId int) ([]model.PinnedMessage, error) {
+	return s.repo.GetPinnedMessages(roomId)
+}
+
+func (s *MessageService) AddReaction(messageId, userId int, emoji string) error {
+	return s.changeReaction(messageId, userId, emoji, s.repo.AddReaction)
+}
+
+func (s *MessageService) RemoveReaction(messageId, userId int, emoji string) error {
+	return s.changeReaction(messageId, userId, emoji, s.repo.RemoveReaction)
+}
+
+// Повторная постановка или снятие реакции не считается ошибкой
+func (s *MessageService) changeReaction(messageId, userId int, emoji string, change func(messageId, userId int, emoji string) (bool, error)) error {
+	if !model.ValidEmoji(emoji) {
+		return ErrInvalidEmoji
+	}
+
+	message, err := s.repo.GetMessageById(messageId)
+	if err != nil {
+		return err
+	}
+
+	if _, err := authorize(s.members, message.Room, userId, model.PermReact); err != nil {
+		return err
+	}
+
+	changed, err := change(messageId, userId, emoji)
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		s.invalidateRoom(message.Room)
+	}
+	return nil
+}
+
+// Поиск всегда ограничен комнатами пользователя, фильтр по чужой комнате возвращает ошибку, а не пустой ответ
+func (s *MessageService) SearchMessages(userId int, search model.MessageSearchQuery) (model.MessageSearchPage, error) {
+	search.Query = strings.TrimSpace(search.Query)
+	if search.Query == "" || len(search.Query) > maxSearchQueryLength {
+		return model.MessageSearchPage{}, ErrInvalidSearch
+	}
+
+	if search.From != nil && search.To != nil && !search.From.Before(*search.To) {
+		return model.MessageSearchPage{}, ErrInvalidSearch
+	}
+
+	if search.Room > 0 {
+		if err := requireMember(s.members, search.Room, userId); err != nil {
+			return model.MessageSearchPage{}, err
+		}
+	}
+
+	limit := search.Limit
+	search.Limit = limit + 1
+
+	results, err := s.repo.SearchMessages(userId, search)
+	if err != nil {
+		return model.MessageSearchPage{}, err
+	}
+
+	page := model.MessageSearchPage{Data: results}
+	if len(results) > limit {
+		page.Data = results[:limit]
+		next := search.Offset + limit
+		page.NextOffset = &next
+	}
+
+	for i := range page.Data {
+		page.Data[i].Headline = escapeHeadline(page.Data[i].Headline)
+	}
+
+	return page, nil
+}
+
+// Экранирует весь текст фрагмента, затем заменяет границы подсветки, расставленные ts_headline, тегами
+// Теги, написанные в самом сообщении, остаются экранированными
+func escapeHeadline(headline string) string {
+	return strings.NewReplacer(
+		repository.HeadlineStart, "<mark>",
+		repository.HeadlineStop, "</mark>",
+	).Replace(html.EscapeString(headline))
 }
@@ -0,0 +1,454 @@
+package service
+
+import (
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Репозиторий в памяти: сообщения комнаты 1 с id от 1 до total
+type pageRepo struct {
+	repository.Message
+	total     int
+	reactions map[int][]model.ReactionCount
+}
+
+func (r *pageRepo) GetRoomMessagesPage(roomId int, page model.MessagePageQuery) ([]model.Message, error) {
+	var messages []model.Message
+
+	switch {
+	case page.After > 0:
+		for id := page.After + 1; id <= r.total && len(messages) < page.Limit; id++ {
+			messages = append(messages, model.Message{Id: id, Room: roomId})
+		}
+	default:
+		upper := r.total
+		if page.Before > 0 {
+			upper = page.Before - 1
+		}
+		for id := upper; id >= 1 && len(messages) < page.Limit; id-- {
+			messages = append([]model.Message{{Id: id, Room: roomId}}, messages...)
+		}
+	}
+
+	return messages, nil
+}
+
+func (r *pageRepo) GetReactionCounts(messageIds []int) (map[int][]model.ReactionCount, error) {
+	return r.reactions, nil
+}
+
+func messageIds(messages []model.Message) []int {
+	ids := make([]int, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.Id)
+	}
+	return ids
+}
+
+func TestGetRoomMessagesPage_Latest(t *testing.T) {
+	s := NewMessageService(&pageRepo{total: 5}, nil, nil, nil, nil)
+
+	page, err := s.GetRoomMessagesPage(1, model.MessagePageQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 5}, messageIds(page.Data))
+	assert.Nil(t, page.NextCursor)
+	assert.Equal(t, 4, *page.PrevCursor)
+}
+
+func TestGetRoomMessagesPage_Before(t *testing.T) {
+	s := NewMessageService(&pageRepo{total: 5}, nil, nil, nil, nil)
+
+	page, err := s.GetRoomMessagesPage(1, model.MessagePageQuery{Before: 4, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, messageIds(page.Data))
+	assert.Equal(t, 3, *page.NextCursor)
+	assert.Equal(t, 2, *page.PrevCursor)
+
+	page, err = s.GetRoomMessagesPage(1, model.MessagePageQuery{Before: 2, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, messageIds(page.Data))
+	assert.Nil(t, page.PrevCursor)
+}
+
+func TestGetRoomMessagesPage_After(t *testing.T) {
+	s := NewMessageService(&pageRepo{total: 5}, nil, nil, nil, nil)
+
+	page, err := s.GetRoomMessagesPage(1, model.MessagePageQuery{After: 1, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, messageIds(page.Data))
+	assert.Equal(t, 2, *page.PrevCursor)
+	assert.Equal(t, 3, *page.NextCursor)
+
+	page, err = s.GetRoomMessagesPage(1, model.MessagePageQuery{After: 3, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 5}, messageIds(page.Data))
+	assert.Nil(t, page.NextCursor)
+}
+
+type fakeCache struct {
+	rooms    map[int][]model.Message
+	versions map[int]int64
+	cached   map[int]int64
+	hits     int
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{rooms: make(map[int][]model.Message), versions: make(map[int]int64), cached: make(map[int]int64)}
+}
+
+func (c *fakeCache) RoomMessagesVersion(roomId int) (int64, error) {
+	return c.versions[roomId], nil
+}
+
+func (c *fakeCache) CacheRoomMessages(roomId int, version int64, messages []model.Message) error {
+	c.rooms[roomId], c.cached[roomId] = messages, version
+	return nil
+}
+
+func (c *fakeCache) GetCachedRoomMessages(roomId int) ([]model.Message, bool, error) {
+	messages, ok := c.rooms[roomId]
+	if !ok || c.cached[roomId] != c.versions[roomId] {
+		return nil, false, nil
+	}
+	c.hits++
+	return messages, true, nil
+}
+
+func (c *fakeCache) InvalidateRoomMessages(roomId int) error {
+	c.versions[roomId]++
+	delete(c.rooms, roomId)
+	return nil
+}
+
+type countingRepo struct {
+	pageRepo
+	pageCalls int
+}
+
+func (r *countingRepo) GetRoomMessagesPage(roomId int, page model.MessagePageQuery) ([]model.Message, error) {
+	r.pageCalls++
+	return r.pageRepo.GetRoomMessagesPage(roomId, page)
+}
+
+func (r *countingRepo) CreateMessage(roomId, userId int, content string, attachmentIds []int) (int, error) {
+	r.total++
+	return r.total, nil
+}
+
+func TestGetRoomMessagesPage_Cache(t *testing.T) {
+	repo := &countingRepo{pageRepo: pageRepo{total: 5}}
+	cache := newFakeCache()
+	members := newMemberRepo(map[int]string{1: model.RoleMember})
+	s := NewMessageService(repo, members, cache, nil, nil)
+
+	page, err := s.GetRoomMessagesPage(1, model.MessagePageQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 5}, messageIds(page.Data))
+	assert.Equal(t, 1, repo.pageCalls)
+
+	page, err = s.GetRoomMessagesPage(1, model.MessagePageQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 5}, messageIds(page.Data))
+	assert.Equal(t, 4, *page.PrevCursor)
+	assert.Equal(t, 1, repo.pageCalls)
+	assert.Equal(t, 1, cache.hits)
+
+	_, err = s.CreateMessage(1, 1, "new", nil)
+	assert.NoError(t, err)
+
+	page, err = s.GetRoomMessagesPage(1, model.MessagePageQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{5, 6}, messageIds(page.Data))
+	assert.Equal(t, 2, repo.pageCalls)
+}
+
+func TestGetRoomMessagesPage_CacheServesFullPage(t *testing.T) {
+	repo := &countingRepo{pageRepo: pageRepo{total: 150}}
+	s := NewMessageService(repo, newMemberRepo(map[int]string{1: model.RoleMember}), newFakeCache(), nil, nil)
+
+	for i := 0; i < 2; i++ {
+		page, err := s.GetRoomMessagesPage(1, model.MessagePageQuery{Limit: recentMessagesCached})
+		assert.NoError(t, err)
+		assert.Len(t, page.Data, recentMessagesCached)
+		assert.Equal(t, 51, *page.PrevCursor)
+	}
+	assert.Equal(t, 1, repo.pageCalls)
+}
+
+// Запись, сбросившая кеш во время загрузки из БД, не дает закешировать устаревшую страницу
+type racingRepo struct {
+	countingRepo
+	cache *fakeCache
+}
+
+func (r *racingRepo) GetRoomMessagesPage(roomId int, page model.MessagePageQuery) ([]model.Message, error) {
+	messages, err := r.countingRepo.GetRoomMessagesPage(roomId, page)
+	if r.pageCalls == 1 {
+		r.total++
+		r.cache.InvalidateRoomMessages(roomId)
+	}
+	return messages, err
+}
+
+func TestGetRoomMessagesPage_CacheSkipsStaleFill(t *testing.T) {
+	cache := newFakeCache()
+	repo := &racingRepo{countingRepo: countingRepo{pageRepo: pageRepo{total: 5}}, cache: cache}
+	s := NewMessageService(repo, newMemberRepo(map[int]string{1: model.RoleMember}), cache, nil, nil)
+
+	page, err := s.GetRoomMessagesPage(1, model.MessagePageQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4, 5}, messageIds(page.Data))
+
+	page, err = s.GetRoomMessagesPage(1, model.MessagePageQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{5, 6}, messageIds(page.Data))
+	assert.Equal(t, 0, cache.hits)
+	assert.Equal(t, 2, repo.pageCalls)
+}
+
+type deleteRepo struct {
+	repository.Message
+	messages map[int]model.Message
+}
+
+func (r *deleteRepo) GetMessageById(messageId int) (model.Message, error) {
+	return r.messages[messageId], nil
+}
+
+func (r *deleteRepo) DeleteMessage(messageId, deletedBy int) error {
+	delete(r.messages, messageId)
+	return nil
+}
+
+func (r *deleteRepo) UpdateMessage(messageId, userId int, content string) error {
+	message := r.messages[messageId]
+	message.Content = content
+	r.messages[messageId] = message
+	return nil
+}
+
+func TestUpdateMessage_RequiresWriteAccess(t *testing.T) {
+	repo := &deleteRepo{messages: map[int]model.Message{
+		1: {Id: 1, Room: 1, User: 1, Content: "hello"},
+		2: {Id: 2, Room: 1, User: 2, Content: "hello"},
+		3: {Id: 3, Room: 1, User: 3, Content: "hello"},
+	}}
+	members := newMemberRepo(map[int]string{1: model.RoleMember, 2: model.RoleReadOnly})
+	s := NewMessageService(repo, members, nil, nil, nil)
+
+	assert.NoError(t, s.UpdateMessage(1, 1, "edited"))
+	assert.Equal(t, "edited", repo.messages[1].Content)
+
+	assert.ErrorIs(t, s.UpdateMessage(2, 2, "edited"), ErrForbidden)
+	assert.ErrorIs(t, s.UpdateMessage(3, 3, "edited"), ErrNotRoomMember, "removed or banned author")
+	assert.Equal(t, "hello", repo.messages[2].Content)
+	assert.Equal(t, "hello", repo.messages[3].Content)
+}
+
+func TestDeleteMessage_Permissions(t *testing.T) {
+	repo := &deleteRepo{messages: map[int]model.Message{
+		1: {Id: 1, Room: 1, User: 1},
+		2: {Id: 2, Room: 1, User: 1},
+		3: {Id: 3, Room: 1, User: 4},
+		4: {Id: 4, Room: 1, User: 5},
+		5: {Id: 5, Room: 1, User: 9},
+	}}
+	members := newMemberRepo(map[int]string{
+		1: model.RoleMember, 2: model.RoleMember, 3: model.RoleModerator, 4: model.RoleModerator, 5: model.RoleOwner,
+	})
+	s := NewMessageService(repo, members, nil, nil, nil)
+
+	assert.ErrorIs(t, s.DeleteMessage(1, 2), ErrForbidden)
+	assert.NoError(t, s.DeleteMessage(1, 1), "author may delete own message")
+	assert.NoError(t, s.DeleteMessage(2, 3), "moderator may delete a member's message")
+	assert.ErrorIs(t, s.DeleteMessage(3, 3), ErrForbidden, "moderator may not delete another moderator's message")
+	assert.ErrorIs(t, s.DeleteMessage(4, 3), ErrForbidden, "moderator may not delete the owner's message")
+	assert.NoError(t, s.DeleteMessage(3, 5), "owner may delete a moderator's message")
+	assert.NoError(t, s.DeleteMessage(5, 3), "message of a user who left the room may be deleted")
+	assert.Equal(t, map[int]model.Message{4: {Id: 4, Room: 1, User: 5}}, repo.messages)
+}
+
+func TestCreateMessage_ReadOnly(t *testing.T) {
+	members := newMemberRepo(map[int]string{1: model.RoleReadOnly})
+	s := NewMessageService(&countingRepo{}, members, nil, nil, nil)
+
+	_, err := s.CreateMessage(1, 1, "hello", nil)
+	assert.ErrorIs(t, err, ErrForbidden)
+
+	_, err = s.CreateMessage(1, 2, "hello", nil)
+	assert.ErrorIs(t, err, ErrNotRoomMember)
+}
+
+type unreadRooms []int
+
+func (u *unreadRooms) MessageCreated(roomId, authorId int) {
+	*u = append(*u, roomId)
+}
+
+func (u *unreadRooms) MessageDeleted(roomId int) {}
+
+func (r *countingRepo) CreateReply(roomId, parentId, userId int, content string, attachmentIds []int) (int, error) {
+	r.total++
+	return r.total, nil
+}
+
+func TestCreateReply_KeepsUnreadCounters(t *testing.T) {
+	unread := &unreadRooms{}
+	members := newMemberRepo(map[int]string{1: model.RoleMember})
+	s := NewMessageService(&countingRepo{}, members, nil, unread, nil)
+
+	_, err := s.CreateMessage(1, 1, "hello", nil)
+	assert.NoError(t, err)
+
+	_, err = s.CreateReply(1, 1, 1, "reply", nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, unreadRooms{1}, *unread, "replies are not counted as unread")
+}
+
+type threadRepo struct {
+	pageRepo
+	threadCalls int
+}
+
+func (r *threadRepo) GetMessageById(messageId int) (model.Message, error) {
+	return model.Message{Id: messageId, Room: 1, ReplyCount: r.total}, nil
+}
+
+func (r *threadRepo) GetThreadMessagesPage(parentId int, page model.MessagePageQuery) ([]model.Message, error) {
+	r.threadCalls++
+	return r.pageRepo.GetRoomMessagesPage(1, page)
+}
+
+func TestGetThreadPage(t *testing.T) {
+	repo := &threadRepo{pageRepo: pageRepo{total: 3}}
+	s := NewMessageService(repo, newMemberRepo(map[int]string{1: model.RoleMember}), nil, nil, nil)
+
+	_, err := s.GetThreadPage(10, 2, model.MessagePageQuery{Limit: 2})
+	assert.ErrorIs(t, err, ErrNotRoomMember)
+	assert.Equal(t, 0, repo.threadCalls)
+
+	thread, err := s.GetThreadPage(10, 1, model.MessagePageQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, thread.Parent.Id)
+	assert.Equal(t, 3, thread.Parent.ReplyCount)
+	assert.Equal(t, []int{2, 3}, messageIds(thread.Data))
+	assert.Equal(t, 2, *thread.PrevCursor)
+	assert.Nil(t, thread.NextCursor)
+}
+
+type reactionRepo struct {
+	countingRepo
+	added map[string]bool
+}
+
+func (r *reactionRepo) GetMessageById(messageId int) (model.Message, error) {
+	return model.Message{Id: messageId, Room: 1}, nil
+}
+
+func (r *reactionRepo) AddReaction(messageId, userId int, emoji string) (bool, error) {
+	if r.added[emoji] {
+		return false, nil
+	}
+	r.added[emoji] = true
+	r.reactions[messageId] = append(r.reactions[messageId], model.ReactionCount{Emoji: emoji, Count: 1})
+	return true, nil
+}
+
+func TestAddReaction_RefreshesCachedPage(t *testing.T) {
+	repo := &reactionRepo{
+		countingRepo: countingRepo{pageRepo: pageRepo{total: 2, reactions: make(map[int][]model.ReactionCount)}},
+		added:        make(map[string]bool),
+	}
+	cache := newFakeCache()
+	members := newMemberRepo(map[int]string{1: model.RoleMember, 2: model.RoleReadOnly})
+	s := NewMessageService(repo, members, cache, nil, nil)
+
+	page, err := s.GetRoomMessagesPage(1, model.MessagePageQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Empty(t, page.Data[1].Reactions)
+
+	assert.ErrorIs(t, s.AddReaction(2, 1, "a b"), ErrInvalidEmoji)
+	assert.ErrorIs(t, s.AddReaction(2, 2, "👍"), ErrForbidden)
+	assert.NoError(t, s.AddReaction(2, 1, "👍"))
+	assert.NoError(t, s.AddReaction(2, 1, "👍"), "repeated reaction is a no-op")
+
+	page, err = s.GetRoomMessagesPage(1, model.MessagePageQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []model.ReactionCount{{Emoji: "👍", Count: 1}}, page.Data[1].Reactions)
+	assert.Equal(t, 2, repo.pageCalls, "reaction must invalidate the cached page")
+}
+
+func (r *deleteRepo) GetMessageRevisions(messageId int) ([]model.MessageRevision, error) {
+	return []model.MessageRevision{{MessageId: messageId, Content: "before"}}, nil
+}
+
+func TestGetMessageRevisions_ModeratorsOnly(t *testing.T) {
+	repo := &deleteRepo{messages: map[int]model.Message{1: {Id: 1, Room: 1, User: 1}}}
+	members := newMemberRepo(map[int]string{1: model.RoleMember, 2: model.RoleModerator})
+	s := NewMessageService(repo, members, nil, nil, nil)
+
+	_, err := s.GetMessageRevisions(1, 1)
+	assert.ErrorIs(t, err, ErrForbidden, "authors cannot review history")
+
+	revisions, err := s.GetMessageRevisions(1, 2)
+	assert.NoError(t, err)
+	assert.Len(t, revisions, 1)
+}
+
+type searchRepo struct {
+	repository.Message
+	total int
+	query model.MessageSearchQuery
+}
+
+func (r *searchRepo) SearchMessages(userId int, search model.MessageSearchQuery) ([]model.MessageSearchResult, error) {
+	r.query = search
+
+	var results []model.MessageSearchResult
+	for i := search.Offset; i < r.total && len(results) < search.Limit; i++ {
+		results = append(results, model.MessageSearchResult{
+			Message:  model.Message{Id: r.total - i},
+			Headline: "<b>x</b> <mark>a</mark> " + repository.HeadlineStart + "hit" + repository.HeadlineStop,
+		})
+	}
+	return results, nil
+}
+
+func TestSearchMessages_Pagination(t *testing.T) {
+	repo := &searchRepo{total: 5}
+	s := NewMessageService(repo, nil, nil, nil, nil)
+
+	page, err := s.SearchMessages(1, model.MessageSearchQuery{Query: "  hit ", Limit: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, "hit", repo.query.Query)
+	assert.Len(t, page.Data, 3)
+	assert.Equal(t, 3, *page.NextOffset)
+	assert.Equal(t, "&lt;b&gt;x&lt;/b&gt; &lt;mark&gt;a&lt;/mark&gt; <mark>hit</mark>", page.Data[0].Headline)
+
+	page, err = s.SearchMessages(1, model.MessageSearchQuery{Query: "hit", Limit: 3, Offset: 3})
+	assert.NoError(t, err)
+	assert.Len(t, page.Data, 2)
+	assert.Nil(t, page.NextOffset)
+}
+
+func TestSearchMessages_Validation(t *testing.T) {
+	members := newMemberRepo(map[int]string{1: model.RoleMember})
+	s := NewMessageService(&searchRepo{}, members, nil, nil, nil)
+
+	_, err := s.SearchMessages(1, model.MessageSearchQuery{Query: "   ", Limit: 10})
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+
+	now := time.Now()
+	_, err = s.SearchMessages(1, model.MessageSearchQuery{Query: "hit", From: &now, To: &now, Limit: 10})
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+
+	_, err = s.SearchMessages(2, model.MessageSearchQuery{Query: "hit", Room: 1, Limit: 10})
+	assert.ErrorIs(t, err, ErrNotRoomMember)
+
+	_, err = s.SearchMessages(1, model.MessageSearchQuery{Query: "hit", Room: 1, Limit: 10})
+	assert.NoError(t, err)
+}
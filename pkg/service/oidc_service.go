@@ -0,0 +1,557 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcStateTTL    = 10 * time.Minute
+	oidcStatePrefix = "oidc-state:"
+	// Ключи провайдера перечитываются при незнакомом kid, но не чаще этого интервала
+	oidcKeysRefreshInterval = time.Minute
+	oidcClockSkew           = time.Minute
+	oidcMaxResponseSize     = 1 << 20
+	maxUsernameLength       = 32
+	usernameAttempts        = 5
+)
+
+var (
+	ErrUnknownProvider  = errors.New("unknown identity provider")
+	ErrInvalidOIDCState = errors.New("invalid or expired login state")
+	ErrOIDCLoginFailed  = errors.New("identity provider login failed")
+	ErrEmailNotVerified = errors.New("identity provider did not confirm the email address")
+)
+
+var usernameDisallowed = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// Настройки провайдера единого входа
+// Адреса авторизации, обмена кода и ключей берутся из discovery документа издателя
+type OIDCProviderConfig struct {
+	Id           string
+	Name         string
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+func (c OIDCProviderConfig) Validate() error {
+	switch {
+	case c.Id == "":
+		return errors.New("provider id is required")
+	case c.Issuer == "":
+		return fmt.Errorf("provider %q: issuer is required", c.Id)
+	case c.ClientId == "":
+		return fmt.Errorf("provider %q: client id is required", c.Id)
+	case c.RedirectURL == "":
+		return fmt.Errorf("provider %q: redirect url is required", c.Id)
+	}
+	return nil
+}
+
+// Хранилище состояния входа, реализуется redis.Client
+type oidcStateStore interface {
+	Set(key string, value interface{}, expiration time.Duration) error
+	GetDel(key string) (string, error)
+}
+
+// Вход после проверки у провайдера, включая второй фактор; реализуется AuthService
+type signer interface {
+	signIn(userId int) (model.SignInResult, error)
+}
+
+// Состояние входа между переходом к провайдеру и возвратом с кодом
+type oidcLoginState struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	// Пользователь, который уже вошел и привязывает к себе внешнюю учетную запись
+	LinkUser int `json:"link_user,omitempty"`
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcProvider struct {
+	cfg OIDCProviderConfig
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+// Audience в ID токене бывает как строкой, так и массивом
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type idTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	NotBefore         int64    `json:"nbf"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+	GivenName         string   `json:"given_name"`
+	FamilyName        string   `json:"family_name"`
+}
+
+// Проверка сроков действия с допуском на расхождение часов с провайдером
+func (c *idTokenClaims) Valid() error {
+	now := jwt.TimeFunc().Unix()
+	skew := int64(oidcClockSkew.Seconds())
+
+	if c.ExpiresAt == 0 || now > c.ExpiresAt+skew {
+		return errors.New("id token is expired")
+	}
+	if c.IssuedAt > now+skew || c.NotBefore > now+skew {
+		return errors.New("id token is not valid yet")
+	}
+	return nil
+}
+
+// Вход через внешних провайдеров OpenID Connect по authorization code с PKCE
+type OIDCService struct {
+	repo      repository.Identity
+	states    oidcStateStore
+	auth      signer
+	providers map[string]*oidcProvider
+	order     []string
+	client    *http.Client
+}
+
+func NewOIDCService(repo repository.Identity, states oidcStateStore, auth signer, configs []OIDCProviderConfig) *OIDCService {
+	s := &OIDCService{
+		repo:      repo,
+		states:    states,
+		auth:      auth,
+		providers: make(map[string]*oidcProvider, len(configs)),
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+
+	for _, cfg := range configs {
+		s.providers[cfg.Id] = &oidcProvider{cfg: cfg}
+		s.order = append(s.order, cfg.Id)
+	}
+
+	return s
+}
+
+func (s *OIDCService) Providers() []model.OIDCProvider {
+	providers := make([]model.OIDCProvider, 0, len(s.order))
+	for _, id := range s.order {
+		cfg := s.providers[id].cfg
+		name := cfg.Name
+		if name == "" {
+			name = cfg.Id
+		}
+		providers = append(providers, model.OIDCProvider{Id: cfg.Id, Name: name})
+	}
+	return providers
+}
+
+// Начинает вход: запоминает state, nonce и PKCE verifier и возвращает адрес страницы провайдера
+func (s *OIDCService) StartLogin(ctx context.Context, providerId string) (string, string, error) {
+	return s.start(ctx, oidcLoginState{Provider: providerId})
+}
+
+// Начинает привязку внешней учетной записи к вошедшему пользователю, дальше как при обычном входе
+func (s *OIDCService) StartLink(ctx context.Context, providerId string, userId int) (string, string, error) {
+	return s.start(ctx, oidcLoginState{Provider: providerId, LinkUser: userId})
+}
+
+func (s *OIDCService) start(ctx context.Context, login oidcLoginState) (string, string, error) {
+	provider, ok := s.providers[login.Provider]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	doc, err := s.discover(ctx, provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	if login.Verifier, err = randomToken(); err != nil {
+		return "", "", err
+	}
+	if login.Nonce, err = randomToken(); err != nil {
+		return "", "", err
+	}
+
+	value, err := json.Marshal(login)
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.states.Set(oidcStatePrefix+state, value, oidcStateTTL); err != nil {
+		return "", "", err
+	}
+
+	scopes := provider.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.cfg.ClientId},
+		"redirect_uri":          {provider.cfg.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return doc.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+// Завершает вход: обменивает код на ID токен, находит или создает пользователя и открывает сессию,
+// а при включенной 2FA возвращает вызов для второго шага. При привязке сессия открывается для пользователя, который ее начал
+// State одноразовый, поэтому повторить возврат с тем же кодом нельзя
+func (s *OIDCService) CompleteLogin(ctx context.Context, providerId, code, state string) (model.SignInResult, error) {
+	provider, ok := s.providers[providerId]
+	if !ok {
+		return model.SignInResult{}, ErrUnknownProvider
+	}
+
+	value, err := s.states.GetDel(oidcStatePrefix + state)
+	if err != nil {
+		return model.SignInResult{}, ErrInvalidOIDCState
+	}
+
+	var login oidcLoginState
+	if err := json.Unmarshal([]byte(value), &login); err != nil || login.Provider != providerId {
+		return model.SignInResult{}, ErrInvalidOIDCState
+	}
+
+	doc, err := s.discover(ctx, provider)
+	if err != nil {
+		return model.SignInResult{}, err
+	}
+
+	rawIDToken, err := s.exchangeCode(ctx, provider, doc, code, login.Verifier)
+	if err != nil {
+		return model.SignInResult{}, err
+	}
+
+	claims, err := s.verifyIDToken(ctx, provider, doc, rawIDToken, login.Nonce)
+	if err != nil {
+		logrus.Warnf("oidc provider %s returned an invalid id token: %s", providerId, err.Error())
+		return model.SignInResult{}, ErrOIDCLoginFailed
+	}
+
+	userId := login.LinkUser
+	if userId != 0 {
+		err = s.repo.LinkIdentity(userId, model.UserIdentity{Provider: providerId, Subject: claims.Subject, Email: claims.Email})
+	} else {
+		userId, err = s.resolveUser(providerId, claims)
+	}
+	if err != nil {
+		return model.SignInResult{}, err
+	}
+
+	return s.auth.signIn(userId)
+}
+
+// Находит пользователя по привязанной учетной записи, а если ее нет - создает нового
+// Если email занят существующим аккаунтом, возвращает repository.ErrEmailTaken
+func (s *OIDCService) resolveUser(providerId string, claims *idTokenClaims) (int, error) {
+	userId, err := s.repo.GetIdentityUser(providerId, claims.Subject)
+	if err == nil {
+		return userId, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	// Новый аккаунт получает email провайдера, поэтому он должен быть подтвержден
+	if claims.Email == "" || !claims.EmailVerified {
+		return 0, ErrEmailNotVerified
+	}
+
+	identity := model.UserIdentity{Provider: providerId, Subject: claims.Subject, Email: claims.Email}
+
+	// Email при регистрации не проверяется, поэтому аккаунт с тем же адресом не привязывается автоматически:
+	// это позволило бы заранее захватить его. Владелец входит сам и привязывает провайдера через StartLink
+	userId, err = s.provisionUser(identity, claims)
+	if errors.Is(err, repository.ErrEmailTaken) {
+		// Одновременный первый вход той же учетной записи уже создал пользователя
+		if linked, lookupErr := s.repo.GetIdentityUser(providerId, claims.Subject); lookupErr == nil {
+			return linked, nil
+		}
+	}
+
+	return userId, err
+}
+
+// При занятом имени к нему добавляется случайный суффикс
+func (s *OIDCService) provisionUser(identity model.UserIdentity, claims *idTokenClaims) (int, error) {
+	base := oidcUsername(claims)
+	user := model.User{FirstName: claims.GivenName, LastName: claims.FamilyName, Email: claims.Email}
+
+	for attempt := 0; attempt < usernameAttempts; attempt++ {
+		user.Username = base
+		if attempt > 0 {
+			suffix, err := randomHex(3)
+			if err != nil {
+				return 0, err
+			}
+			user.Username = base + "-" + suffix
+		}
+
+		userId, err := s.repo.CreateUserWithIdentity(user, identity)
+		if !errors.Is(err, repository.ErrUsernameTaken) {
+			return userId, err
+		}
+	}
+
+	return 0, repository.ErrUsernameTaken
+}
+
+func oidcUsername(claims *idTokenClaims) string {
+	name := claims.PreferredUsername
+	if name == "" {
+		name = claims.Email
+	}
+	name, _, _ = strings.Cut(name, "@")
+
+	name = strings.Trim(usernameDisallowed.ReplaceAllString(strings.ToLower(name), ""), "._-")
+	if len(name) > maxUsernameLength {
+		name = name[:maxUsernameLength]
+	}
+	if name == "" {
+		name = "user"
+	}
+	return name
+}
+
+func (s *OIDCService) discover(ctx context.Context, provider *oidcProvider) (*oidcDiscovery, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.discovery != nil {
+		return provider.discovery, nil
+	}
+
+	var doc oidcDiscovery
+	if err := s.getJSON(ctx, strings.TrimSuffix(provider.cfg.Issuer, "/")+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery for %s: %w", provider.cfg.Id, err)
+	}
+
+	if doc.Issuer != provider.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery for %s: issuer %q does not match %q", provider.cfg.Id, doc.Issuer, provider.cfg.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery for %s: incomplete provider metadata", provider.cfg.Id)
+	}
+
+	provider.discovery = &doc
+	return provider.discovery, nil
+}
+
+// Код обменивается вместе с PKCE verifier, секрет клиента передается через basic auth
+func (s *OIDCService) exchangeCode(ctx context.Context, provider *oidcProvider, doc *oidcDiscovery, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {provider.cfg.RedirectURL},
+		"client_id":     {provider.cfg.ClientId},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if provider.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(provider.cfg.ClientId), url.QueryEscape(provider.cfg.ClientSecret))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc code exchange for %s: %w", provider.cfg.Id, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxResponseSize))
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		logrus.Warnf("oidc provider %s rejected code exchange: %d %s", provider.cfg.Id, resp.StatusCode, body)
+		return "", ErrOIDCLoginFailed
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.IDToken == "" {
+		logrus.Warnf("oidc provider %s returned no id token", provider.cfg.Id)
+		return "", ErrOIDCLoginFailed
+	}
+
+	return tokens.IDToken, nil
+}
+
+func (s *OIDCService) verifyIDToken(ctx context.Context, provider *oidcProvider, doc *oidcDiscovery, rawIDToken, nonce string) (*idTokenClaims, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.Alg() {
+		case AlgorithmRS256, "ES256", AlgorithmEdDSA:
+		default:
+			return nil, fmt.Errorf("unsupported id token algorithm %q", token.Method.Alg())
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		return s.providerKey(ctx, provider, doc, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case claims.Issuer != doc.Issuer:
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	case !claims.Audience.contains(provider.cfg.ClientId):
+		return nil, errors.New("id token is issued for another client")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != provider.cfg.ClientId:
+		return nil, errors.New("id token is authorized for another party")
+	case claims.Subject == "":
+		return nil, errors.New("id token has no subject")
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, errors.New("id token nonce does not match")
+	}
+
+	return claims, nil
+}
+
+// Ключ проверки ID токена; незнакомый kid перечитывает ключи, так подхватывается их ротация у провайдера
+func (s *OIDCService) providerKey(ctx context.Context, provider *oidcProvider, doc *oidcDiscovery, kid string) (interface{}, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if key, ok := provider.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(provider.keysFetchedAt) < oidcKeysRefreshInterval {
+		return nil, ErrUnknownSigningKey
+	}
+
+	var set model.JSONWebKeySet
+	if err := s.getJSON(ctx, doc.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := publicKeyFromJWK(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	provider.keys, provider.keysFetchedAt = keys, time.Now()
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, ErrUnknownSigningKey
+	}
+	return key, nil
+}
+
+func (s *OIDCService) getJSON(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", target, resp.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseSize)).Decode(v)
+}
+
+// 32 случайных байта в base64url: подходит и для state, и для PKCE verifier
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
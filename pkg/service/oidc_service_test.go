@@ -0,0 +1,430 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type identityRepo struct {
+	users      []model.User
+	identities map[string]int
+}
+
+func (r *identityRepo) GetIdentityUser(provider, subject string) (int, error) {
+	userId, ok := r.identities[provider+"|"+subject]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return userId, nil
+}
+
+func (r *identityRepo) LinkIdentity(userId int, identity model.UserIdentity) error {
+	key := identity.Provider + "|" + identity.Subject
+	if owner, ok := r.identities[key]; ok && owner != userId {
+		return repository.ErrIdentityLinked
+	}
+	r.identities[key] = userId
+	return nil
+}
+
+func (r *identityRepo) CreateUserWithIdentity(user model.User, identity model.UserIdentity) (int, error) {
+	for _, existing := range r.users {
+		if existing.Username == user.Username {
+			return 0, repository.ErrUsernameTaken
+		}
+		if strings.EqualFold(existing.Email, user.Email) {
+			return 0, repository.ErrEmailTaken
+		}
+	}
+
+	user.Id = len(r.users) + 1
+	r.users = append(r.users, user)
+	r.identities[identity.Provider+"|"+identity.Subject] = user.Id
+	return user.Id, nil
+}
+
+type stateStore map[string]string
+
+func (s stateStore) Set(key string, value interface{}, expiration time.Duration) error {
+	switch v := value.(type) {
+	case []byte:
+		s[key] = string(v)
+	default:
+		s[key] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+func (s stateStore) Get(key string) (string, error) {
+	value, ok := s[key]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return value, nil
+}
+
+func (s stateStore) GetDel(key string) (string, error) {
+	value, err := s.Get(key)
+	delete(s, key)
+	return value, err
+}
+
+func (s stateStore) Del(key string) error {
+	delete(s, key)
+	return nil
+}
+
+func (s stateStore) Incr(key string, expiration time.Duration) (int64, error) {
+	count, _ := strconv.ParseInt(s[key], 10, 64)
+	count++
+	s[key] = strconv.FormatInt(count, 10)
+	return count, nil
+}
+
+type sessionRecorder struct {
+	users []int
+}
+
+func (r *sessionRecorder) signIn(userId int) (model.SignInResult, error) {
+	r.users = append(r.users, userId)
+	return model.SignInResult{TokenPair: &model.TokenPair{AccessToken: fmt.Sprintf("token-%d", userId)}}, nil
+}
+
+// Тестовый провайдер: discovery, ключи и обмен кода с проверкой PKCE
+type mockIdP struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientId string
+	secret   string
+	grants   map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+	key       *rsa.PrivateKey
+	kid       string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := &mockIdP{key: key, clientId: "chat", secret: "s3cret", grants: make(map[string]mockGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(model.JSONWebKeySet{Keys: []model.JSONWebKey{{
+			Kty: "RSA",
+			Kid: "idp-1",
+			Use: "sig",
+			Alg: AlgorithmRS256,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		grant, ok := idp.grants[r.PostFormValue("code")]
+		delete(idp.grants, r.PostFormValue("code"))
+
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || user != idp.clientId || pass != idp.secret ||
+			r.PostFormValue("grant_type") != "authorization_code" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+		token.Header["kid"] = grant.kid
+		idToken, err := token.SignedString(grant.key)
+		require.NoError(t, err)
+
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": idToken})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) config() OIDCProviderConfig {
+	return OIDCProviderConfig{
+		Id:           "corp",
+		Name:         "Corp SSO",
+		Issuer:       idp.server.URL,
+		ClientId:     idp.clientId,
+		ClientSecret: idp.secret,
+		RedirectURL:  "https://chat.example.com/auth/oidc/corp/callback",
+	}
+}
+
+func (idp *mockIdP) claims(subject, email string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"aud":            idp.clientId,
+		"sub":            subject,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"email":          email,
+		"email_verified": true,
+	}
+}
+
+// Проходит страницу провайдера: проверяет параметры запроса и выдает код для указанных claims
+func (idp *mockIdP) authorize(t *testing.T, s *OIDCService, claims jwt.MapClaims) (string, string) {
+	location, state, err := s.StartLogin(context.Background(), "corp")
+	require.NoError(t, err)
+
+	u, err := url.Parse(location)
+	require.NoError(t, err)
+	query := u.Query()
+	assert.Equal(t, idp.server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, "code", query.Get("response_type"))
+	assert.Equal(t, idp.clientId, query.Get("client_id"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.Equal(t, "openid email profile", query.Get("scope"))
+	assert.Equal(t, state, query.Get("state"))
+
+	if _, ok := claims["nonce"]; !ok {
+		claims["nonce"] = query.Get("nonce")
+	}
+
+	code := fmt.Sprintf("code-%d", len(idp.grants)+1)
+	idp.grants[code] = mockGrant{challenge: query.Get("code_challenge"), claims: claims, key: idp.key, kid: "idp-1"}
+	return code, state
+}
+
+func newTestOIDCService(idp *mockIdP, users ...model.User) (*OIDCService, *identityRepo, *sessionRecorder) {
+	repo := &identityRepo{users: users, identities: make(map[string]int)}
+	sessions := &sessionRecorder{}
+	return NewOIDCService(repo, stateStore{}, sessions, []OIDCProviderConfig{idp.config()}), repo, sessions
+}
+
+func TestOIDC_ProvisionsUserOnFirstLogin(t *testing.T) {
+	idp := newMockIdP(t)
+	s, repo, sessions := newTestOIDCService(idp)
+
+	claims := idp.claims("sub-1", "alice@corp.com")
+	claims["preferred_username"] = "Alice.Smith@corp.com"
+	claims["given_name"], claims["family_name"] = "Alice", "Smith"
+
+	code, state := idp.authorize(t, s, claims)
+	tokens, err := s.CompleteLogin(context.Background(), "corp", code, state)
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", tokens.AccessToken)
+
+	require.Len(t, repo.users, 1)
+	assert.Equal(t, "alice.smith", repo.users[0].Username)
+	assert.Equal(t, "alice@corp.com", repo.users[0].Email)
+	assert.Equal(t, "Alice", repo.users[0].FirstName)
+	assert.Empty(t, repo.users[0].Password)
+
+	// Повторный вход находит пользователя по привязке, даже если email у провайдера изменился
+	code, state = idp.authorize(t, s, idp.claims("sub-1", "alice.smith@corp.com"))
+	_, err = s.CompleteLogin(context.Background(), "corp", code, state)
+	require.NoError(t, err)
+
+	assert.Len(t, repo.users, 1)
+	assert.Equal(t, []int{1, 1}, sessions.users)
+}
+
+// Email при регистрации не проверяется: кто угодно мог заранее занять чужой адрес
+func TestOIDC_DoesNotLinkExistingEmail(t *testing.T) {
+	idp := newMockIdP(t)
+	s, repo, sessions := newTestOIDCService(idp, model.User{Id: 1, Username: "squatter", Email: "Alice@Corp.com"})
+
+	code, state := idp.authorize(t, s, idp.claims("sub-1", "alice@corp.com"))
+	_, err := s.CompleteLogin(context.Background(), "corp", code, state)
+	assert.ErrorIs(t, err, repository.ErrEmailTaken)
+
+	assert.Len(t, repo.users, 1)
+	assert.Empty(t, repo.identities)
+	assert.Empty(t, sessions.users)
+}
+
+func TestOIDC_LinksSignedInUser(t *testing.T) {
+	idp := newMockIdP(t)
+	s, repo, sessions := newTestOIDCService(idp, model.User{Id: 1, Username: "alice", Email: "alice@home.net"})
+
+	location, state, err := s.StartLink(context.Background(), "corp", 1)
+	require.NoError(t, err)
+	u, err := url.Parse(location)
+	require.NoError(t, err)
+
+	claims := idp.claims("sub-1", "alice@corp.com")
+	claims["nonce"] = u.Query().Get("nonce")
+	idp.grants["link"] = mockGrant{challenge: u.Query().Get("code_challenge"), claims: claims, key: idp.key, kid: "idp-1"}
+
+	_, err = s.CompleteLogin(context.Background(), "corp", "link", state)
+	require.NoError(t, err)
+
+	assert.Len(t, repo.users, 1)
+	assert.Equal(t, 1, repo.identities["corp|sub-1"])
+	assert.Equal(t, []int{1}, sessions.users)
+
+	// Учетная запись, привязанная к другому пользователю, не переходит к нему
+	repo.identities["corp|sub-2"] = 5
+	location, state, err = s.StartLink(context.Background(), "corp", 1)
+	require.NoError(t, err)
+	u, err = url.Parse(location)
+	require.NoError(t, err)
+
+	claims = idp.claims("sub-2", "bob@corp.com")
+	claims["nonce"] = u.Query().Get("nonce")
+	idp.grants["taken"] = mockGrant{challenge: u.Query().Get("code_challenge"), claims: claims, key: idp.key, kid: "idp-1"}
+
+	_, err = s.CompleteLogin(context.Background(), "corp", "taken", state)
+	assert.ErrorIs(t, err, repository.ErrIdentityLinked)
+	assert.Equal(t, 5, repo.identities["corp|sub-2"])
+}
+
+// Вход через провайдера не заменяет второй фактор
+func TestOIDC_RequiresTwoFactor(t *testing.T) {
+	idp := newMockIdP(t)
+	auth, _ := newTestAuthService(t)
+	secret, _ := enableTwoFactor(t, auth)
+
+	repo := &identityRepo{identities: map[string]int{"corp|sub-1": 1}}
+	s := NewOIDCService(repo, stateStore{}, auth, []OIDCProviderConfig{idp.config()})
+
+	code, state := idp.authorize(t, s, idp.claims("sub-1", "alice@corp.com"))
+	result, err := s.CompleteLogin(context.Background(), "corp", code, state)
+	require.NoError(t, err)
+	assert.Nil(t, result.TokenPair)
+	require.NotNil(t, result.TwoFactorChallenge)
+
+	tokens, err := auth.VerifyTwoFactor(result.ChallengeToken, currentCode(t, auth, secret))
+	require.NoError(t, err)
+
+	access, err := auth.ParseToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, 1, access.User)
+}
+
+func TestOIDC_RejectsUnverifiedEmail(t *testing.T) {
+	idp := newMockIdP(t)
+	s, repo, sessions := newTestOIDCService(idp, model.User{Id: 1, Username: "alice", Email: "alice@corp.com"})
+
+	claims := idp.claims("attacker", "alice@corp.com")
+	claims["email_verified"] = false
+
+	code, state := idp.authorize(t, s, claims)
+	_, err := s.CompleteLogin(context.Background(), "corp", code, state)
+	assert.ErrorIs(t, err, ErrEmailNotVerified)
+
+	assert.Empty(t, repo.identities)
+	assert.Empty(t, sessions.users)
+}
+
+func TestOIDC_UsernameCollision(t *testing.T) {
+	idp := newMockIdP(t)
+	s, repo, _ := newTestOIDCService(idp, model.User{Id: 1, Username: "alice", Email: "alice@home.net"})
+
+	code, state := idp.authorize(t, s, idp.claims("sub-1", "alice@corp.com"))
+	_, err := s.CompleteLogin(context.Background(), "corp", code, state)
+	require.NoError(t, err)
+
+	require.Len(t, repo.users, 2)
+	assert.True(t, strings.HasPrefix(repo.users[1].Username, "alice-"))
+	assert.Equal(t, 2, repo.identities["corp|sub-1"])
+}
+
+func TestOIDC_StateIsSingleUse(t *testing.T) {
+	idp := newMockIdP(t)
+	s, _, _ := newTestOIDCService(idp)
+
+	code, state := idp.authorize(t, s, idp.claims("sub-1", "alice@corp.com"))
+	_, err := s.CompleteLogin(context.Background(), "corp", code, state)
+	require.NoError(t, err)
+
+	_, err = s.CompleteLogin(context.Background(), "corp", code, state)
+	assert.ErrorIs(t, err, ErrInvalidOIDCState)
+
+	_, err = s.CompleteLogin(context.Background(), "corp", code, "forged")
+	assert.ErrorIs(t, err, ErrInvalidOIDCState)
+
+	_, err = s.CompleteLogin(context.Background(), "other", code, state)
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+}
+
+func TestOIDC_RequiresPKCEVerifier(t *testing.T) {
+	idp := newMockIdP(t)
+	s, _, _ := newTestOIDCService(idp)
+
+	code, state := idp.authorize(t, s, idp.claims("sub-1", "alice@corp.com"))
+	grant := idp.grants[code]
+	grant.challenge = "another-challenge"
+	idp.grants[code] = grant
+
+	_, err := s.CompleteLogin(context.Background(), "corp", code, state)
+	assert.ErrorIs(t, err, ErrOIDCLoginFailed)
+}
+
+func TestOIDC_RejectsInvalidIDToken(t *testing.T) {
+	idp := newMockIdP(t)
+	rogue, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	cases := map[string]func(claims jwt.MapClaims, grant *mockGrant){
+		"wrong nonce":    func(claims jwt.MapClaims, _ *mockGrant) { claims["nonce"] = "replayed" },
+		"wrong audience": func(claims jwt.MapClaims, _ *mockGrant) { claims["aud"] = []string{"other-app"} },
+		"foreign azp": func(claims jwt.MapClaims, _ *mockGrant) {
+			claims["aud"], claims["azp"] = []string{"other-app", idp.clientId}, "other-app"
+		},
+		"wrong issuer": func(claims jwt.MapClaims, _ *mockGrant) { claims["iss"] = "https://evil.example.com" },
+		"expired":      func(claims jwt.MapClaims, _ *mockGrant) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"unknown key":  func(_ jwt.MapClaims, grant *mockGrant) { grant.key, grant.kid = rogue, "rogue" },
+		"forged key":   func(_ jwt.MapClaims, grant *mockGrant) { grant.key = rogue },
+	}
+
+	for name, tamper := range cases {
+		s, _, sessions := newTestOIDCService(idp)
+
+		code, state := idp.authorize(t, s, idp.claims("sub-1", "alice@corp.com"))
+		grant := idp.grants[code]
+		tamper(grant.claims, &grant)
+		idp.grants[code] = grant
+
+		_, err := s.CompleteLogin(context.Background(), "corp", code, state)
+		assert.ErrorIs(t, err, ErrOIDCLoginFailed, name)
+		assert.Empty(t, sessions.users, name)
+	}
+}
+
+func TestOIDCUsername(t *testing.T) {
+	assert.Equal(t, "bob", oidcUsername(&idTokenClaims{Email: "Bob@corp.com"}))
+	assert.Equal(t, "j.doe", oidcUsername(&idTokenClaims{PreferredUsername: "J. Doe!"}))
+	assert.Equal(t, "user", oidcUsername(&idTokenClaims{PreferredUsername: "Иван"}))
+	assert.Len(t, oidcUsername(&idTokenClaims{PreferredUsername: strings.Repeat("a", 100)}), maxUsernameLength)
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/middleware/monitoring"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	maxOutboxBackoff      = 30 * time.Second
+	defaultOutboxInterval = 500 * time.Millisecond
+	defaultOutboxBatch    = 100
+)
+
+// Публикует payload события в брокер, реализуется kafka.Producer
+type eventProducer interface {
+	Send(roomId int, payload []byte) error
+}
+
+// Фоновый воркер, публикующий события из outbox в Kafka.
+// Событие помечается отправленным только после подтверждения брокера,
+// поэтому доставка гарантируется как минимум один раз.
+// Воркер запускается на каждом узле, но публикует в каждый момент только один из них
+type OutboxRelay struct {
+	repo      repository.Outbox
+	producer  eventProducer
+	interval  time.Duration
+	batchSize int
+}
+
+// Без интервала и размера пачки воркер опрашивал бы БД без пауз, поэтому для них есть значения по умолчанию
+func NewOutboxRelay(repo repository.Outbox, producer eventProducer, interval time.Duration, batchSize int) *OutboxRelay {
+	if interval <= 0 {
+		interval = defaultOutboxInterval
+	}
+	if batchSize <= 0 {
+		batchSize = defaultOutboxBatch
+	}
+
+	return &OutboxRelay{
+		repo:      repo,
+		producer:  producer,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+// Опрашивает outbox до отмены контекста.
+// Пока есть ошибки публикации, пауза между попытками растет экспоненциально
+func (r *OutboxRelay) Run(ctx context.Context) {
+	delay := r.interval
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		sent, err := r.repo.ProcessPending(r.batchSize, r.publish)
+		if err != nil {
+			logrus.Errorf("outbox relay error: %s", err.Error())
+			delay *= 2
+			if delay > maxOutboxBackoff {
+				delay = maxOutboxBackoff
+			}
+		} else {
+			delay = r.interval
+		}
+
+		r.reportStats()
+
+		// Полная пачка - скорее всего есть еще события, забираем без паузы
+		if err == nil && sent == r.batchSize {
+			delay = 0
+		}
+	}
+}
+
+func (r *OutboxRelay) publish(event model.OutboxEvent) error {
+	if err := r.producer.Send(event.AggregateId, event.Payload); err != nil {
+		monitoring.IncrementOutboxPublished("error")
+		return err
+	}
+
+	monitoring.IncrementOutboxPublished("success")
+	monitoring.IncrementKafkaMessagesSent(event.EventType)
+	return nil
+}
+
+func (r *OutboxRelay) reportStats() {
+	stats, err := r.repo.GetStats()
+	if err != nil {
+		logrus.Errorf("failed to read outbox stats: %s", err.Error())
+		return
+	}
+
+	monitoring.SetOutboxStats(stats.Pending, stats.LagSeconds)
+}
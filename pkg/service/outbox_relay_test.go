@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Outbox в памяти с той же семантикой, что и у OutboxPostgres
+type outboxRepo struct {
+	mu      sync.Mutex
+	pending []model.OutboxEvent
+	batches []int
+}
+
+func (r *outboxRepo) ProcessPending(limit int, publish func(event model.OutboxEvent) error) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.batches = append(r.batches, limit)
+
+	sent := 0
+	for len(r.pending) > 0 && sent < limit {
+		if err := publish(r.pending[0]); err != nil {
+			r.pending[0].Attempts++
+			return sent, err
+		}
+		r.pending = r.pending[1:]
+		sent++
+	}
+	return sent, nil
+}
+
+func (r *outboxRepo) GetStats() (model.OutboxStats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return model.OutboxStats{Pending: len(r.pending)}, nil
+}
+
+func (r *outboxRepo) left() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pending)
+}
+
+type fakeProducer struct {
+	mu       sync.Mutex
+	sent     []string
+	rooms    []int
+	failures int
+}
+
+func (p *fakeProducer) Send(roomId int, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker is not available")
+	}
+	p.sent = append(p.sent, string(payload))
+	p.rooms = append(p.rooms, roomId)
+	return nil
+}
+
+func outboxEvents(count int) []model.OutboxEvent {
+	events := make([]model.OutboxEvent, 0, count)
+	for i := 1; i <= count; i++ {
+		events = append(events, model.OutboxEvent{Id: int64(i), AggregateId: i % 2, Payload: []byte{byte('a' + i - 1)}})
+	}
+	return events
+}
+
+func TestNewOutboxRelay_Defaults(t *testing.T) {
+	relay := NewOutboxRelay(&outboxRepo{}, &fakeProducer{}, 0, 0)
+
+	assert.Equal(t, defaultOutboxInterval, relay.interval)
+	assert.Equal(t, defaultOutboxBatch, relay.batchSize)
+}
+
+func TestOutboxRelay_PublishesInOrderAfterFailure(t *testing.T) {
+	repo := &outboxRepo{pending: outboxEvents(5)}
+	producer := &fakeProducer{failures: 2}
+	relay := NewOutboxRelay(repo, producer, time.Millisecond, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return repo.left() == 0 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, producer.sent)
+	assert.Equal(t, []int{1, 0, 1, 0, 1}, producer.rooms)
+	for _, limit := range repo.batches {
+		assert.Equal(t, 2, limit)
+	}
+}
+
+func TestOutboxRelay_StopsOnCancel(t *testing.T) {
+	relay := NewOutboxRelay(&outboxRepo{}, &fakeProducer{}, time.Hour, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("relay did not stop after cancel")
+	}
+}
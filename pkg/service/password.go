@@ -0,0 +1,106 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+var errInvalidPasswordHash = errors.New("invalid password hash format")
+
+// Параметры argon2id, Memory задается в КиБ
+// Изменение параметров не ломает старые хеши: они пересчитываются при следующем входе
+type PasswordConfig struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Значения по умолчанию соответствуют рекомендациям OWASP для argon2id
+func (c PasswordConfig) withDefaults() PasswordConfig {
+	if c.Memory == 0 {
+		c.Memory = 64 * 1024
+	}
+	if c.Iterations == 0 {
+		c.Iterations = 3
+	}
+	if c.Parallelism == 0 {
+		c.Parallelism = 2
+	}
+	if c.SaltLength == 0 {
+		c.SaltLength = 16
+	}
+	if c.KeyLength == 0 {
+		c.KeyLength = 32
+	}
+	return c
+}
+
+// Хеширует пароль в формате PHC: $argon2id$v=19$m=...,t=...,p=...$соль$хеш
+func hashPassword(password string, cfg PasswordConfig) (string, error) {
+	salt := make([]byte, cfg.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, cfg.Iterations, cfg.Memory, cfg.Parallelism, cfg.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		cfg.Memory, cfg.Iterations, cfg.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Проверяет пароль и сообщает, нужно ли пересчитать хеш:
+// для устаревшего SHA-1 и для argon2id с параметрами, отличными от текущих
+func verifyPassword(password, encoded string, cfg PasswordConfig) (bool, bool, error) {
+	if !strings.HasPrefix(encoded, "$") {
+		return verifyLegacyPassword(password, encoded), true, nil
+	}
+
+	var version int
+	var params PasswordConfig
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, false, errInvalidPasswordHash
+	}
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, errInvalidPasswordHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return false, false, errInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, errInvalidPasswordHash
+	}
+
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(expected) == 0 {
+		return false, false, errInvalidPasswordHash
+	}
+	params.SaltLength, params.KeyLength = uint32(len(salt)), uint32(len(expected))
+
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, expected) != 1 {
+		return false, false, nil
+	}
+
+	return true, params != cfg, nil
+}
+
+// Хеши, созданные до перехода на argon2id: несоленый SHA-1 в hex
+func verifyLegacyPassword(password, encoded string) bool {
+	sum := sha1.Sum([]byte(password))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(encoded)) == 1
+}
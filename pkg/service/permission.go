@@ -0,0 +1,40 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/firstproject/talk-together-app/model"
+)
+
+var ErrForbidden = errors.New("not enough permissions in this room")
+
+// Источник ролей участников, реализуется repository.Room
+type memberLookup interface {
+	GetMember(roomId, userId int) (model.RoomMember, error)
+}
+
+// Возвращает участника, если его роль разрешает действие в комнате
+func authorize(members memberLookup, roomId, userId int, perm model.Permission) (model.RoomMember, error) {
+	member, err := members.GetMember(roomId, userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.RoomMember{}, ErrNotRoomMember
+		}
+		return model.RoomMember{}, err
+	}
+
+	if !model.RoleHas(member.Role, perm) {
+		return model.RoomMember{}, ErrForbidden
+	}
+
+	return member, nil
+}
+
+// Проверяет только членство, без требований к роли
+func requireMember(members memberLookup, roomId, userId int) error {
+	_, err := members.GetMember(roomId, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotRoomMember
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"errors"
+	"github.com/firstproject/talk-together-app/model"
+	"time"
+)
+
+// Статус истекает, если клиент не прислал heartbeat за это время
+const presenceTTL = 45 * time.Second
+
+var ErrInvalidPresenceStatus = errors.New("invalid presence status")
+
+// Хранилище присутствия, общее для всех узлов, реализуется redis.Client
+type presenceStore interface {
+	SetPresence(roomId, userId int, status string, ttl time.Duration) (string, error)
+	RefreshPresence(roomId, userId int, ttl time.Duration) (bool, error)
+	AddPresenceConnections(roomId, userId int, delta int64) (int64, error)
+	RemovePresence(roomId, userId int) (bool, error)
+	GetRoomPresence(roomId int) (map[int]string, []int, error)
+	GetPresenceRooms() ([]int, error)
+}
+
+type PresenceService struct {
+	store presenceStore
+}
+
+func NewPresenceService(store presenceStore) *PresenceService {
+	return &PresenceService{store: store}
+}
+
+// Регистрирует новое соединение пользователя
+// changed = true, если пользователь до этого не был online
+func (s *PresenceService) Connect(roomId, userId int) (model.Presence, bool, error) {
+	presence := model.Presence{User: userId, Room: roomId, Status: model.PresenceOnline}
+
+	if _, err := s.store.AddPresenceConnections(roomId, userId, 1); err != nil {
+		return presence, false, err
+	}
+
+	previous, err := s.store.SetPresence(roomId, userId, model.PresenceOnline, presenceTTL)
+	if err != nil {
+		return presence, false, err
+	}
+
+	return presence, previous != model.PresenceOnline, nil
+}
+
+// Закрывает соединение пользователя
+// Пользователь уходит в offline только когда закрыто последнее его соединение
+func (s *PresenceService) Disconnect(roomId, userId int) (model.Presence, bool, error) {
+	presence := model.Presence{User: userId, Room: roomId, Status: model.PresenceOffline}
+
+	count, err := s.store.AddPresenceConnections(roomId, userId, -1)
+	if err != nil {
+		return presence, false, err
+	}
+
+	if count > 0 {
+		return presence, false, nil
+	}
+
+	removed, err := s.store.RemovePresence(roomId, userId)
+	return presence, removed, err
+}
+
+// Продлевает статус пользователя
+// Если статус успел истечь, пользователь снова становится online
+func (s *PresenceService) Heartbeat(roomId, userId int) (model.Presence, bool, error) {
+	presence := model.Presence{User: userId, Room: roomId, Status: model.PresenceOnline}
+
+	alive, err := s.store.RefreshPresence(roomId, userId, presenceTTL)
+	if err != nil || alive {
+		return presence, false, err
+	}
+
+	if _, err := s.store.SetPresence(roomId, userId, model.PresenceOnline, presenceTTL); err != nil {
+		return presence, false, err
+	}
+
+	return presence, true, nil
+}
+
+// Устанавливает статус online или away по запросу клиента
+func (s *PresenceService) SetStatus(roomId, userId int, status string) (model.Presence, bool, error) {
+	presence := model.Presence{User: userId, Room: roomId, Status: status}
+
+	if status != model.PresenceOnline && status != model.PresenceAway {
+		return presence, false, ErrInvalidPresenceStatus
+	}
+
+	previous, err := s.store.SetPresence(roomId, userId, status, presenceTTL)
+	if err != nil {
+		return presence, false, err
+	}
+
+	return presence, previous != status, nil
+}
+
+func (s *PresenceService) GetRoomPresence(roomId int) ([]model.Presence, error) {
+	statuses, _, err := s.store.GetRoomPresence(roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	presence := make([]model.Presence, 0, len(statuses))
+	for userId, status := range statuses {
+		presence = append(presence, model.Presence{User: userId, Room: roomId, Status: status})
+	}
+
+	return presence, nil
+}
+
+// Удаляет пользователей, у которых истек статус (например, узел упал без Disconnect)
+// Каждый переход в offline возвращается ровно одному узлу
+func (s *PresenceService) SweepExpired() ([]model.Presence, error) {
+	rooms, err := s.store.GetPresenceRooms()
+	if err != nil {
+		return nil, err
+	}
+
+	var offline []model.Presence
+	for _, roomId := range rooms {
+		_, expired, err := s.store.GetRoomPresence(roomId)
+		if err != nil {
+			return offline, err
+		}
+
+		for _, userId := range expired {
+			removed, err := s.store.RemovePresence(roomId, userId)
+			if err != nil {
+				return offline, err
+			}
+
+			if removed {
+				offline = append(offline, model.Presence{User: userId, Room: roomId, Status: model.PresenceOffline})
+			}
+		}
+	}
+
+	return offline, nil
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakePresenceStore struct {
+	statuses map[int]map[int]string
+	expired  map[int]map[int]bool
+	conns    map[int]map[int]int64
+}
+
+func newFakePresenceStore() *fakePresenceStore {
+	return &fakePresenceStore{
+		statuses: make(map[int]map[int]string),
+		expired:  make(map[int]map[int]bool),
+		conns:    make(map[int]map[int]int64),
+	}
+}
+
+func (s *fakePresenceStore) SetPresence(roomId, userId int, status string, ttl time.Duration) (string, error) {
+	if s.statuses[roomId] == nil {
+		s.statuses[roomId] = make(map[int]string)
+		s.expired[roomId] = make(map[int]bool)
+	}
+
+	previous := s.statuses[roomId][userId]
+	if s.expired[roomId][userId] {
+		previous = ""
+	}
+
+	s.statuses[roomId][userId] = status
+	s.expired[roomId][userId] = false
+	return previous, nil
+}
+
+func (s *fakePresenceStore) RefreshPresence(roomId, userId int, ttl time.Duration) (bool, error) {
+	_, ok := s.statuses[roomId][userId]
+	return ok && !s.expired[roomId][userId], nil
+}
+
+func (s *fakePresenceStore) AddPresenceConnections(roomId, userId int, delta int64) (int64, error) {
+	if s.conns[roomId] == nil {
+		s.conns[roomId] = make(map[int]int64)
+	}
+	s.conns[roomId][userId] += delta
+	return s.conns[roomId][userId], nil
+}
+
+func (s *fakePresenceStore) RemovePresence(roomId, userId int) (bool, error) {
+	_, ok := s.statuses[roomId][userId]
+	delete(s.statuses[roomId], userId)
+	delete(s.expired[roomId], userId)
+	return ok, nil
+}
+
+func (s *fakePresenceStore) GetRoomPresence(roomId int) (map[int]string, []int, error) {
+	statuses := make(map[int]string)
+	var expired []int
+	for userId, status := range s.statuses[roomId] {
+		if s.expired[roomId][userId] {
+			expired = append(expired, userId)
+			continue
+		}
+		statuses[userId] = status
+	}
+	return statuses, expired, nil
+}
+
+func (s *fakePresenceStore) GetPresenceRooms() ([]int, error) {
+	var rooms []int
+	for roomId := range s.statuses {
+		rooms = append(rooms, roomId)
+	}
+	return rooms, nil
+}
+
+func TestPresence_MultipleConnections(t *testing.T) {
+	s := NewPresenceService(newFakePresenceStore())
+
+	_, changed, err := s.Connect(1, 10)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	_, changed, _ = s.Connect(1, 10)
+	assert.False(t, changed, "second tab must not emit presence.changed")
+
+	_, changed, _ = s.Disconnect(1, 10)
+	assert.False(t, changed, "user still has an open connection")
+
+	presence, changed, _ := s.Disconnect(1, 10)
+	assert.True(t, changed)
+	assert.Equal(t, model.PresenceOffline, presence.Status)
+}
+
+func TestPresence_SetStatus(t *testing.T) {
+	s := NewPresenceService(newFakePresenceStore())
+	s.Connect(1, 10)
+
+	_, changed, err := s.SetStatus(1, 10, model.PresenceAway)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	_, _, err = s.SetStatus(1, 10, "busy")
+	assert.ErrorIs(t, err, ErrInvalidPresenceStatus)
+
+	presence, err := s.GetRoomPresence(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []model.Presence{{User: 10, Room: 1, Status: model.PresenceAway}}, presence)
+}
+
+func TestPresence_SweepExpired(t *testing.T) {
+	store := newFakePresenceStore()
+	s := NewPresenceService(store)
+	s.Connect(1, 10)
+	s.Connect(1, 11)
+
+	store.expired[1][10] = true
+
+	offline, err := s.SweepExpired()
+	assert.NoError(t, err)
+	assert.Equal(t, []model.Presence{{User: 10, Room: 1, Status: model.PresenceOffline}}, offline)
+
+	offline, err = s.SweepExpired()
+	assert.NoError(t, err)
+	assert.Empty(t, offline)
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/sirupsen/logrus"
+)
+
+// Кеш счетчиков непрочитанных, реализуется redis.Client
+type unreadCache interface {
+	GetCachedUnreadCounts(userId int, roomIds []int) (map[int]int, error)
+	CacheUnreadCounts(userId int, counts map[int]int) error
+	IncrementUnread(roomId int, userIds []int) error
+	InvalidateUnread(roomId int, userIds []int) error
+}
+
+type ReceiptService struct {
+	repo  repository.Receipt
+	cache unreadCache
+}
+
+func NewReceiptService(repo repository.Receipt, cache unreadCache) *ReceiptService {
+	return &ReceiptService{repo: repo, cache: cache}
+}
+
+// Сдвигает отметку о прочтении, счетчик комнаты у пользователя пересчитается при следующем запросе
+func (s *ReceiptService) MarkRead(userId, roomId, messageId int) (model.ReadReceipt, error) {
+	receipt, advanced, err := s.repo.AdvanceReadCursor(userId, roomId, messageId)
+	if err != nil {
+		return receipt, err
+	}
+
+	if advanced && s.cache != nil {
+		if err := s.cache.InvalidateUnread(roomId, []int{userId}); err != nil {
+			logrus.Errorf("failed to invalidate unread counter of user %d: %s", userId, err.Error())
+		}
+	}
+
+	return receipt, nil
+}
+
+func (s *ReceiptService) GetRoomReceipts(roomId int) ([]model.ReadReceipt, error) {
+	return s.repo.GetRoomReceipts(roomId)
+}
+
+// Возвращает счетчики непрочитанных из кеша, недостающие считает одним запросом
+// Кешируются только комнаты с отметкой о прочтении: только их счетчики
+// поддерживаются при появлении новых сообщений
+func (s *ReceiptService) GetUnreadCounts(userId int, roomIds []int) (map[int]int, error) {
+	counts := make(map[int]int, len(roomIds))
+	missing := roomIds
+
+	if s.cache != nil {
+		cached, err := s.cache.GetCachedUnreadCounts(userId, roomIds)
+		if err == nil {
+			counts = cached
+			missing = make([]int, 0, len(roomIds))
+			for _, roomId := range roomIds {
+				if _, ok := cached[roomId]; !ok {
+					missing = append(missing, roomId)
+				}
+			}
+		}
+	}
+
+	if len(missing) == 0 {
+		return counts, nil
+	}
+
+	loaded, err := s.repo.GetUnreadCounts(userId, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(map[int]int, len(loaded))
+	for _, count := range loaded {
+		counts[count.Room] = count.Unread
+		if count.Tracked {
+			tracked[count.Room] = count.Unread
+		}
+	}
+
+	if s.cache != nil {
+		if err := s.cache.CacheUnreadCounts(userId, tracked); err != nil {
+			logrus.Errorf("failed to cache unread counters of user %d: %s", userId, err.Error())
+		}
+	}
+
+	return counts, nil
+}
+
+// Увеличивает закешированные счетчики комнаты у всех, кроме автора сообщения
+func (s *ReceiptService) MessageCreated(roomId, authorId int) {
+	if s.cache == nil {
+		return
+	}
+	s.updateReaders(roomId, authorId, s.cache.IncrementUnread)
+}
+
+// Сбрасывает закешированные счетчики комнаты, удаленное сообщение могло быть непрочитанным
+func (s *ReceiptService) MessageDeleted(roomId int) {
+	if s.cache == nil {
+		return
+	}
+	s.updateReaders(roomId, 0, s.cache.InvalidateUnread)
+}
+
+func (s *ReceiptService) updateReaders(roomId, skipUserId int, update func(roomId int, userIds []int) error) {
+	readers, err := s.repo.GetRoomReaders(roomId)
+	if err != nil {
+		logrus.Errorf("failed to load readers of room %d: %s", roomId, err.Error())
+		return
+	}
+
+	userIds := make([]int, 0, len(readers))
+	for _, userId := range readers {
+		if userId != skipUserId {
+			userIds = append(userIds, userId)
+		}
+	}
+
+	if err := update(roomId, userIds); err != nil {
+		logrus.Errorf("failed to update unread counters of room %d: %s", roomId, err.Error())
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type fakeReceiptRepo struct {
+	repository.Receipt
+	counts  map[int]model.UnreadCount
+	readers []int
+	queries int
+}
+
+func (r *fakeReceiptRepo) GetUnreadCounts(userId int, roomIds []int) ([]model.UnreadCount, error) {
+	r.queries++
+	var counts []model.UnreadCount
+	for _, roomId := range roomIds {
+		counts = append(counts, r.counts[roomId])
+	}
+	return counts, nil
+}
+
+func (r *fakeReceiptRepo) GetRoomReaders(roomId int) ([]int, error) {
+	return r.readers, nil
+}
+
+type fakeUnreadCache struct {
+	counts map[int]map[int]int
+}
+
+func (c *fakeUnreadCache) GetCachedUnreadCounts(userId int, roomIds []int) (map[int]int, error) {
+	result := make(map[int]int)
+	for _, roomId := range roomIds {
+		if count, ok := c.counts[userId][roomId]; ok {
+			result[roomId] = count
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeUnreadCache) CacheUnreadCounts(userId int, counts map[int]int) error {
+	if c.counts[userId] == nil {
+		c.counts[userId] = make(map[int]int)
+	}
+	for roomId, count := range counts {
+		c.counts[userId][roomId] = count
+	}
+	return nil
+}
+
+func (c *fakeUnreadCache) IncrementUnread(roomId int, userIds []int) error {
+	for _, userId := range userIds {
+		if _, ok := c.counts[userId][roomId]; ok {
+			c.counts[userId][roomId]++
+		}
+	}
+	return nil
+}
+
+func (c *fakeUnreadCache) InvalidateUnread(roomId int, userIds []int) error {
+	for _, userId := range userIds {
+		delete(c.counts[userId], roomId)
+	}
+	return nil
+}
+
+func TestGetUnreadCounts_CachesTrackedRooms(t *testing.T) {
+	repo := &fakeReceiptRepo{
+		counts: map[int]model.UnreadCount{
+			1: {Room: 1, Unread: 3, Tracked: true},
+			2: {Room: 2, Unread: 7},
+		},
+		readers: []int{10, 20},
+	}
+	cache := &fakeUnreadCache{counts: make(map[int]map[int]int)}
+	s := NewReceiptService(repo, cache)
+
+	counts, err := s.GetUnreadCounts(10, []int{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{1: 3, 2: 7}, counts)
+	assert.Equal(t, map[int]int{1: 3}, cache.counts[10])
+
+	s.MessageCreated(1, 20)
+
+	counts, err = s.GetUnreadCounts(10, []int{1})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, counts[1])
+	assert.Equal(t, 1, repo.queries, "tracked room must be served from cache")
+}
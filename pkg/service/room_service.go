@@ -1,10 +1,29 @@
 package service
 
 import (
+	"database/sql"
+	"errors"
 	"github.com/firstproject/talk-together-app/model"
 	"github.com/firstproject/talk-together-app/pkg/repository"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+var (
+	ErrInvalidVisibility   = errors.New("invalid room visibility")
+	ErrRoomNotJoinable     = errors.New("room can be joined only by invitation")
+	ErrOwnerCannotLeave    = errors.New("room owner cannot leave the room")
+	ErrNotRoomMember       = errors.New("you are not a member of this room")
+	ErrUserBanned          = errors.New("user is banned in this room")
+	ErrInvalidRole         = errors.New("invalid room role")
+	ErrInvalidParticipants = errors.New("invalid conversation participants")
+	ErrConversationFixed   = errors.New("conversation participants cannot be invited")
+)
+
+// Наибольшее число участников групповой переписки вместе с создателем
+const maxConversationParticipants = 10
+
 type RoomService struct {
 	repo repository.Room
 }
@@ -14,9 +33,67 @@ func NewRoomService(repo repository.Room) *RoomService {
 }
 
 func (s *RoomService) CreateRoom(userId int, room model.Room) (int, error) {
+	if room.Visibility == "" {
+		room.Visibility = model.RoomPublic
+	}
+
+	if !model.ValidVisibility(room.Visibility) {
+		return 0, ErrInvalidVisibility
+	}
+
 	return s.repo.CreateRoom(userId, room)
 }
 
+// Переписка из двух человек - личная, из большего числа - групповая
+// Повторный запрос с тем же набором участников возвращает ту же переписку
+func (s *RoomService) CreateConversation(userId int, participants []int) (model.Room, error) {
+	seen := map[int]bool{userId: true}
+	ids := []int{userId}
+	for _, id := range participants {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) < 2 || len(ids) > maxConversationParticipants {
+		return model.Room{}, ErrInvalidParticipants
+	}
+
+	exist, err := s.repo.UsersExist(ids)
+	if err != nil {
+		return model.Room{}, err
+	}
+
+	if !exist {
+		return model.Room{}, ErrInvalidParticipants
+	}
+
+	kind := model.RoomKindGroup
+	if len(ids) == 2 {
+		kind = model.RoomKindDirect
+	}
+
+	room, err := s.repo.CreateConversation(userId, kind, conversationKey(ids), ids)
+	if errors.Is(err, repository.ErrParticipantBanned) {
+		return model.Room{}, ErrUserBanned
+	}
+
+	return room, err
+}
+
+func conversationKey(ids []int) string {
+	sorted := append([]int(nil), ids...)
+	sort.Ints(sorted)
+
+	parts := make([]string, 0, len(sorted))
+	for _, id := range sorted {
+		parts = append(parts, strconv.Itoa(id))
+	}
+
+	return strings.Join(parts, ",")
+}
+
 func (s *RoomService) GetAllRooms(userId int) ([]model.Room, error) {
 	return s.repo.GetAllRooms(userId)
 }
@@ -30,9 +107,155 @@ func (s *RoomService) GetRoomById(roomId int) (model.Room, error) {
 }
 
 func (s *RoomService) UpdateRoom(roomId, userId int, input model.UpdateRoomInput) error {
-	return s.repo.UpdateRoom(roomId, userId, input)
+	if _, err := authorize(s.repo, roomId, userId, model.PermEditRoom); err != nil {
+		return err
+	}
+
+	return s.repo.UpdateRoom(roomId, input)
 }
 
 func (s *RoomService) DeleteRoom(userId, roomId int) error {
-	return s.repo.DeleteRoom(userId, roomId)
+	if _, err := authorize(s.repo, roomId, userId, model.PermDeleteRoom); err != nil {
+		return err
+	}
+
+	return s.repo.DeleteRoom(roomId)
+}
+
+// Вступление без приглашения возможно только в публичную комнату
+func (s *RoomService) JoinRoom(roomId, userId int) error {
+	room, err := s.repo.GetRoomById(roomId)
+	if err != nil {
+		return err
+	}
+
+	if room.Visibility != model.RoomPublic {
+		return ErrRoomNotJoinable
+	}
+
+	return s.addMember(roomId, userId)
+}
+
+func (s *RoomService) addMember(roomId, userId int) error {
+	banned, err := s.repo.IsBanned(roomId, userId)
+	if err != nil {
+		return err
+	}
+
+	if banned {
+		return ErrUserBanned
+	}
+
+	_, err = s.repo.AddMember(roomId, userId)
+	return err
+}
+
+func (s *RoomService) LeaveRoom(roomId, userId int) error {
+	member, err := s.repo.GetMember(roomId, userId)
+	if err != nil {
+		return err
+	}
+
+	if member.Role == model.RoleOwner {
+		return ErrOwnerCannotLeave
+	}
+
+	return s.repo.RemoveMember(roomId, userId)
+}
+
+// Добавляет пользователя в комнату любой видимости от имени участника с правом приглашения
+// Состав переписки определяется при создании и приглашениями не меняется
+func (s *RoomService) InviteMember(roomId, userId, targetId int) error {
+	if _, err := authorize(s.repo, roomId, userId, model.PermInvite); err != nil {
+		return err
+	}
+
+	room, err := s.repo.GetRoomById(roomId)
+	if err != nil {
+		return err
+	}
+
+	if room.Kind != model.RoomKindRoom {
+		return ErrConversationFixed
+	}
+
+	return s.addMember(roomId, targetId)
+}
+
+func (s *RoomService) KickMember(roomId, userId, targetId int) error {
+	actor, err := authorize(s.repo, roomId, userId, model.PermKick)
+	if err != nil {
+		return err
+	}
+
+	target, err := s.repo.GetMember(roomId, targetId)
+	if err != nil {
+		return err
+	}
+
+	if !model.RoleOutranks(actor.Role, target.Role) {
+		return ErrForbidden
+	}
+
+	return s.repo.RemoveMember(roomId, targetId)
+}
+
+// Блокировать можно и пользователя, не состоящего в комнате
+func (s *RoomService) BanMember(roomId, userId, targetId int) error {
+	actor, err := authorize(s.repo, roomId, userId, model.PermBan)
+	if err != nil {
+		return err
+	}
+
+	target, err := s.repo.GetMember(roomId, targetId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if err == nil && !model.RoleOutranks(actor.Role, target.Role) {
+		return ErrForbidden
+	}
+
+	return s.repo.BanMember(roomId, targetId, userId)
+}
+
+func (s *RoomService) UnbanMember(roomId, userId, targetId int) error {
+	if _, err := authorize(s.repo, roomId, userId, model.PermBan); err != nil {
+		return err
+	}
+
+	return s.repo.UnbanMember(roomId, targetId)
+}
+
+func (s *RoomService) GetRoomBans(roomId, userId int) ([]model.RoomBan, error) {
+	if _, err := authorize(s.repo, roomId, userId, model.PermBan); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetRoomBans(roomId)
+}
+
+// Владелец комнаты единственный, передача владения не поддерживается
+func (s *RoomService) SetMemberRole(roomId, userId, targetId int, role string) (model.RoomMember, error) {
+	if !model.ValidRole(role) || role == model.RoleOwner {
+		return model.RoomMember{}, ErrInvalidRole
+	}
+
+	if _, err := authorize(s.repo, roomId, userId, model.PermManageRoles); err != nil {
+		return model.RoomMember{}, err
+	}
+
+	if userId == targetId {
+		return model.RoomMember{}, ErrForbidden
+	}
+
+	return s.repo.SetMemberRole(roomId, targetId, role)
+}
+
+func (s *RoomService) IsMember(roomId, userId int) (bool, error) {
+	return s.repo.IsMember(roomId, userId)
+}
+
+func (s *RoomService) GetRoomMembers(roomId int) ([]model.RoomMember, error) {
+	return s.repo.GetRoomMembers(roomId)
 }
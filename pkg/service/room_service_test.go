@@ -0,0 +1,162 @@
+package service
+
+import (
+	"database/sql"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Участники комнаты 1 в памяти: роль по id пользователя
+type memberRepo struct {
+	repository.Room
+	roles    map[int]string
+	banned   map[int]bool
+	roomKind string
+	created  []string
+}
+
+func newMemberRepo(roles map[int]string) *memberRepo {
+	return &memberRepo{roles: roles, banned: make(map[int]bool)}
+}
+
+func (r *memberRepo) GetMember(roomId, userId int) (model.RoomMember, error) {
+	role, ok := r.roles[userId]
+	if !ok {
+		return model.RoomMember{}, sql.ErrNoRows
+	}
+	return model.RoomMember{Room: roomId, User: userId, Role: role}, nil
+}
+
+func (r *memberRepo) GetRoomById(roomId int) (model.Room, error) {
+	return model.Room{Id: roomId, Kind: r.kind()}, nil
+}
+
+func (r *memberRepo) kind() string {
+	if r.roomKind == "" {
+		return model.RoomKindRoom
+	}
+	return r.roomKind
+}
+
+func (r *memberRepo) RemoveMember(roomId, userId int) error {
+	delete(r.roles, userId)
+	return nil
+}
+
+func (r *memberRepo) BanMember(roomId, userId, bannedBy int) error {
+	delete(r.roles, userId)
+	r.banned[userId] = true
+	return nil
+}
+
+func (r *memberRepo) IsBanned(roomId, userId int) (bool, error) {
+	return r.banned[userId], nil
+}
+
+func (r *memberRepo) AddMember(roomId, userId int) (bool, error) {
+	r.roles[userId] = model.RoleMember
+	return true, nil
+}
+
+func (r *memberRepo) SetMemberRole(roomId, userId int, role string) (model.RoomMember, error) {
+	r.roles[userId] = role
+	return model.RoomMember{Room: roomId, User: userId, Role: role}, nil
+}
+
+func TestKickMember_RequiresHigherRole(t *testing.T) {
+	repo := newMemberRepo(map[int]string{
+		1: model.RoleOwner,
+		2: model.RoleModerator,
+		3: model.RoleModerator,
+		4: model.RoleMember,
+	})
+	s := NewRoomService(repo)
+
+	assert.ErrorIs(t, s.KickMember(1, 4, 3), ErrForbidden, "member cannot kick")
+	assert.ErrorIs(t, s.KickMember(1, 2, 3), ErrForbidden, "moderator cannot kick an equal")
+	assert.ErrorIs(t, s.KickMember(1, 2, 1), ErrForbidden, "moderator cannot kick the owner")
+	assert.NoError(t, s.KickMember(1, 2, 4))
+	assert.NoError(t, s.KickMember(1, 1, 3))
+	assert.NotContains(t, repo.roles, 3)
+	assert.NotContains(t, repo.roles, 4)
+}
+
+func TestBanMember_BlocksInvite(t *testing.T) {
+	repo := newMemberRepo(map[int]string{1: model.RoleOwner, 2: model.RoleMember})
+	s := NewRoomService(repo)
+
+	assert.NoError(t, s.BanMember(1, 1, 2))
+	assert.ErrorIs(t, s.InviteMember(1, 1, 2), ErrUserBanned)
+	assert.NoError(t, s.InviteMember(1, 1, 5))
+	assert.Equal(t, model.RoleMember, repo.roles[5])
+}
+
+func TestSetMemberRole(t *testing.T) {
+	repo := newMemberRepo(map[int]string{1: model.RoleOwner, 2: model.RoleModerator, 3: model.RoleMember})
+	s := NewRoomService(repo)
+
+	_, err := s.SetMemberRole(1, 2, 3, model.RoleModerator)
+	assert.ErrorIs(t, err, ErrForbidden, "only the owner manages roles")
+
+	_, err = s.SetMemberRole(1, 1, 3, model.RoleOwner)
+	assert.ErrorIs(t, err, ErrInvalidRole)
+
+	member, err := s.SetMemberRole(1, 1, 3, model.RoleReadOnly)
+	assert.NoError(t, err)
+	assert.Equal(t, model.RoleReadOnly, member.Role)
+}
+
+func (r *memberRepo) UsersExist(userIds []int) (bool, error) {
+	return true, nil
+}
+
+func (r *memberRepo) CreateConversation(userId int, kind, participantKey string, participants []int) (model.Room, error) {
+	for _, participant := range participants {
+		if r.banned[participant] {
+			return model.Room{}, repository.ErrParticipantBanned
+		}
+	}
+	r.created = append(r.created, kind+":"+participantKey)
+	return model.Room{Kind: kind}, nil
+}
+
+func TestCreateConversation_DedupesParticipants(t *testing.T) {
+	repo := newMemberRepo(map[int]string{})
+	s := NewRoomService(repo)
+
+	_, err := s.CreateConversation(1, []int{1})
+	assert.ErrorIs(t, err, ErrInvalidParticipants, "conversation with oneself")
+
+	room, err := s.CreateConversation(5, []int{2, 2, 5})
+	assert.NoError(t, err)
+	assert.Equal(t, model.RoomKindDirect, room.Kind)
+
+	room, err = s.CreateConversation(3, []int{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, model.RoomKindGroup, room.Kind)
+
+	_, err = s.CreateConversation(1, []int{3, 2})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"direct:2,5", "group:1,2,3", "group:1,2,3"}, repo.created)
+}
+
+func TestCreateConversation_RejectsBannedParticipant(t *testing.T) {
+	repo := newMemberRepo(map[int]string{})
+	repo.banned[2] = true
+	s := NewRoomService(repo)
+
+	_, err := s.CreateConversation(1, []int{2})
+	assert.ErrorIs(t, err, ErrUserBanned)
+	assert.Empty(t, repo.created)
+}
+
+func TestInviteMember_ConversationFixed(t *testing.T) {
+	repo := newMemberRepo(map[int]string{1: model.RoleMember, 2: model.RoleMember})
+	repo.roomKind = model.RoomKindDirect
+	s := NewRoomService(repo)
+
+	assert.ErrorIs(t, s.InviteMember(1, 1, 3), ErrConversationFixed)
+}
@@ -1,25 +1,57 @@
 package service
 
 import (
+	"context"
 	"github.com/firstproject/talk-together-app/model"
 	"github.com/firstproject/talk-together-app/pkg/kafka"
 	"github.com/firstproject/talk-together-app/pkg/redis"
 	"github.com/firstproject/talk-together-app/pkg/repository"
+	"github.com/firstproject/talk-together-app/pkg/storage"
+	"io"
+	"time"
 )
 
 type Authorization interface {
 	CreateUser(user model.User) (int, error)
-	GenerateToken(userName, password string) (string, error)
-	ParseToken(token string) (int, error)
+	GenerateToken(userName, password string) (model.SignInResult, error)
+	VerifyTwoFactor(challengeToken, code string) (model.TokenPair, error)
+	RefreshToken(refreshToken string) (model.TokenPair, error)
+	ParseToken(token string) (model.AccessToken, error)
+	Logout(token model.AccessToken) error
+	LogoutAll(userId int) error
+	JWKS() model.JSONWebKeySet
+	EnrollTwoFactor(userId int) (model.TwoFactorEnrollment, error)
+	ConfirmTwoFactor(userId int, code string) (model.RecoveryCodes, error)
+	RegenerateRecoveryCodes(userId int, code string) (model.RecoveryCodes, error)
+	DisableTwoFactor(userId int, code string) error
+	ResetTwoFactor(adminId, userId int) error
+}
+
+type OIDC interface {
+	Providers() []model.OIDCProvider
+	StartLogin(ctx context.Context, provider string) (string, string, error)
+	StartLink(ctx context.Context, provider string, userId int) (string, string, error)
+	CompleteLogin(ctx context.Context, provider, code, state string) (model.SignInResult, error)
 }
 
 type Room interface {
 	CreateRoom(userId int, room model.Room) (int, error)
+	CreateConversation(userId int, participants []int) (model.Room, error)
 	GetAllRooms(userId int) ([]model.Room, error)
 	SearchRoomByName(name string) ([]model.Room, error)
 	GetRoomById(roomId int) (model.Room, error)
 	UpdateRoom(roomId, userId int, input model.UpdateRoomInput) error
 	DeleteRoom(userId, roomId int) error
+	JoinRoom(roomId, userId int) error
+	LeaveRoom(roomId, userId int) error
+	IsMember(roomId, userId int) (bool, error)
+	GetRoomMembers(roomId int) ([]model.RoomMember, error)
+	InviteMember(roomId, userId, targetId int) error
+	KickMember(roomId, userId, targetId int) error
+	BanMember(roomId, userId, targetId int) error
+	UnbanMember(roomId, userId, targetId int) error
+	GetRoomBans(roomId, userId int) ([]model.RoomBan, error)
+	SetMemberRole(roomId, userId, targetId int, role string) (model.RoomMember, error)
 }
 
 type Client interface {
@@ -29,27 +61,103 @@ type Client interface {
 }
 
 type Message interface {
-	CreateMessage(roomId, userId int, content string) (int, error)
+	CreateMessage(roomId, userId int, content string, attachmentIds []int) (int, error)
+	CreateReply(roomId, parentId, userId int, content string, attachmentIds []int) (int, error)
 	GetRoomMessages(roomId int) ([]model.Message, error)
+	GetRoomMessagesPage(roomId int, page model.MessagePageQuery) (model.MessagePage, error)
+	GetThreadPage(parentId, userId int, page model.MessagePageQuery) (model.ThreadPage, error)
 	DeleteMessage(messageId, userId int) error
 	UpdateMessage(messageId, userId int, content string) error
+	GetMessageById(messageId int) (model.Message, error)
+	GetMessageRevisions(messageId, userId int) ([]model.MessageRevision, error)
+	PinMessage(messageId, userId int) error
+	UnpinMessage(messageId, userId int) error
+	GetPinnedMessages(roomId int) ([]model.PinnedMessage, error)
+	AddReaction(messageId, userId int, emoji string) error
+	RemoveReaction(messageId, userId int, emoji string) error
+	SearchMessages(userId int, search model.MessageSearchQuery) (model.MessageSearchPage, error)
+}
+
+type Ticket interface {
+	IssueTicket(userId, roomId int, session string) (string, time.Duration, error)
+	RedeemTicket(ticket string, roomId int) (int, string, error)
+}
+
+type Presence interface {
+	Connect(roomId, userId int) (model.Presence, bool, error)
+	Disconnect(roomId, userId int) (model.Presence, bool, error)
+	Heartbeat(roomId, userId int) (model.Presence, bool, error)
+	SetStatus(roomId, userId int, status string) (model.Presence, bool, error)
+	GetRoomPresence(roomId int) ([]model.Presence, error)
+	SweepExpired() ([]model.Presence, error)
+}
+
+type Receipt interface {
+	MarkRead(userId, roomId, messageId int) (model.ReadReceipt, error)
+	GetRoomReceipts(roomId int) ([]model.ReadReceipt, error)
+	GetUnreadCounts(userId int, roomIds []int) (map[int]int, error)
+}
+
+type Invite interface {
+	CreateInvite(roomId, userId int, input model.CreateInviteInput) (model.RoomInvite, error)
+	GetRoomInvites(roomId, userId int) ([]model.RoomInvite, error)
+	RevokeInvite(roomId, userId int, code string) error
+	PreviewInvite(code string) (model.InvitePreview, error)
+	AcceptInvite(code string, userId int) (int, error)
+}
+
+type Attachment interface {
+	MaxSize() int64
+	Upload(ctx context.Context, roomId, userId int, filename string, size int64, r io.Reader) (model.Attachment, error)
+	StartUpload(roomId, userId int, input model.CreateUploadInput) (model.AttachmentUpload, error)
+	UploadChunk(ctx context.Context, uploadId string, userId int, offset, length int64, r io.Reader) (model.AttachmentUpload, error)
+	CompleteUpload(ctx context.Context, uploadId string, userId int) (model.Attachment, error)
+	GetAttachment(attachmentId, userId int) (model.Attachment, error)
+	OpenAttachment(ctx context.Context, attachmentId int, variant string, expires int64, signature string) (io.ReadCloser, model.Attachment, string, error)
+	RunCleanup(ctx context.Context)
 }
 
 type Service struct {
 	Authorization
+	OIDC
 	Client
 	Room
 	Message
+	Ticket
+	Presence
+	Receipt
+	Invite
+	Attachment
 	Redis *redis.Client
 	Kafka *kafka.Producer
 }
 
-func NewService(repos *repository.Repository, redisClient *redis.Client, kafkaProducer *kafka.Producer) *Service {
+// Настройки сервисов, задаваемые конфигурацией приложения
+type Config struct {
+	Attachments AttachmentConfig
+	Passwords   PasswordConfig
+	Tokens      TokenConfig
+	TwoFactor   TwoFactorConfig
+	SigningKeys *KeySet
+	OIDC        []OIDCProviderConfig
+}
+
+func NewService(repos *repository.Repository, redisClient *redis.Client, kafkaProducer *kafka.Producer, blobs storage.BlobStore, cfg Config) *Service {
+	auth := NewAuthService(repos.Authorization, repos.Token, repos.TwoFactor, redisClient, redisClient, cfg.SigningKeys, cfg.Passwords, cfg.Tokens, cfg.TwoFactor)
+	receipts := NewReceiptService(repos.Receipt, redisClient)
+	attachments := NewAttachmentService(repos.Attachment, repos.Room, blobs, cfg.Attachments)
+
 	return &Service{
-		Authorization: NewAuthService(repos.Authorization),
+		Authorization: auth,
+		OIDC:          NewOIDCService(repos.Identity, redisClient, auth, cfg.OIDC),
 		Room:          NewRoomService(repos.Room),
-		Message:       NewMessageService(repos.Message, kafkaProducer),
+		Message:       NewMessageService(repos.Message, repos.Room, redisClient, receipts, attachments),
 		Client:        NewClientService(repos.Client),
+		Ticket:        NewTicketService(redisClient),
+		Presence:      NewPresenceService(redisClient),
+		Receipt:       receipts,
+		Invite:        NewInviteService(repos.Invite, repos.Room),
+		Attachment:    attachments,
 		Redis:         redisClient,
 		Kafka:         kafkaProducer,
 	}
@@ -0,0 +1,325 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/firstproject/talk-together-app/model"
+	"math/big"
+)
+
+// Поддерживаемые алгоритмы подписи access токенов
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// Минимальные размеры ключей: короче подпись считается небезопасной
+const (
+	minHMACSecretLength = 32
+	minRSAKeyBits       = 2048
+)
+
+var ErrUnknownSigningKey = errors.New("token is signed with an unknown key")
+
+// jwt-go v3 не поддерживает EdDSA, поэтому алгоритм регистрируется здесь
+func init() {
+	jwt.RegisterSigningMethod(AlgorithmEdDSA, func() jwt.SigningMethod {
+		return signingMethodEdDSA{}
+	})
+}
+
+// Описание ключа из конфигурации
+// Для HS256 задается Secret, для асимметричных алгоритмов - PEM приватного ключа,
+// либо только публичного, если ключ выведен из ротации и остается лишь для проверки
+type SigningKeyConfig struct {
+	Id            string
+	Algorithm     string
+	Secret        []byte
+	PrivateKeyPEM []byte
+	PublicKeyPEM  []byte
+}
+
+type signingKey struct {
+	id        string
+	algorithm string
+	// []byte для HS256, иначе приватный ключ; nil у ключей только для проверки
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// Набор ключей подписи: новые токены подписываются активным ключом,
+// проверка выбирает ключ по kid, поэтому при ротации старые токены остаются действительными
+type KeySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+	order  []string
+}
+
+func NewKeySet(activeId string, configs []SigningKeyConfig) (*KeySet, error) {
+	set := &KeySet{keys: make(map[string]*signingKey, len(configs))}
+
+	for _, cfg := range configs {
+		if cfg.Id == "" {
+			return nil, errors.New("signing key id is required")
+		}
+		if _, exists := set.keys[cfg.Id]; exists {
+			return nil, fmt.Errorf("duplicate signing key id %q", cfg.Id)
+		}
+
+		key, err := parseSigningKey(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", cfg.Id, err)
+		}
+
+		set.keys[cfg.Id] = key
+		set.order = append(set.order, cfg.Id)
+	}
+
+	set.active = set.keys[activeId]
+	if set.active == nil {
+		return nil, fmt.Errorf("active signing key %q is not configured", activeId)
+	}
+	if set.active.signKey == nil {
+		return nil, fmt.Errorf("active signing key %q has no private key", activeId)
+	}
+
+	return set, nil
+}
+
+func parseSigningKey(cfg SigningKeyConfig) (*signingKey, error) {
+	key := &signingKey{id: cfg.Id, algorithm: cfg.Algorithm}
+
+	switch cfg.Algorithm {
+	case AlgorithmHS256:
+		if len(cfg.Secret) < minHMACSecretLength {
+			return nil, fmt.Errorf("HS256 secret must be at least %d bytes", minHMACSecretLength)
+		}
+		key.signKey, key.verifyKey = cfg.Secret, cfg.Secret
+		return key, nil
+	case AlgorithmRS256, AlgorithmEdDSA:
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", cfg.Algorithm)
+	}
+
+	var public crypto.PublicKey
+	if len(cfg.PrivateKeyPEM) > 0 {
+		private, err := parsePrivateKeyPEM(cfg.PrivateKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		key.signKey, public = private, private.Public()
+	} else if len(cfg.PublicKeyPEM) > 0 {
+		var err error
+		if public, err = parsePublicKeyPEM(cfg.PublicKeyPEM); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New("private or public key is required")
+	}
+
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		if cfg.Algorithm != AlgorithmRS256 {
+			return nil, errors.New("RSA key can only be used with RS256")
+		}
+		if public.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA key must be at least %d bits", minRSAKeyBits)
+		}
+		key.verifyKey = public
+	case ed25519.PublicKey:
+		if cfg.Algorithm != AlgorithmEdDSA {
+			return nil, errors.New("Ed25519 key can only be used with EdDSA")
+		}
+		key.verifyKey = public
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+
+	return key, nil
+}
+
+func parsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid private key PEM")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
+
+func parsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid public key PEM")
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// Подписывает claims активным ключом и указывает его kid в заголовке
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(k.active.algorithm), claims)
+	token.Header["kid"] = k.active.id
+
+	return token.SignedString(k.active.signKey)
+}
+
+// Выбирает ключ проверки по kid; алгоритм токена обязан совпадать с алгоритмом ключа,
+// иначе публичный RSA ключ можно было бы подсунуть как секрет HS256
+func (k *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, ErrUnknownSigningKey
+	}
+
+	if token.Method.Alg() != key.algorithm {
+		return nil, errors.New("invalid signing method")
+	}
+
+	return key.verifyKey, nil
+}
+
+// Публичные ключи в формате JWK, секреты HS256 не публикуются
+func (k *KeySet) JWKS() model.JSONWebKeySet {
+	set := model.JSONWebKeySet{Keys: []model.JSONWebKey{}}
+
+	for _, id := range k.order {
+		key := k.keys[id]
+		jwk := model.JSONWebKey{Kid: key.id, Alg: key.algorithm, Use: "sig"}
+
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// Разбирает публичный ключ из JWK: RSA, EC P-256 или Ed25519
+func publicKeyFromJWK(jwk model.JSONWebKey) (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return key, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+// Реализация jwt.SigningMethod для Ed25519
+type signingMethodEdDSA struct{}
+
+func (signingMethodEdDSA) Alg() string {
+	return AlgorithmEdDSA
+}
+
+func (signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(public, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	sig, err := private.Sign(rand.Reader, []byte(signingString), crypto.Hash(0))
+	if err != nil {
+		return "", err
+	}
+	return jwt.EncodeSegment(sig), nil
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func testKeySet(t *testing.T) *KeySet {
+	keys, err := NewKeySet("test", []SigningKeyConfig{
+		{Id: "test", Algorithm: AlgorithmHS256, Secret: []byte(strings.Repeat("s", minHMACSecretLength))},
+	})
+	require.NoError(t, err)
+	return keys
+}
+
+func privateKeyPEM(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func publicKeyPEM(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func parseWith(keys *KeySet, token string) error {
+	_, err := jwt.ParseWithClaims(token, &tokenClaims{}, keys.Keyfunc)
+	return err
+}
+
+func TestKeySet_SignAndVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	configs := []SigningKeyConfig{
+		{Id: "rsa", Algorithm: AlgorithmRS256, PrivateKeyPEM: privateKeyPEM(t, rsaKey)},
+		{Id: "ed", Algorithm: AlgorithmEdDSA, PrivateKeyPEM: privateKeyPEM(t, edKey)},
+	}
+
+	for _, cfg := range configs {
+		keys, err := NewKeySet(cfg.Id, configs)
+		require.NoError(t, err)
+
+		token, err := keys.Sign(&tokenClaims{UserId: 1, Session: "s"})
+		require.NoError(t, err)
+
+		parsed, err := jwt.ParseWithClaims(token, &tokenClaims{}, keys.Keyfunc)
+		require.NoError(t, err, cfg.Id)
+		assert.Equal(t, cfg.Id, parsed.Header["kid"])
+		assert.Equal(t, cfg.Algorithm, parsed.Method.Alg())
+		assert.Equal(t, 1, parsed.Claims.(*tokenClaims).UserId)
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	_, oldKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, newKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	before, err := NewKeySet("old", []SigningKeyConfig{
+		{Id: "old", Algorithm: AlgorithmEdDSA, PrivateKeyPEM: privateKeyPEM(t, oldKey)},
+	})
+	require.NoError(t, err)
+	oldToken, err := before.Sign(&tokenClaims{UserId: 1})
+	require.NoError(t, err)
+
+	// Старый ключ оставлен только для проверки
+	after, err := NewKeySet("new", []SigningKeyConfig{
+		{Id: "new", Algorithm: AlgorithmEdDSA, PrivateKeyPEM: privateKeyPEM(t, newKey)},
+		{Id: "old", Algorithm: AlgorithmEdDSA, PublicKeyPEM: publicKeyPEM(t, oldKey.Public())},
+	})
+	require.NoError(t, err)
+
+	assert.NoError(t, parseWith(after, oldToken))
+
+	newToken, err := after.Sign(&tokenClaims{UserId: 1})
+	require.NoError(t, err)
+	assert.NoError(t, parseWith(after, newToken))
+
+	// После удаления ключа его токены отклоняются
+	assert.Error(t, parseWith(before, newToken))
+
+	_, err = NewKeySet("old", []SigningKeyConfig{
+		{Id: "old", Algorithm: AlgorithmEdDSA, PublicKeyPEM: publicKeyPEM(t, oldKey.Public())},
+	})
+	assert.Error(t, err)
+}
+
+func TestKeySet_RejectsAlgorithmMismatch(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	keys, err := NewKeySet("rsa", []SigningKeyConfig{
+		{Id: "rsa", Algorithm: AlgorithmRS256, PrivateKeyPEM: privateKeyPEM(t, rsaKey)},
+	})
+	require.NoError(t, err)
+
+	// Публичный ключ, использованный как секрет HS256
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &tokenClaims{UserId: 1})
+	forged.Header["kid"] = "rsa"
+	token, err := forged.SignedString(publicKeyPEM(t, rsaKey.Public()))
+	require.NoError(t, err)
+	assert.Error(t, parseWith(keys, token))
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodHS256, &tokenClaims{UserId: 1})
+	token, err = unsigned.SignedString([]byte(strings.Repeat("s", minHMACSecretLength)))
+	require.NoError(t, err)
+	assert.Error(t, parseWith(keys, token))
+}
+
+func TestNewKeySet_Validation(t *testing.T) {
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	cases := map[string][]SigningKeyConfig{
+		"short secret":   {{Id: "k", Algorithm: AlgorithmHS256, Secret: []byte("short")}},
+		"small rsa key":  {{Id: "k", Algorithm: AlgorithmRS256, PrivateKeyPEM: privateKeyPEM(t, smallKey)}},
+		"wrong key type": {{Id: "k", Algorithm: AlgorithmRS256, PrivateKeyPEM: privateKeyPEM(t, edKey)}},
+		"unknown alg":    {{Id: "k", Algorithm: "none", Secret: []byte(strings.Repeat("s", 32))}},
+		"no key":         {{Id: "k", Algorithm: AlgorithmEdDSA}},
+		"missing active": {},
+	}
+
+	for name, configs := range cases {
+		_, err := NewKeySet("k", configs)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestKeySet_JWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	keys, err := NewKeySet("hs", []SigningKeyConfig{
+		{Id: "hs", Algorithm: AlgorithmHS256, Secret: []byte(strings.Repeat("s", 32))},
+		{Id: "rsa", Algorithm: AlgorithmRS256, PrivateKeyPEM: privateKeyPEM(t, rsaKey)},
+		{Id: "ed", Algorithm: AlgorithmEdDSA, PrivateKeyPEM: privateKeyPEM(t, edKey)},
+	})
+	require.NoError(t, err)
+
+	set := keys.JWKS()
+	require.Len(t, set.Keys, 2)
+
+	assert.Equal(t, "rsa", set.Keys[0].Kid)
+	assert.Equal(t, "RSA", set.Keys[0].Kty)
+	assert.Equal(t, "AQAB", set.Keys[0].E)
+
+	assert.Equal(t, "ed", set.Keys[1].Kid)
+	assert.Equal(t, "OKP", set.Keys[1].Kty)
+	assert.Equal(t, "Ed25519", set.Keys[1].Crv)
+	assert.Equal(t, []byte(edPublic), mustDecodeSegment(t, set.Keys[1].X))
+}
+
+func mustDecodeSegment(t *testing.T, s string) []byte {
+	b, err := jwt.DecodeSegment(s)
+	require.NoError(t, err)
+	return b
+}
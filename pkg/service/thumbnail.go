@@ -0,0 +1,101 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+const (
+	// Наибольшая сторона миниатюры в пикселях
+	thumbnailSide = 320
+	// Изображения больше этого числа пикселей не декодируются, чтобы не исчерпать память
+	maxThumbnailSourcePixels = 40_000_000
+)
+
+var errThumbnailUnsupported = errors.New("image cannot be thumbnailed")
+
+// Типы изображений, для которых строятся миниатюры
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Уменьшает изображение усреднением пикселей, миниатюры JPEG кодируются в JPEG, остальные в PNG
+func makeThumbnail(r io.Reader, contentType string) ([]byte, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width*config.Height > maxThumbnailSourcePixels {
+		return nil, "", errThumbnailUnsupported
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", errThumbnailUnsupported
+	}
+
+	thumb := downscale(src, thumbnailSide)
+
+	var buf bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+		return buf.Bytes(), "image/jpeg", err
+	}
+
+	err = png.Encode(&buf, thumb)
+	return buf.Bytes(), "image/png", err
+}
+
+// Вписывает изображение в квадрат side x side с сохранением пропорций
+func downscale(src image.Image, side int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	dstWidth, dstHeight := width, height
+	if width > side || height > side {
+		if width >= height {
+			dstWidth, dstHeight = side, max(1, height*side/width)
+		} else {
+			dstWidth, dstHeight = max(1, width*side/height), side
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0 := bounds.Min.Y + y*height/dstHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/dstHeight)
+
+		for x := 0; x < dstWidth; x++ {
+			x0 := bounds.Min.X + x*width/dstWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/dstWidth)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/firstproject/talk-together-app/pkg/redis"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	wsTicketTTL    = 30 * time.Second
+	wsTicketPrefix = "ws-ticket:"
+)
+
+var ErrInvalidTicket = errors.New("invalid or expired ticket")
+
+type TicketService struct {
+	redis *redis.Client
+}
+
+func NewTicketService(redisClient *redis.Client) *TicketService {
+	return &TicketService{redis: redisClient}
+}
+
+// Тикет запоминает сессию токена, чтобы соединение закрылось при ее отзыве
+func (s *TicketService) IssueTicket(userId, roomId int, session string) (string, time.Duration, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", 0, err
+	}
+	ticket := hex.EncodeToString(buf)
+
+	value := fmt.Sprintf("%d:%d:%s", userId, roomId, session)
+	if err := s.redis.Set(wsTicketPrefix+ticket, value, wsTicketTTL); err != nil {
+		return "", 0, err
+	}
+
+	return ticket, wsTicketTTL, nil
+}
+
+// Возвращает пользователя и сессию, для которых выдан тикет
+func (s *TicketService) RedeemTicket(ticket string, roomId int) (int, string, error) {
+	value, err := s.redis.GetDel(wsTicketPrefix + ticket)
+	if err != nil {
+		return 0, "", ErrInvalidTicket
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return 0, "", ErrInvalidTicket
+	}
+
+	userId, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", ErrInvalidTicket
+	}
+
+	ticketRoomId, err := strconv.Atoi(parts[1])
+	if err != nil || ticketRoomId != roomId {
+		return 0, "", ErrInvalidTicket
+	}
+
+	return userId, parts[2], nil
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"errors"
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	twoFactorChallengePrefix = "2fa-challenge:"
+	twoFactorAttemptsPrefix  = "2fa-attempts:"
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotPending  = errors.New("two-factor enrollment has not been started")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	ErrInvalidChallenge     = errors.New("invalid or expired two-factor challenge")
+	ErrTooManyAttempts      = errors.New("too many failed two-factor attempts, try again later")
+	ErrNotAdmin             = errors.New("administrator rights required")
+)
+
+// Настройки второго фактора: Issuer показывается в приложении-аутентификаторе,
+// после MaxAttempts неудачных кодов проверка блокируется до конца LockoutWindow
+type TwoFactorConfig struct {
+	Issuer        string
+	ChallengeTTL  time.Duration
+	MaxAttempts   int
+	LockoutWindow time.Duration
+}
+
+func (c TwoFactorConfig) withDefaults() TwoFactorConfig {
+	if c.Issuer == "" {
+		c.Issuer = "Talk Together"
+	}
+	if c.ChallengeTTL == 0 {
+		c.ChallengeTTL = 5 * time.Minute
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = 5
+	}
+	if c.LockoutWindow == 0 {
+		c.LockoutWindow = 15 * time.Minute
+	}
+	return c
+}
+
+// Вызовы второго шага входа и счетчики неудачных попыток, реализуется redis.Client
+type twoFactorStore interface {
+	Set(key string, value interface{}, expiration time.Duration) error
+	Get(key string) (string, error)
+	GetDel(key string) (string, error)
+	Del(key string) error
+	Incr(key string, expiration time.Duration) (int64, error)
+}
+
+// Начинает подключение: новый секрет действует только после подтверждения кодом
+func (s *AuthService) EnrollTwoFactor(userId int) (model.TwoFactorEnrollment, error) {
+	current, err := s.twoFactor.GetTwoFactor(userId)
+	if err != nil {
+		return model.TwoFactorEnrollment{}, err
+	}
+	if current.Enabled() {
+		return model.TwoFactorEnrollment{}, ErrTwoFactorEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return model.TwoFactorEnrollment{}, err
+	}
+
+	pending, err := s.twoFactor.SetPendingTwoFactor(userId, secret)
+	if err != nil {
+		return model.TwoFactorEnrollment{}, err
+	}
+	if !pending {
+		return model.TwoFactorEnrollment{}, ErrTwoFactorEnabled
+	}
+
+	return model.TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totpURI(s.twoFactorCfg.Issuer, current.Username, secret),
+	}, nil
+}
+
+// Подтверждает подключение кодом из приложения и выдает коды восстановления
+func (s *AuthService) ConfirmTwoFactor(userId int, code string) (model.RecoveryCodes, error) {
+	current, err := s.twoFactor.GetTwoFactor(userId)
+	if err != nil {
+		return model.RecoveryCodes{}, err
+	}
+	if current.Enabled() {
+		return model.RecoveryCodes{}, ErrTwoFactorEnabled
+	}
+	if current.Secret == "" {
+		return model.RecoveryCodes{}, ErrTwoFactorNotPending
+	}
+
+	step, ok := validateTOTP(current.Secret, compactCode(code), s.now())
+	if !ok {
+		return model.RecoveryCodes{}, ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return model.RecoveryCodes{}, err
+	}
+
+	// Секрет мог смениться повторным началом подключения
+	enabled, err := s.twoFactor.EnableTwoFactor(userId, current.Secret, step, hashes)
+	if err != nil {
+		return model.RecoveryCodes{}, err
+	}
+	if !enabled {
+		return model.RecoveryCodes{}, ErrTwoFactorNotPending
+	}
+
+	return model.RecoveryCodes{Codes: codes}, nil
+}
+
+// Новые коды восстановления заменяют старые, подтверждается только кодом из приложения
+func (s *AuthService) RegenerateRecoveryCodes(userId int, code string) (model.RecoveryCodes, error) {
+	current, err := s.enabledTwoFactor(userId)
+	if err != nil {
+		return model.RecoveryCodes{}, err
+	}
+
+	if err := s.verifyCode(current, code, false); err != nil {
+		return model.RecoveryCodes{}, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return model.RecoveryCodes{}, err
+	}
+
+	if err := s.twoFactor.ReplaceRecoveryCodes(userId, hashes); err != nil {
+		return model.RecoveryCodes{}, err
+	}
+
+	return model.RecoveryCodes{Codes: codes}, nil
+}
+
+// Пользователь отключает второй фактор сам, подтверждая кодом из приложения или кодом восстановления
+// Все сессии завершаются: код восстановления мог использовать тот, кто украл пароль и коды
+func (s *AuthService) DisableTwoFactor(userId int, code string) error {
+	current, err := s.enabledTwoFactor(userId)
+	if err != nil {
+		return err
+	}
+
+	if err := s.verifyCode(current, code, true); err != nil {
+		return err
+	}
+
+	if err := s.twoFactor.DisableTwoFactor(userId); err != nil {
+		return err
+	}
+
+	return s.LogoutAll(userId)
+}
+
+// Сброс администратором, когда пользователь потерял и устройство, и коды восстановления
+// Сессии с потерянного устройства завершаются вместе со сбросом
+func (s *AuthService) ResetTwoFactor(adminId, userId int) error {
+	isAdmin, err := s.repo.IsAdmin(adminId)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return ErrNotAdmin
+	}
+
+	if err := s.twoFactor.DisableTwoFactor(userId); err != nil {
+		return err
+	}
+	if err := s.LogoutAll(userId); err != nil {
+		return err
+	}
+
+	logrus.Infof("two-factor authentication of user %d reset by admin %d", userId, adminId)
+	return nil
+}
+
+// Первый шаг входа при включенной 2FA: вместо токенов выдается вызов для второго шага
+func (s *AuthService) newTwoFactorChallenge(userId int) (model.SignInResult, error) {
+	challenge, err := randomToken()
+	if err != nil {
+		return model.SignInResult{}, err
+	}
+
+	expiresAt := time.Now().Add(s.twoFactorCfg.ChallengeTTL)
+	if err := s.challenges.Set(twoFactorChallengePrefix+challenge, challengeValue(userId, expiresAt), s.twoFactorCfg.ChallengeTTL); err != nil {
+		return model.SignInResult{}, err
+	}
+
+	return model.SignInResult{TwoFactorChallenge: &model.TwoFactorChallenge{
+		TwoFactorRequired:  true,
+		ChallengeToken:     challenge,
+		ChallengeExpiresIn: int(s.twoFactorCfg.ChallengeTTL.Seconds()),
+	}}, nil
+}
+
+// Второй шаг входа: принимает код из приложения или код восстановления
+// Вызов действует до успешной проверки или истечения срока, число ошибок ограничено на пользователя
+// Вызов забирается до проверки кода: из одновременных запросов код проверяет и тратит только один,
+// при неверном коде вызов возвращается с прежним сроком
+func (s *AuthService) VerifyTwoFactor(challengeToken, code string) (model.TokenPair, error) {
+	key := twoFactorChallengePrefix + challengeToken
+	value, err := s.challenges.GetDel(key)
+	if err != nil {
+		return model.TokenPair{}, ErrInvalidChallenge
+	}
+
+	userId, expiresAt, err := parseChallengeValue(value)
+	if err != nil {
+		return model.TokenPair{}, ErrInvalidChallenge
+	}
+
+	current, err := s.enabledTwoFactor(userId)
+	if errors.Is(err, ErrTwoFactorNotEnabled) {
+		return model.TokenPair{}, ErrInvalidChallenge
+	}
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	if err := s.verifyCode(current, code, true); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) || errors.Is(err, ErrTooManyAttempts) {
+			s.restoreChallenge(key, value, expiresAt)
+		}
+		return model.TokenPair{}, err
+	}
+
+	return s.startSession(userId)
+}
+
+// Если вызов не удалось вернуть, пользователь входит заново
+func (s *AuthService) restoreChallenge(key, value string, expiresAt time.Time) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return
+	}
+
+	if err := s.challenges.Set(key, value, ttl); err != nil {
+		logrus.Errorf("failed to restore two-factor challenge: %s", err.Error())
+	}
+}
+
+// Вместе с пользователем хранится срок вызова, чтобы возврат вызова не продлевал его
+func challengeValue(userId int, expiresAt time.Time) string {
+	return strconv.Itoa(userId) + ":" + strconv.FormatInt(expiresAt.Unix(), 10)
+}
+
+func parseChallengeValue(value string) (int, time.Time, error) {
+	user, expires, found := strings.Cut(value, ":")
+	if !found {
+		return 0, time.Time{}, ErrInvalidChallenge
+	}
+
+	userId, err := strconv.Atoi(user)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return userId, time.Unix(unix, 0), nil
+}
+
+func (s *AuthService) enabledTwoFactor(userId int) (model.TwoFactor, error) {
+	current, err := s.twoFactor.GetTwoFactor(userId)
+	if err != nil {
+		return model.TwoFactor{}, err
+	}
+	if !current.Enabled() {
+		return model.TwoFactor{}, ErrTwoFactorNotEnabled
+	}
+	return current, nil
+}
+
+// Проверяет код с ограничением числа попыток; при блокировке отклоняется и верный код
+// Попытка засчитывается до проверки, иначе одновременные запросы обходили бы лимит
+func (s *AuthService) verifyCode(current model.TwoFactor, code string, allowRecovery bool) error {
+	attemptsKey := twoFactorAttemptsPrefix + strconv.Itoa(current.User)
+	attempts, err := s.challenges.Incr(attemptsKey, s.twoFactorCfg.LockoutWindow)
+	if err != nil {
+		return err
+	}
+	if attempts > int64(s.twoFactorCfg.MaxAttempts) {
+		return ErrTooManyAttempts
+	}
+
+	ok, err := s.matchCode(current, code, allowRecovery)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return s.challenges.Del(attemptsKey)
+}
+
+// Код TOTP принимается один раз, код восстановления погашается при использовании
+func (s *AuthService) matchCode(current model.TwoFactor, code string, allowRecovery bool) (bool, error) {
+	if totp := compactCode(code); isTOTPCode(totp) {
+		step, ok := validateTOTP(current.Secret, totp, s.now())
+		if !ok {
+			return false, nil
+		}
+		return s.twoFactor.UseTotpStep(current.User, step)
+	}
+
+	if !allowRecovery {
+		return false, nil
+	}
+	return s.twoFactor.UseRecoveryCode(current.User, hashToken(normalizeRecoveryCode(code)))
+}
+
+func compactCode(code string) string {
+	return strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"github.com/firstproject/talk-together-app/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type twoFactorRepo struct {
+	states   map[int]model.TwoFactor
+	recovery map[int]map[string]bool
+}
+
+func newTwoFactorRepo() *twoFactorRepo {
+	return &twoFactorRepo{states: make(map[int]model.TwoFactor), recovery: make(map[int]map[string]bool)}
+}
+
+func (r *twoFactorRepo) GetTwoFactor(userId int) (model.TwoFactor, error) {
+	state := r.states[userId]
+	state.User, state.Username = userId, "alice"
+	return state, nil
+}
+
+func (r *twoFactorRepo) SetPendingTwoFactor(userId int, secret string) (bool, error) {
+	if r.states[userId].Enabled() {
+		return false, nil
+	}
+	r.states[userId] = model.TwoFactor{Secret: secret}
+	return true, nil
+}
+
+func (r *twoFactorRepo) EnableTwoFactor(userId int, secret string, step int64, codeHashes []string) (bool, error) {
+	state := r.states[userId]
+	if state.Enabled() || state.Secret != secret {
+		return false, nil
+	}
+
+	now := time.Now()
+	state.EnabledAt, state.LastStep = &now, step
+	r.states[userId] = state
+	return true, r.ReplaceRecoveryCodes(userId, codeHashes)
+}
+
+func (r *twoFactorRepo) UseTotpStep(userId int, step int64) (bool, error) {
+	state := r.states[userId]
+	if !state.Enabled() || state.LastStep >= step {
+		return false, nil
+	}
+	state.LastStep = step
+	r.states[userId] = state
+	return true, nil
+}
+
+func (r *twoFactorRepo) ReplaceRecoveryCodes(userId int, codeHashes []string) error {
+	r.recovery[userId] = make(map[string]bool)
+	for _, hash := range codeHashes {
+		r.recovery[userId][hash] = false
+	}
+	return nil
+}
+
+func (r *twoFactorRepo) UseRecoveryCode(userId int, codeHash string) (bool, error) {
+	used, ok := r.recovery[userId][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	r.recovery[userId][codeHash] = true
+	return true, nil
+}
+
+func (r *twoFactorRepo) DisableTwoFactor(userId int) error {
+	delete(r.states, userId)
+	delete(r.recovery, userId)
+	return nil
+}
+
+// Код, который сейчас показывает приложение-аутентификатор
+func currentCode(t *testing.T, s *AuthService, secret string) string {
+	key, err := totpEncoding.DecodeString(secret)
+	require.NoError(t, err)
+	return totpCode(key, s.now().Unix()/totpPeriod)
+}
+
+// Сдвигает часы сервиса на следующий шаг TOTP
+func nextStep(s *AuthService) {
+	now := s.now().Add(totpPeriod * time.Second)
+	s.now = func() time.Time { return now }
+}
+
+func enableTwoFactor(t *testing.T, s *AuthService) (string, []string) {
+	enrollment, err := s.EnrollTwoFactor(1)
+	require.NoError(t, err)
+
+	codes, err := s.ConfirmTwoFactor(1, currentCode(t, s, enrollment.Secret))
+	require.NoError(t, err)
+	require.Len(t, codes.Codes, recoveryCodeCount)
+
+	nextStep(s)
+	return enrollment.Secret, codes.Codes
+}
+
+func signInChallenge(t *testing.T, s *AuthService) string {
+	result, err := s.GenerateToken("alice", "secret")
+	require.NoError(t, err)
+	require.Nil(t, result.TokenPair)
+	require.NotNil(t, result.TwoFactorChallenge)
+	assert.True(t, result.TwoFactorRequired)
+	return result.ChallengeToken
+}
+
+func TestTOTP_RFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	secret := totpEncoding.EncodeToString(key)
+
+	for unix, code := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		assert.Equal(t, code, totpCode(key, unix/totpPeriod))
+
+		step, ok := validateTOTP(secret, code, time.Unix(unix+totpPeriod, 0))
+		assert.True(t, ok, "previous step is accepted")
+		assert.Equal(t, unix/totpPeriod, step)
+
+		_, ok = validateTOTP(secret, code, time.Unix(unix+3*totpPeriod, 0))
+		assert.False(t, ok)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	u, err := url.Parse(totpURI("Talk Together", "alice", "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Talk Together:alice", u.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	assert.Equal(t, "Talk Together", u.Query().Get("issuer"))
+}
+
+func TestTwoFactor_EnrollmentAndSignIn(t *testing.T) {
+	s, _ := newTestAuthService(t)
+
+	enrollment, err := s.EnrollTwoFactor(1)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/Talk%20Together:alice?"))
+
+	// До подтверждения вход не требует второго фактора
+	result, err := s.GenerateToken("alice", "secret")
+	require.NoError(t, err)
+	assert.NotNil(t, result.TokenPair)
+
+	_, err = s.ConfirmTwoFactor(1, "000000")
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	code := currentCode(t, s, enrollment.Secret)
+	_, err = s.ConfirmTwoFactor(1, code)
+	require.NoError(t, err)
+
+	_, err = s.EnrollTwoFactor(1)
+	assert.ErrorIs(t, err, ErrTwoFactorEnabled)
+
+	challenge := signInChallenge(t, s)
+
+	// Код, использованный при подтверждении, повторно не принимается
+	_, err = s.VerifyTwoFactor(challenge, code)
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	nextStep(s)
+	tokens, err := s.VerifyTwoFactor(challenge, currentCode(t, s, enrollment.Secret))
+	require.NoError(t, err)
+
+	access, err := s.ParseToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, 1, access.User)
+
+	_, err = s.VerifyTwoFactor(challenge, currentCode(t, s, enrollment.Secret))
+	assert.ErrorIs(t, err, ErrInvalidChallenge)
+}
+
+func TestTwoFactor_RecoveryCodeIsOneTime(t *testing.T) {
+	s, _ := newTestAuthService(t)
+	_, codes := enableTwoFactor(t, s)
+
+	_, err := s.VerifyTwoFactor(signInChallenge(t, s), " "+strings.ToUpper(codes[0])+" ")
+	require.NoError(t, err)
+
+	_, err = s.VerifyTwoFactor(signInChallenge(t, s), codes[0])
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	_, err = s.VerifyTwoFactor(signInChallenge(t, s), codes[1])
+	assert.NoError(t, err)
+}
+
+// Запрос, которому не достался вызов, не тратит код восстановления
+func TestTwoFactor_ChallengeTakenBeforeCodeIsSpent(t *testing.T) {
+	s, _ := newTestAuthService(t)
+	_, codes := enableTwoFactor(t, s)
+	challenge := signInChallenge(t, s)
+
+	_, err := s.VerifyTwoFactor(challenge, codes[0])
+	require.NoError(t, err)
+
+	_, err = s.VerifyTwoFactor(challenge, codes[1])
+	assert.ErrorIs(t, err, ErrInvalidChallenge)
+
+	_, err = s.VerifyTwoFactor(signInChallenge(t, s), codes[1])
+	assert.NoError(t, err, "code of the losing request stays valid")
+}
+
+func TestTwoFactor_RegenerateRequiresAuthenticatorCode(t *testing.T) {
+	s, _ := newTestAuthService(t)
+	secret, codes := enableTwoFactor(t, s)
+
+	_, err := s.RegenerateRecoveryCodes(1, codes[0])
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	fresh, err := s.RegenerateRecoveryCodes(1, currentCode(t, s, secret))
+	require.NoError(t, err)
+
+	_, err = s.VerifyTwoFactor(signInChallenge(t, s), codes[1])
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode, "old codes are replaced")
+
+	_, err = s.VerifyTwoFactor(signInChallenge(t, s), fresh.Codes[0])
+	assert.NoError(t, err)
+}
+
+func TestTwoFactor_LockoutAfterFailedAttempts(t *testing.T) {
+	s, _ := newTestAuthService(t)
+	secret, _ := enableTwoFactor(t, s)
+
+	for i := 0; i < s.twoFactorCfg.MaxAttempts; i++ {
+		_, err := s.VerifyTwoFactor(signInChallenge(t, s), "000000")
+		assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+	}
+
+	_, err := s.VerifyTwoFactor(signInChallenge(t, s), currentCode(t, s, secret))
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+}
+
+func TestTwoFactor_LockoutCountsConcurrentAttempts(t *testing.T) {
+	s, _ := newTestAuthService(t)
+	secret, _ := enableTwoFactor(t, s)
+
+	// Попытки, начатые до завершения проверки остальных, уже занимают место в лимите
+	for i := 0; i < s.twoFactorCfg.MaxAttempts; i++ {
+		_, err := s.challenges.Incr(twoFactorAttemptsPrefix+"1", s.twoFactorCfg.LockoutWindow)
+		require.NoError(t, err)
+	}
+
+	_, err := s.VerifyTwoFactor(signInChallenge(t, s), currentCode(t, s, secret))
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+}
+
+func TestTwoFactor_DisableAndAdminReset(t *testing.T) {
+	s, denied := newTestAuthService(t)
+	s.repo.(*userRepo).admins = map[int]bool{2: true}
+	secret, codes := enableTwoFactor(t, s)
+
+	tokens, err := s.VerifyTwoFactor(signInChallenge(t, s), currentCode(t, s, secret))
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.DisableTwoFactor(1, "000000"), ErrInvalidTwoFactorCode)
+	require.NoError(t, s.DisableTwoFactor(1, codes[0]))
+	assert.ErrorIs(t, s.DisableTwoFactor(1, codes[1]), ErrTwoFactorNotEnabled)
+
+	_, err = s.ParseToken(tokens.AccessToken)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+
+	secret, _ = enableTwoFactor(t, s)
+	tokens, err = s.VerifyTwoFactor(signInChallenge(t, s), currentCode(t, s, secret))
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.ResetTwoFactor(1, 1), ErrNotAdmin)
+	require.NoError(t, s.ResetTwoFactor(2, 1))
+
+	_, err = s.RefreshToken(tokens.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	assert.Len(t, denied.published, 2)
+
+	result, err := s.GenerateToken("alice", "secret")
+	require.NoError(t, err)
+	assert.NotNil(t, result.TokenPair)
+	assert.Nil(t, result.TwoFactorChallenge)
+}
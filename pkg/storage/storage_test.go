@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func roundTrip(t *testing.T, store BlobStore) {
+	ctx := context.Background()
+	key := "attachments/1/file name.txt"
+
+	err := store.Put(ctx, key, strings.NewReader("hello"), 5, "text/plain")
+	assert.NoError(t, err)
+
+	reader, err := store.Get(ctx, key)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	assert.Equal(t, "hello", string(data))
+
+	assert.NoError(t, store.Delete(ctx, key))
+	assert.NoError(t, store.Delete(ctx, key), "deleting a missing blob is not an error")
+
+	_, err = store.Get(ctx, key)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
+
+func TestLocalStore_RoundTrip(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+
+	roundTrip(t, store)
+}
+
+func TestLocalStore_KeyStaysInRoot(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewLocalStore(root)
+	assert.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(store.path("../../etc/passwd"), root))
+}
+
+// Заглушка S3-совместимого хранилища в памяти, проверяет наличие подписи V4
+type s3Stub struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=access/") ||
+		!strings.Contains(auth, "/us-east-1/s3/aws4_request") ||
+		r.Header.Get("X-Amz-Date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		s.objects[r.URL.Path] = data
+		s.types[r.URL.Path] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		data, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3Store_RoundTrip(t *testing.T) {
+	stub := &s3Stub{objects: make(map[string][]byte), types: make(map[string]string)}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	store := NewS3Store(S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "chat",
+		Region:    "us-east-1",
+		AccessKey: "access",
+		SecretKey: "secret",
+	})
+
+	roundTrip(t, store)
+
+	assert.NoError(t, store.Put(context.Background(), "a/b.png", strings.NewReader("png"), 3, "image/png"))
+	assert.Equal(t, "image/png", stub.types["/chat/a/b.png"])
+}
+
+func TestS3Store_RejectedRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	store := NewS3Store(S3Config{Endpoint: server.URL, Bucket: "chat", Region: "us-east-1"})
+
+	err := store.Put(context.Background(), "key", strings.NewReader("x"), 1, "")
+	assert.ErrorContains(t, err, "AccessDenied")
+}
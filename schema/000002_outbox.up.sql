@@ -0,0 +1,13 @@
+CREATE TABLE outbox
+(
+    id bigserial not null unique,
+    aggregate_id int not null,
+    event_type varchar(64) not null,
+    payload jsonb not null,
+    attempts int not null default 0,
+    last_error text,
+    created_at timestamp default current_timestamp,
+    sent_at timestamp
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (id) WHERE sent_at IS NULL;
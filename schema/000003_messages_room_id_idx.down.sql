@@ -0,0 +1 @@
+DROP INDEX messages_room_id_id_idx;
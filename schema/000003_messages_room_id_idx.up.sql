@@ -0,0 +1 @@
+CREATE INDEX messages_room_id_id_idx ON messages (room_id, id);
@@ -0,0 +1,10 @@
+CREATE TABLE read_cursors
+(
+    user_id int references users(id) on delete cascade not null,
+    room_id int references rooms(id) on delete cascade not null,
+    last_read_message_id int not null,
+    updated_at timestamp default current_timestamp,
+    primary key (user_id, room_id)
+);
+
+CREATE INDEX read_cursors_room_id_idx ON read_cursors (room_id);
@@ -0,0 +1,3 @@
+DROP TABLE room_members;
+
+ALTER TABLE rooms DROP COLUMN visibility;
@@ -0,0 +1,15 @@
+ALTER TABLE rooms ADD COLUMN visibility varchar(16) not null default 'public'
+    check (visibility in ('public', 'private', 'invite_only'));
+
+CREATE TABLE room_members
+(
+    room_id int references rooms(id) on delete cascade not null,
+    user_id int references users(id) on delete cascade not null,
+    joined_at timestamp default current_timestamp,
+    primary key (room_id, user_id)
+);
+
+CREATE INDEX room_members_user_id_idx ON room_members (user_id);
+
+INSERT INTO room_members (room_id, user_id)
+SELECT id, created_by FROM rooms;
@@ -0,0 +1,5 @@
+DROP TABLE pinned_messages;
+
+DROP TABLE room_bans;
+
+ALTER TABLE room_members DROP COLUMN role;
@@ -0,0 +1,24 @@
+ALTER TABLE room_members ADD COLUMN role varchar(16) not null default 'member'
+    check (role in ('owner', 'moderator', 'member', 'read_only'));
+
+UPDATE room_members rm SET role = 'owner'
+FROM rooms r
+WHERE r.id = rm.room_id AND r.created_by = rm.user_id;
+
+CREATE TABLE room_bans
+(
+    room_id int references rooms(id) on delete cascade not null,
+    user_id int references users(id) on delete cascade not null,
+    banned_by int references users(id) on delete set null,
+    created_at timestamp default current_timestamp,
+    primary key (room_id, user_id)
+);
+
+CREATE TABLE pinned_messages
+(
+    room_id int references rooms(id) on delete cascade not null,
+    message_id int references messages(id) on delete cascade not null,
+    pinned_by int references users(id) on delete set null,
+    pinned_at timestamp default current_timestamp,
+    primary key (room_id, message_id)
+);
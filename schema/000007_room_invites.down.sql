@@ -0,0 +1,3 @@
+DROP TABLE room_invite_acceptances;
+
+DROP TABLE room_invites;
@@ -0,0 +1,22 @@
+CREATE TABLE room_invites
+(
+    id serial primary key,
+    room_id int references rooms(id) on delete cascade not null,
+    code varchar(32) not null unique,
+    created_by int references users(id) on delete cascade not null,
+    max_uses int check (max_uses > 0),
+    uses int not null default 0,
+    expires_at timestamp,
+    revoked_at timestamp,
+    created_at timestamp default current_timestamp
+);
+
+CREATE INDEX room_invites_room_id_idx ON room_invites (room_id);
+
+CREATE TABLE room_invite_acceptances
+(
+    invite_id int references room_invites(id) on delete cascade not null,
+    user_id int references users(id) on delete cascade not null,
+    accepted_at timestamp default current_timestamp,
+    primary key (invite_id, user_id)
+);
@@ -0,0 +1,8 @@
+DELETE FROM rooms WHERE kind <> 'room';
+
+DROP INDEX rooms_name_key;
+ALTER TABLE rooms ADD CONSTRAINT rooms_name_key UNIQUE (name);
+
+ALTER TABLE rooms DROP COLUMN participant_key;
+
+ALTER TABLE rooms DROP COLUMN kind;
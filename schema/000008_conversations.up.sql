@@ -0,0 +1,7 @@
+ALTER TABLE rooms ADD COLUMN kind varchar(16) not null default 'room'
+    check (kind in ('room', 'direct', 'group'));
+
+ALTER TABLE rooms ADD COLUMN participant_key varchar(255) unique;
+
+ALTER TABLE rooms DROP CONSTRAINT rooms_name_key;
+CREATE UNIQUE INDEX rooms_name_key ON rooms (name) WHERE kind = 'room';
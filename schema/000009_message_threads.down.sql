@@ -0,0 +1,5 @@
+DROP INDEX messages_parent_id_idx;
+
+ALTER TABLE messages DROP COLUMN last_reply_at;
+ALTER TABLE messages DROP COLUMN reply_count;
+ALTER TABLE messages DROP COLUMN parent_id;
@@ -0,0 +1,5 @@
+ALTER TABLE messages ADD COLUMN parent_id int references messages(id) on delete cascade;
+ALTER TABLE messages ADD COLUMN reply_count int not null default 0;
+ALTER TABLE messages ADD COLUMN last_reply_at timestamp;
+
+CREATE INDEX messages_parent_id_idx ON messages (parent_id, id) WHERE parent_id IS NOT NULL;
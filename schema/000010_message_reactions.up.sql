@@ -0,0 +1,8 @@
+CREATE TABLE message_reactions
+(
+    message_id int references messages(id) on delete cascade not null,
+    user_id int references users(id) on delete cascade not null,
+    emoji varchar(64) not null,
+    created_at timestamp default current_timestamp,
+    primary key (message_id, user_id, emoji)
+);
@@ -0,0 +1,14 @@
+ALTER TABLE messages ADD COLUMN edited_at timestamp;
+ALTER TABLE messages ADD COLUMN deleted_at timestamp;
+ALTER TABLE messages ADD COLUMN deleted_by int references users(id) on delete set null;
+
+CREATE TABLE message_revisions
+(
+    id serial primary key,
+    message_id int references messages(id) on delete cascade not null,
+    content text not null,
+    edited_by int references users(id) on delete set null,
+    created_at timestamp default current_timestamp
+);
+
+CREATE INDEX message_revisions_message_id_idx ON message_revisions (message_id, id);
@@ -0,0 +1,27 @@
+CREATE TABLE attachments
+(
+    id serial primary key,
+    room_id int references rooms(id) on delete cascade not null,
+    user_id int references users(id) on delete cascade not null,
+    message_id int references messages(id) on delete set null,
+    filename varchar(255) not null,
+    content_type varchar(255) not null,
+    size bigint not null,
+    storage_key varchar(255) not null,
+    thumbnail_key varchar(255),
+    created_at timestamp default current_timestamp
+);
+
+CREATE INDEX attachments_message_id_idx ON attachments (message_id) WHERE message_id IS NOT NULL;
+
+CREATE TABLE attachment_uploads
+(
+    id varchar(32) primary key,
+    room_id int references rooms(id) on delete cascade not null,
+    user_id int references users(id) on delete cascade not null,
+    filename varchar(255) not null,
+    size bigint not null,
+    received bigint not null default 0,
+    parts int not null default 0,
+    created_at timestamp default current_timestamp
+);
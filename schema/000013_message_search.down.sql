@@ -0,0 +1,3 @@
+DROP INDEX messages_search_vector_idx;
+
+ALTER TABLE messages DROP COLUMN search_vector;
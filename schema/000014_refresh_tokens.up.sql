@@ -0,0 +1,14 @@
+CREATE TABLE refresh_tokens
+(
+    id serial primary key,
+    user_id int references users(id) on delete cascade not null,
+    session_id varchar(32) not null,
+    token_hash varchar(64) not null unique,
+    expires_at timestamp not null,
+    used_at timestamp,
+    revoked_at timestamp,
+    created_at timestamp default current_timestamp
+);
+
+CREATE INDEX refresh_tokens_session_id_idx ON refresh_tokens (session_id);
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
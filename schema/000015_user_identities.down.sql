@@ -0,0 +1,2 @@
+DROP INDEX users_lower_email_idx;
+DROP TABLE user_identities;
@@ -0,0 +1,13 @@
+CREATE TABLE user_identities
+(
+    id serial primary key,
+    user_id int references users(id) on delete cascade not null,
+    provider varchar(64) not null,
+    subject varchar(255) not null,
+    email varchar(255) not null,
+    created_at timestamp default current_timestamp,
+    unique (provider, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
+CREATE INDEX users_lower_email_idx ON users (lower(email));
@@ -0,0 +1,3 @@
+DROP TABLE admins;
+DROP TABLE recovery_codes;
+DROP TABLE user_two_factor;
@@ -0,0 +1,24 @@
+CREATE TABLE user_two_factor
+(
+    user_id int primary key references users(id) on delete cascade,
+    secret varchar(64) not null,
+    enabled_at timestamp,
+    last_step bigint not null default 0,
+    created_at timestamp default current_timestamp
+);
+
+CREATE TABLE recovery_codes
+(
+    id serial primary key,
+    user_id int references users(id) on delete cascade not null,
+    code_hash varchar(64) not null,
+    used_at timestamp,
+    created_at timestamp default current_timestamp,
+    unique (user_id, code_hash)
+);
+
+CREATE TABLE admins
+(
+    user_id int primary key references users(id) on delete cascade,
+    created_at timestamp default current_timestamp
+);
@@ -0,0 +1,3 @@
+DROP INDEX users_verified_email_idx;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users DROP COLUMN email_verified;
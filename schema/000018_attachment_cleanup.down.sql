@@ -0,0 +1,2 @@
+DROP INDEX attachment_uploads_created_at_idx;
+DROP INDEX attachments_unused_idx;
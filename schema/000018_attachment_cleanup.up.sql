@@ -0,0 +1,2 @@
+CREATE INDEX attachments_unused_idx ON attachments (created_at) WHERE message_id IS NULL;
+CREATE INDEX attachment_uploads_created_at_idx ON attachment_uploads (created_at);
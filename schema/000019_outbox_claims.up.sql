@@ -0,0 +1 @@
+ALTER TABLE outbox ADD COLUMN claimed_until timestamp;
@@ -0,0 +1,8 @@
+DROP INDEX users_email_idx;
+
+ALTER TABLE users ADD COLUMN email_verified boolean not null default false;
+
+UPDATE users SET email_verified = true
+WHERE id IN (SELECT user_id FROM user_identities) AND password_hash IS NULL;
+
+CREATE UNIQUE INDEX users_verified_email_idx ON users (lower(email)) WHERE email_verified;
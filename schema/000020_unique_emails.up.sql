@@ -0,0 +1,4 @@
+DROP INDEX users_verified_email_idx;
+ALTER TABLE users DROP COLUMN email_verified;
+
+CREATE UNIQUE INDEX users_email_idx ON users (lower(email));